@@ -0,0 +1,46 @@
+package auth
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// emails of users who are made admins when they sign in
+var admins = map[string]bool{}
+
+// SetAdmins sets the users who are made admins when they sign in from a comma
+// separated list of emails. Other users can be made admins on /admin/users.
+func SetAdmins(emails string) {
+	admins = map[string]bool{}
+	for _, email := range strings.Split(emails, ",") {
+		email = strings.ToLower(strings.TrimSpace(email))
+		if email != "" {
+			admins[email] = true
+		}
+	}
+}
+
+// IsAdmin reports whether the signed in user can use the admin pages. With
+// auth disabled there's only one user, so they're always an admin.
+func IsAdmin(r *http.Request) bool {
+	if disableAuth {
+		return true
+	}
+
+	admin, _ := r.Context().Value(userAdmin{}).(bool)
+	return admin
+}
+
+// AdminMiddleware authenticates the user like Middleware, then only lets admins through
+func AdminMiddleware(next func(w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request) {
+	return Middleware(func(w http.ResponseWriter, r *http.Request) {
+		if !IsAdmin(r) {
+			slog.WarnContext(r.Context(), "Non-admin user denied")
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		next(w, r)
+	})
+}
@@ -2,21 +2,82 @@ package auth
 
 import (
 	"context"
-	"encoding/base64"
-	"encoding/json"
-	"log"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
 	"net/http"
+	"net/url"
 	"strings"
 
 	"github.com/jccroft1/goshowtrack/db"
+	"github.com/jccroft1/goshowtrack/logging"
 )
 
 var (
 	disableAuth bool
+	verifier    *jwtVerifier
+	oidc        *oidcProvider
+	// cookies are only sent over HTTPS when the app is served over it
+	secureCookies bool
 )
 
-func Setup(_disableAuth bool) {
-	disableAuth = _disableAuth
+// Options configures how users sign in, through Cloudflare Access, an OIDC
+// provider or both
+type Options struct {
+	// Disable signs everyone in as a single user
+	Disable bool
+	// TeamDomain is the Cloudflare Access team, e.g. "myteam.cloudflareaccess.com",
+	// and Audience the application's AUD tag
+	TeamDomain string
+	Audience   string
+	// OIDC is used when its Issuer is set
+	OIDC OIDCConfig
+	// Secret signs CSRF tokens and session cookies, a random one is used if it's empty
+	Secret string
+	// Admins is a comma separated list of emails made admins when they sign in
+	Admins string
+	// SignupAllowlist limits who can sign up, see SetSignupAllowlist
+	SignupAllowlist string
+}
+
+// Setup configures authentication
+func Setup(options Options) error {
+	err := setupCSRF(options.Secret)
+	if err != nil {
+		return err
+	}
+
+	SetAdmins(options.Admins)
+	SetSignupAllowlist(options.SignupAllowlist)
+
+	disableAuth = options.Disable
+	verifier = nil
+	oidc = nil
+	if disableAuth {
+		return nil
+	}
+
+	if options.TeamDomain != "" || options.Audience != "" {
+		if options.TeamDomain == "" || options.Audience == "" {
+			return errors.New("Cloudflare Access needs both a team domain and audience")
+		}
+		verifier = newCloudflareVerifier(options.TeamDomain, options.Audience)
+	}
+
+	if options.OIDC.Issuer != "" {
+		if options.OIDC.ClientID == "" || options.OIDC.RedirectURL == "" {
+			return errors.New("OIDC needs a client ID and redirect URL")
+		}
+		oidc = newOIDCProvider(options.OIDC)
+		secureCookies = strings.HasPrefix(options.OIDC.RedirectURL, "https://")
+	}
+
+	if verifier == nil && oidc == nil {
+		return errors.New("Cloudflare Access or OIDC must be configured unless auth is disabled")
+	}
+
+	return nil
 }
 
 func Validate(req *http.Request) (int64, string, bool) {
@@ -24,70 +85,67 @@ func Validate(req *http.Request) (int64, string, bool) {
 		return 1, "john.doe@example.com", true
 	}
 
-	jwt := req.Header.Get("Cf-Access-Jwt-Assertion")
-	if jwt == "" {
-		log.Println("No JWT provided")
-		return 0, "", false
+	if oidc != nil {
+		s, ok := readSession(req)
+		if ok {
+			return s.UserID, s.Email, true
+		}
 	}
 
-	// Split the JWT: header.payload.signature
-	parts := strings.Split(jwt, ".")
-	if len(parts) != 3 {
-		log.Println("Invalid JWT format")
+	if verifier == nil {
 		return 0, "", false
 	}
 
-	// Decode the payload (2nd part)
-	payloadBytes, err := base64.RawURLEncoding.DecodeString(parts[1])
-	if err != nil {
-		log.Println("Failed to decode payload")
+	jwt := req.Header.Get("Cf-Access-Jwt-Assertion")
+	if jwt == "" {
+		slog.DebugContext(req.Context(), "No JWT provided")
 		return 0, "", false
 	}
 
-	// Parse payload JSON
-	var payload map[string]interface{}
-	if err := json.Unmarshal(payloadBytes, &payload); err != nil {
-		log.Println("Invalid JSON in payload")
+	claims, err := verifier.Verify(jwt)
+	if err != nil {
+		slog.WarnContext(req.Context(), "Invalid JWT", "err", err)
 		return 0, "", false
 	}
 
 	// Get email
-	email, ok := payload["email"].(string)
-	if !ok {
-		log.Println("Email not found in token")
+	email := claims.Email
+	if email == "" {
+		slog.WarnContext(req.Context(), "Email not found in token")
 		return 0, "", false
 	}
 
-	// Store user if new
-	result, err := db.Connection.Exec(`INSERT OR IGNORE INTO users (email) VALUES (?)`, email)
-	if err != nil {
-		log.Println("Failed to insert user", err)
-		return 0, "", false
-	}
-	rowsAffected, err := result.RowsAffected()
+	userID, err := userIDForEmail(email)
 	if err != nil {
-		log.Println("Failed to get RowsAffected", err)
+		slog.WarnContext(req.Context(), "Failed to find user", "err", err)
 		return 0, "", false
 	}
 
+	return userID, email, true
+}
+
+// userIDForEmail returns the user's ID, storing them if they're new and
+// allowed to sign up
+func userIDForEmail(email string) (int64, error) {
 	var userID int64
-	if rowsAffected > 0 {
-		// New user inserted, get the ID
-		userID, err = result.LastInsertId()
-		if err != nil {
-			log.Println("Failed to get last insert UserID", err)
-			return 0, "", false
-		}
-	} else {
-		// User already exists, fetch ID
-		err = db.Connection.QueryRow(`SELECT id FROM users WHERE email = ?`, email).Scan(&userID)
-		if err != nil {
-			log.Println("Failed to fetch existing UserID", err)
-			return 0, "", false
-		}
+	err := db.Connection.QueryRow(`SELECT id FROM users WHERE email = ?`, email).Scan(&userID)
+	if err == nil {
+		return userID, nil
+	}
+	if err != sql.ErrNoRows {
+		return 0, fmt.Errorf("failed to fetch existing UserID: %v", err)
 	}
 
-	return userID, email, true
+	if !signupAllowed(email) {
+		return 0, errSignupClosed
+	}
+
+	err = db.Connection.QueryRow(`INSERT INTO users (email) VALUES (?) RETURNING id`, email).Scan(&userID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert user: %v", err)
+	}
+
+	return userID, nil
 }
 
 type userEmail struct{}
@@ -106,16 +164,98 @@ func GetUserID(r *http.Request) (int64, bool) {
 
 func Middleware(next func(w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request) {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token := bearerToken(r); token != "" && !disableAuth {
+			tokenMiddleware(next, token, w, r)
+			return
+		}
+
 		id, email, ok := Validate(r)
+		if !ok && oidc != nil && r.Method == http.MethodGet && !strings.HasPrefix(r.URL.Path, "/api/") {
+			// send people to sign in, API clients get the error
+			http.Redirect(w, r, "/auth/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusFound)
+			return
+		}
 		if !ok {
-			log.Println("Invalid user credentials", r.URL)
+			slog.InfoContext(r.Context(), "Invalid user credentials")
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
 
-		ctx := context.WithValue(r.Context(), userEmail{}, email)
-		ctx = context.WithValue(ctx, userID{}, id)
+		admin, ok := allowUser(w, r, id, email)
+		if !ok {
+			return
+		}
 
-		next(w, r.WithContext(ctx))
+		if !safeMethod(r.Method) && !checkCSRF(w, r, id) {
+			slog.WarnContext(r.Context(), "Invalid CSRF token")
+			http.Error(w, "Invalid CSRF token, reload the page and try again", http.StatusForbidden)
+			return
+		}
+		w.Header().Set(CSRFHeader, csrfToken(id))
+
+		next(w, r.WithContext(withUser(r.Context(), id, email, admin)))
 	})
 }
+
+// tokenMiddleware authenticates a request with an API token. Browsers never
+// send the header by themselves, so these requests don't need a CSRF token.
+func tokenMiddleware(next func(w http.ResponseWriter, r *http.Request), token string, w http.ResponseWriter, r *http.Request) {
+	id, email, scope, err := validateToken(token)
+	if err != nil {
+		slog.WarnContext(r.Context(), "Invalid API token", "err", err)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if scope != ScopeReadWrite && !safeMethod(r.Method) {
+		http.Error(w, "API token is read-only", http.StatusForbidden)
+		return
+	}
+
+	admin, ok := allowUser(w, r, id, email)
+	if !ok {
+		return
+	}
+
+	next(w, r.WithContext(withUser(r.Context(), id, email, admin)))
+}
+
+// allowUser checks an authenticated user hasn't been disabled or deleted,
+// writing the error if they have
+func allowUser(w http.ResponseWriter, r *http.Request, id int64, email string) (bool, bool) {
+	if disableAuth {
+		return true, true
+	}
+
+	admin, err := checkUser(id, email)
+	if errors.Is(err, errUserDisabled) {
+		slog.WarnContext(r.Context(), "Disabled user denied", "user_id", id)
+		http.Error(w, "Your account has been disabled", http.StatusForbidden)
+		return false, false
+	}
+	if errors.Is(err, errUnknownUser) {
+		// deleted while they were signed in, they can sign in again to start over
+		slog.WarnContext(r.Context(), "Unknown user denied", "user_id", id)
+		clearCookie(w, sessionCookie)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return false, false
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to check user", "err", err)
+		http.Error(w, "Error querying database", http.StatusInternalServerError)
+		return false, false
+	}
+
+	return admin, true
+}
+
+type userAdmin struct{}
+
+// withUser stores the user in the request's context, and records them in
+// the access log
+func withUser(ctx context.Context, id int64, email string, admin bool) context.Context {
+	logging.SetUserID(ctx, id)
+	ctx = context.WithValue(ctx, userEmail{}, email)
+	ctx = context.WithValue(ctx, userAdmin{}, admin)
+	return context.WithValue(ctx, userID{}, id)
+}
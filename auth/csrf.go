@@ -0,0 +1,78 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"log/slog"
+	"net/http"
+)
+
+// CSRF tokens are an HMAC of the user's ID, so they don't need storing and
+// any page's token works for any form until the secret changes.
+
+const (
+	// CSRFField is the form field forms post the token in
+	CSRFField = "csrf_token"
+	// CSRFHeader carries the token for API clients, it's set on every
+	// authenticated response so clients can pick it up from any GET
+	CSRFHeader = "X-CSRF-Token"
+
+	// largest request body read when looking for the token in a form
+	maxFormSize = 32 << 20
+)
+
+var csrfSecret []byte
+
+func setupCSRF(secret string) error {
+	if secret != "" {
+		csrfSecret = []byte(secret)
+		return nil
+	}
+
+	slog.Warn("AUTH_SECRET not set, forms opened before a restart will need reloading")
+	csrfSecret = make([]byte, 32)
+	_, err := rand.Read(csrfSecret)
+	if err != nil {
+		return fmt.Errorf("failed to generate CSRF secret: %v", err)
+	}
+	return nil
+}
+
+func csrfToken(userID int64) string {
+	mac := hmac.New(sha256.New, csrfSecret)
+	fmt.Fprintf(mac, "csrf:%d", userID)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// CSRFToken returns the token state changing requests from this user must include
+func CSRFToken(r *http.Request) string {
+	id, ok := GetUserID(r)
+	if !ok {
+		return ""
+	}
+	return csrfToken(id)
+}
+
+// safeMethod reports whether the method can't change anything, so it doesn't need a token
+func safeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// checkCSRF looks for the user's token in the header, then the form
+func checkCSRF(w http.ResponseWriter, r *http.Request, userID int64) bool {
+	token := r.Header.Get(CSRFHeader)
+	if token == "" {
+		r.Body = http.MaxBytesReader(w, r.Body, maxFormSize)
+		err := r.ParseMultipartForm(maxFormSize)
+		if err != nil && err != http.ErrNotMultipart {
+			slog.WarnContext(r.Context(), "Failed to parse form", "err", err)
+			return false
+		}
+		token = r.PostFormValue(CSRFField)
+	}
+
+	return hmac.Equal([]byte(token), []byte(csrfToken(userID)))
+}
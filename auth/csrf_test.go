@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestCSRFToken(t *testing.T) {
+	err := setupCSRF("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if csrfToken(1) != csrfToken(1) {
+		t.Error("token for the same user changed")
+	}
+	if csrfToken(1) == csrfToken(2) {
+		t.Error("different users have the same token")
+	}
+
+	token := csrfToken(1)
+	setupCSRF("other secret")
+	if csrfToken(1) == token {
+		t.Error("token didn't change with the secret")
+	}
+}
+
+func TestMiddlewareCSRF(t *testing.T) {
+	err := Setup(Options{Disable: true, Secret: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// disabled auth always signs in user 1
+	token := csrfToken(1)
+
+	tests := []struct {
+		name string
+		req  func() *http.Request
+		want int
+	}{
+		{"GET without token", func() *http.Request {
+			return httptest.NewRequest("GET", "/show/watched?show_id=1&season=1", nil)
+		}, http.StatusOK},
+		{"POST without token", func() *http.Request {
+			return formRequest("POST", url.Values{"show_id": {"1"}})
+		}, http.StatusForbidden},
+		{"POST with wrong token", func() *http.Request {
+			return formRequest("POST", url.Values{CSRFField: {csrfToken(2)}})
+		}, http.StatusForbidden},
+		{"POST with form token", func() *http.Request {
+			return formRequest("POST", url.Values{CSRFField: {token}})
+		}, http.StatusOK},
+		{"DELETE without token", func() *http.Request {
+			return httptest.NewRequest("DELETE", "/api/v1/list/1", nil)
+		}, http.StatusForbidden},
+		{"DELETE with header token", func() *http.Request {
+			req := httptest.NewRequest("DELETE", "/api/v1/list/1", nil)
+			req.Header.Set(CSRFHeader, token)
+			return req
+		}, http.StatusOK},
+	}
+
+	handler := Middleware(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler(rec, tt.req())
+
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if rec.Code == http.StatusOK && rec.Header().Get(CSRFHeader) != token {
+				t.Errorf("%s header = %q, want the user's token", CSRFHeader, rec.Header().Get(CSRFHeader))
+			}
+		})
+	}
+}
+
+func formRequest(method string, form url.Values) *http.Request {
+	req := httptest.NewRequest(method, "/show/watched", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req
+}
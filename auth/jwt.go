@@ -0,0 +1,249 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// how long fetched keys are trusted before they're fetched again
+	keysMaxAge = time.Hour
+	// minimum time between fetches caused by an unknown key ID
+	keysMinRefetch = time.Minute
+	// allowed clock difference when checking exp and nbf
+	clockLeeway = time.Minute
+)
+
+// jwtVerifier checks RS256 signed JWTs against a JWKS endpoint, caching the
+// keys and fetching them again when they expire or an unknown key is seen
+type jwtVerifier struct {
+	issuer   string
+	audience string
+	jwksURL  string
+	client   *http.Client
+
+	mu        sync.Mutex
+	keys      map[string]*rsa.PublicKey
+	fetchedAt time.Time
+}
+
+func newJWTVerifier(issuer string, audience string, jwksURL string) *jwtVerifier {
+	return &jwtVerifier{
+		issuer:   issuer,
+		audience: audience,
+		jwksURL:  jwksURL,
+		client: &http.Client{
+			Timeout: 5 * time.Second,
+		},
+	}
+}
+
+// newCloudflareVerifier creates a verifier for a Cloudflare Access team domain,
+// e.g. "myteam.cloudflareaccess.com"
+func newCloudflareVerifier(teamDomain string, audience string) *jwtVerifier {
+	teamDomain = strings.TrimSuffix(strings.TrimPrefix(teamDomain, "https://"), "/")
+	issuer := "https://" + teamDomain
+	return newJWTVerifier(issuer, audience, issuer+"/cdn-cgi/access/certs")
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// audience can be a single string or a list in the JWT spec
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var single string
+	if json.Unmarshal(b, &single) == nil {
+		*a = audience{single}
+		return nil
+	}
+
+	var list []string
+	err := json.Unmarshal(b, &list)
+	if err != nil {
+		return err
+	}
+	*a = list
+	return nil
+}
+
+type jwtClaims struct {
+	Issuer    string   `json:"iss"`
+	Audience  audience `json:"aud"`
+	Expiry    int64    `json:"exp"`
+	NotBefore int64    `json:"nbf"`
+	Email     string   `json:"email"`
+	// only set by OIDC providers
+	EmailVerified *bool  `json:"email_verified"`
+	Nonce         string `json:"nonce"`
+}
+
+// Verify checks the token's signature, issuer, audience and expiry and returns its claims
+func (v *jwtVerifier) Verify(token string) (*jwtClaims, error) {
+	// Split the JWT: header.payload.signature
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("invalid JWT format")
+	}
+
+	var header jwtHeader
+	err := decodeSegment(parts[0], &header)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JWT header: %v", err)
+	}
+	if header.Alg != "RS256" {
+		return nil, fmt.Errorf("unsupported JWT algorithm %q", header.Alg)
+	}
+
+	key, err := v.key(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid JWT signature encoding: %v", err)
+	}
+
+	hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	err = rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], signature)
+	if err != nil {
+		return nil, errors.New("invalid JWT signature")
+	}
+
+	var claims jwtClaims
+	err = decodeSegment(parts[1], &claims)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JWT payload: %v", err)
+	}
+
+	if claims.Issuer != v.issuer {
+		return nil, fmt.Errorf("unexpected JWT issuer %q", claims.Issuer)
+	}
+
+	validAudience := false
+	for _, aud := range claims.Audience {
+		if aud == v.audience {
+			validAudience = true
+			break
+		}
+	}
+	if !validAudience {
+		return nil, errors.New("JWT audience doesn't match")
+	}
+
+	now := time.Now()
+	if claims.Expiry == 0 || now.After(time.Unix(claims.Expiry, 0).Add(clockLeeway)) {
+		return nil, errors.New("JWT has expired")
+	}
+	if claims.NotBefore != 0 && now.Add(clockLeeway).Before(time.Unix(claims.NotBefore, 0)) {
+		return nil, errors.New("JWT is not valid yet")
+	}
+
+	return &claims, nil
+}
+
+func decodeSegment(segment string, output interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, output)
+}
+
+// key returns the public key for the key ID, fetching the keys if they're
+// stale or the key ID is new (keys are rotated regularly)
+func (v *jwtVerifier) key(kid string) (*rsa.PublicKey, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	age := time.Since(v.fetchedAt)
+	key, ok := v.keys[kid]
+	if ok && age < keysMaxAge {
+		return key, nil
+	}
+
+	if !ok && v.keys != nil && age < keysMinRefetch {
+		return nil, fmt.Errorf("unknown JWT key ID %q", kid)
+	}
+
+	keys, err := v.fetchKeys()
+	if err != nil {
+		if ok {
+			// keep using the old key until the endpoint is back
+			return key, nil
+		}
+		return nil, err
+	}
+	v.keys = keys
+	v.fetchedAt = time.Now()
+
+	key, ok = v.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown JWT key ID %q", kid)
+	}
+	return key, nil
+}
+
+type jwks struct {
+	Keys []struct {
+		Kid string `json:"kid"`
+		Kty string `json:"kty"`
+		N   string `json:"n"`
+		E   string `json:"e"`
+	} `json:"keys"`
+}
+
+func (v *jwtVerifier) fetchKeys() (map[string]*rsa.PublicKey, error) {
+	res, err := v.client.Get(v.jwksURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch JWKS: %v", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch JWKS: status %d", res.StatusCode)
+	}
+
+	var set jwks
+	err = json.NewDecoder(res.Body).Decode(&set)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JWKS: %v", err)
+	}
+
+	keys := map[string]*rsa.PublicKey{}
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modulus for key %q: %v", k.Kid, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exponent for key %q: %v", k.Kid, err)
+		}
+
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+
+	return keys, nil
+}
@@ -0,0 +1,199 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testIssuer   = "https://test.cloudflareaccess.com"
+	testAudience = "test-aud"
+)
+
+// testJWKS serves the public half of its keys like Cloudflare's certs endpoint
+type testJWKS struct {
+	mu       sync.Mutex
+	keys     map[string]*rsa.PrivateKey
+	requests int
+}
+
+func newTestJWKS(t *testing.T, kids ...string) (*testJWKS, *httptest.Server) {
+	t.Helper()
+
+	j := &testJWKS{keys: map[string]*rsa.PrivateKey{}}
+	for _, kid := range kids {
+		j.addKey(t, kid)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		j.mu.Lock()
+		defer j.mu.Unlock()
+		j.requests++
+
+		type key struct {
+			Kid string `json:"kid"`
+			Kty string `json:"kty"`
+			Alg string `json:"alg"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		}
+		var set struct {
+			Keys []key `json:"keys"`
+		}
+		for kid, k := range j.keys {
+			set.Keys = append(set.Keys, key{
+				Kid: kid,
+				Kty: "RSA",
+				Alg: "RS256",
+				N:   base64.RawURLEncoding.EncodeToString(k.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.E)).Bytes()),
+			})
+		}
+		json.NewEncoder(w).Encode(set)
+	}))
+	t.Cleanup(server.Close)
+
+	return j, server
+}
+
+func (j *testJWKS) addKey(t *testing.T, kid string) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	j.mu.Lock()
+	j.keys[kid] = key
+	j.mu.Unlock()
+}
+
+func (j *testJWKS) sign(t *testing.T, kid string, claims map[string]interface{}) string {
+	t.Helper()
+
+	j.mu.Lock()
+	key := j.keys[kid]
+	j.mu.Unlock()
+
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	hash := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hash[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func validClaims() map[string]interface{} {
+	return map[string]interface{}{
+		"iss":   testIssuer,
+		"aud":   []string{testAudience},
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"nbf":   time.Now().Add(-time.Minute).Unix(),
+		"email": "jane@example.com",
+	}
+}
+
+func TestVerify(t *testing.T) {
+	jwks, server := newTestJWKS(t, "key1")
+	verifier := newJWTVerifier(testIssuer, testAudience, server.URL)
+
+	tests := []struct {
+		name    string
+		modify  func(claims map[string]interface{})
+		wantErr bool
+	}{
+		{"valid", func(c map[string]interface{}) {}, false},
+		{"audience as string", func(c map[string]interface{}) { c["aud"] = testAudience }, false},
+		{"wrong audience", func(c map[string]interface{}) { c["aud"] = []string{"other"} }, true},
+		{"wrong issuer", func(c map[string]interface{}) { c["iss"] = "https://evil.cloudflareaccess.com" }, true},
+		{"expired", func(c map[string]interface{}) { c["exp"] = time.Now().Add(-time.Hour).Unix() }, true},
+		{"missing expiry", func(c map[string]interface{}) { delete(c, "exp") }, true},
+		{"not yet valid", func(c map[string]interface{}) { c["nbf"] = time.Now().Add(time.Hour).Unix() }, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := validClaims()
+			tt.modify(claims)
+
+			got, err := verifier.Verify(jwks.sign(t, "key1", claims))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Verify() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got.Email != "jane@example.com" {
+				t.Errorf("Verify() email = %q, want %q", got.Email, "jane@example.com")
+			}
+		})
+	}
+}
+
+func TestVerifyRejectsTampering(t *testing.T) {
+	jwks, server := newTestJWKS(t, "key1")
+	verifier := newJWTVerifier(testIssuer, testAudience, server.URL)
+
+	token := jwks.sign(t, "key1", validClaims())
+
+	// swap in a payload claiming to be someone else, keeping the signature
+	claims := validClaims()
+	claims["email"] = "admin@example.com"
+	payload, _ := json.Marshal(claims)
+	parts := strings.Split(token, ".")
+	forged := parts[0] + "." + base64.RawURLEncoding.EncodeToString(payload) + "." + parts[2]
+
+	_, err := verifier.Verify(forged)
+	if err == nil {
+		t.Fatal("Verify() accepted a forged payload")
+	}
+
+	// unsigned tokens must never be accepted
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","kid":"key1"}`))
+	_, err = verifier.Verify(header + "." + parts[1] + ".")
+	if err == nil {
+		t.Fatal("Verify() accepted an unsigned token")
+	}
+}
+
+func TestVerifyKeyRotation(t *testing.T) {
+	jwks, server := newTestJWKS(t, "key1")
+	verifier := newJWTVerifier(testIssuer, testAudience, server.URL)
+
+	_, err := verifier.Verify(jwks.sign(t, "key1", validClaims()))
+	if err != nil {
+		t.Fatalf("Verify() with first key failed: %v", err)
+	}
+
+	// a new key isn't fetched again straight away
+	jwks.addKey(t, "key2")
+	_, err = verifier.Verify(jwks.sign(t, "key2", validClaims()))
+	if err == nil {
+		t.Fatal("Verify() fetched keys again within the minimum refetch interval")
+	}
+
+	// but it is once the interval has passed
+	verifier.fetchedAt = time.Now().Add(-keysMinRefetch)
+	_, err = verifier.Verify(jwks.sign(t, "key2", validClaims()))
+	if err != nil {
+		t.Fatalf("Verify() with rotated key failed: %v", err)
+	}
+
+	if jwks.requests != 2 {
+		t.Errorf("JWKS fetched %d times, want 2", jwks.requests)
+	}
+}
@@ -0,0 +1,316 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	loginCookie = "goshowtrack_login"
+	// how long users have to sign in with the provider
+	loginMaxAge = 10 * time.Minute
+)
+
+// OIDCConfig configures signing in with an OpenID Connect provider using the
+// authorization code flow with PKCE
+type OIDCConfig struct {
+	// Issuer is the provider's URL, e.g. "https://accounts.google.com"
+	Issuer   string
+	ClientID string
+	// ClientSecret can be empty for public clients, PKCE protects the code either way
+	ClientSecret string
+	// RedirectURL is this app's callback, e.g. "https://shows.example.com/auth/callback"
+	RedirectURL string
+}
+
+// oidcDiscovery is the part of the provider's openid-configuration used here
+// https://openid.net/specs/openid-connect-discovery-1_0.html
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+	EndSessionEndpoint    string `json:"end_session_endpoint"`
+}
+
+// oidcProvider discovers the provider's endpoints the first time they're
+// needed, so the app starts even if the provider is down
+type oidcProvider struct {
+	config OIDCConfig
+	client *http.Client
+
+	mu        sync.Mutex
+	discovery *oidcDiscovery
+	verifier  *jwtVerifier
+}
+
+// login is kept in a cookie between sending the user to the provider and
+// them coming back
+type login struct {
+	State    string `json:"state"`
+	Verifier string `json:"verifier"`
+	Nonce    string `json:"nonce"`
+	Next     string `json:"next"`
+}
+
+func newOIDCProvider(config OIDCConfig) *oidcProvider {
+	config.Issuer = strings.TrimSuffix(config.Issuer, "/")
+	return &oidcProvider{
+		config: config,
+		client: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+	}
+}
+
+func (p *oidcProvider) discover() (*oidcDiscovery, *jwtVerifier, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.discovery != nil {
+		return p.discovery, p.verifier, nil
+	}
+
+	res, err := p.client.Get(p.config.Issuer + "/.well-known/openid-configuration")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to discover OIDC provider: %v", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("failed to discover OIDC provider: status %d", res.StatusCode)
+	}
+
+	var discovery oidcDiscovery
+	err = json.NewDecoder(res.Body).Decode(&discovery)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid OIDC discovery document: %v", err)
+	}
+	if strings.TrimSuffix(discovery.Issuer, "/") != p.config.Issuer {
+		return nil, nil, fmt.Errorf("OIDC provider issuer %q doesn't match %q", discovery.Issuer, p.config.Issuer)
+	}
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JWKSURI == "" {
+		return nil, nil, errors.New("OIDC discovery document is missing endpoints")
+	}
+
+	p.discovery = &discovery
+	p.verifier = newJWTVerifier(discovery.Issuer, p.config.ClientID, discovery.JWKSURI)
+	return p.discovery, p.verifier, nil
+}
+
+// exchange swaps the authorization code for the user's ID token
+func (p *oidcProvider) exchange(tokenEndpoint string, code string, verifier string) (string, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.config.RedirectURL},
+		"client_id":     {p.config.ClientID},
+		"code_verifier": {verifier},
+	}
+	req, err := http.NewRequest("POST", tokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+	}
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to exchange code: %v", err)
+	}
+	defer res.Body.Close()
+
+	var token struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	err = json.NewDecoder(res.Body).Decode(&token)
+	if err != nil {
+		return "", fmt.Errorf("invalid token response: %v", err)
+	}
+	if res.StatusCode != http.StatusOK || token.Error != "" {
+		return "", fmt.Errorf("failed to exchange code: status %d %s %s", res.StatusCode, token.Error, token.ErrorDescription)
+	}
+	if token.IDToken == "" {
+		return "", errors.New("token response is missing the ID token")
+	}
+
+	return token.IDToken, nil
+}
+
+// LoginHandler sends the user to the OIDC provider to sign in, GET /auth/login?next=/path
+func LoginHandler(w http.ResponseWriter, r *http.Request) {
+	if oidc == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	discovery, _, err := oidc.discover()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to start login", "err", err)
+		http.Error(w, "Sign in is unavailable, try again later", http.StatusBadGateway)
+		return
+	}
+
+	l := login{
+		State:    randomString(),
+		Verifier: randomString(),
+		Nonce:    randomString(),
+		Next:     localPath(r.URL.Query().Get("next")),
+	}
+	value, err := signValue("login", l)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to start login", "err", err)
+		http.Error(w, "Failed to start sign in", http.StatusInternalServerError)
+		return
+	}
+	setCookie(w, loginCookie, value, loginMaxAge)
+
+	challenge := sha256.Sum256([]byte(l.Verifier))
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {oidc.config.ClientID},
+		"redirect_uri":          {oidc.config.RedirectURL},
+		"scope":                 {"openid email"},
+		"state":                 {l.State},
+		"nonce":                 {l.Nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+
+	separator := "?"
+	if strings.Contains(discovery.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	http.Redirect(w, r, discovery.AuthorizationEndpoint+separator+query.Encode(), http.StatusFound)
+}
+
+// CallbackHandler signs the user in when the OIDC provider sends them back, GET /auth/callback
+func CallbackHandler(w http.ResponseWriter, r *http.Request) {
+	if oidc == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	query := r.URL.Query()
+	if query.Get("error") != "" {
+		slog.WarnContext(r.Context(), "OIDC provider returned an error", "error", query.Get("error"), "description", query.Get("error_description"))
+		http.Error(w, "Sign in failed: "+query.Get("error"), http.StatusUnauthorized)
+		return
+	}
+
+	var l login
+	cookie, err := r.Cookie(loginCookie)
+	if err == nil {
+		err = readValue("login", cookie.Value, &l)
+	}
+	if err != nil || subtle.ConstantTimeCompare([]byte(l.State), []byte(query.Get("state"))) != 1 {
+		slog.WarnContext(r.Context(), "Invalid login state", "err", err)
+		http.Error(w, "Sign in expired, try again", http.StatusBadRequest)
+		return
+	}
+	clearCookie(w, loginCookie)
+
+	discovery, verifier, err := oidc.discover()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to finish login", "err", err)
+		http.Error(w, "Sign in is unavailable, try again later", http.StatusBadGateway)
+		return
+	}
+
+	idToken, err := oidc.exchange(discovery.TokenEndpoint, query.Get("code"), l.Verifier)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to finish login", "err", err)
+		http.Error(w, "Sign in failed", http.StatusBadGateway)
+		return
+	}
+
+	claims, err := verifier.Verify(idToken)
+	if err != nil {
+		slog.WarnContext(r.Context(), "Invalid ID token", "err", err)
+		http.Error(w, "Sign in failed", http.StatusUnauthorized)
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(l.Nonce)) != 1 {
+		slog.WarnContext(r.Context(), "ID token nonce doesn't match")
+		http.Error(w, "Sign in failed", http.StatusUnauthorized)
+		return
+	}
+	if claims.Email == "" || (claims.EmailVerified != nil && !*claims.EmailVerified) {
+		slog.WarnContext(r.Context(), "ID token has no verified email")
+		http.Error(w, "Sign in needs a verified email address", http.StatusUnauthorized)
+		return
+	}
+
+	userID, err := userIDForEmail(claims.Email)
+	if errors.Is(err, errSignupClosed) {
+		slog.InfoContext(r.Context(), "Sign up refused", "email", claims.Email)
+		http.Error(w, "Sign up is limited to invited users", http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to find user", "err", err)
+		http.Error(w, "Error querying database", http.StatusInternalServerError)
+		return
+	}
+
+	err = startSession(w, userID, claims.Email)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to start session", "err", err)
+		http.Error(w, "Failed to sign in", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, l.Next, http.StatusSeeOther)
+}
+
+var signedOutTemplate = template.Must(template.New("signedOut").Parse(`<!DOCTYPE html>
+<html><head><title>Signed out</title></head>
+<body><p>You've signed out. <a href="/auth/login">Sign in again</a></p></body></html>`))
+
+// LogoutHandler ends the user's session, POST /auth/logout. Users are sent to
+// the provider to sign out there too if it supports it.
+func LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	clearCookie(w, sessionCookie)
+
+	if oidc != nil {
+		discovery, _, err := oidc.discover()
+		if err == nil && discovery.EndSessionEndpoint != "" {
+			query := url.Values{"client_id": {oidc.config.ClientID}}
+			http.Redirect(w, r, discovery.EndSessionEndpoint+"?"+query.Encode(), http.StatusSeeOther)
+			return
+		}
+	}
+
+	signedOutTemplate.Execute(w, nil)
+}
+
+// localPath only allows redirects within this site
+func localPath(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+	return next
+}
+
+func randomString() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
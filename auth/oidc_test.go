@@ -0,0 +1,271 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jccroft1/goshowtrack/db/dbtest"
+)
+
+const testClientID = "goshowtrack"
+
+// fakeIdP is an OIDC provider that signs in anyone as email without asking
+type fakeIdP struct {
+	t      *testing.T
+	jwks   *testJWKS
+	server *httptest.Server
+	email  string
+
+	mu sync.Mutex
+	// authorization requests by code, to check the token request against
+	codes map[string]url.Values
+}
+
+func newFakeIdP(t *testing.T) *fakeIdP {
+	t.Helper()
+
+	jwks, jwksServer := newTestJWKS(t, "key1")
+	idp := &fakeIdP{t: t, jwks: jwks, email: "jane@example.com", codes: map[string]url.Values{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(oidcDiscovery{
+			Issuer:                idp.server.URL,
+			AuthorizationEndpoint: idp.server.URL + "/authorize",
+			TokenEndpoint:         idp.server.URL + "/token",
+			JWKSURI:               jwksServer.URL,
+		})
+	})
+	mux.HandleFunc("GET /authorize", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		code := randomString()
+		idp.mu.Lock()
+		idp.codes[code] = query
+		idp.mu.Unlock()
+
+		callback := query.Get("redirect_uri") + "?" + url.Values{"code": {code}, "state": {query.Get("state")}}.Encode()
+		http.Redirect(w, r, callback, http.StatusFound)
+	})
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		idp.mu.Lock()
+		auth, ok := idp.codes[r.FormValue("code")]
+		delete(idp.codes, r.FormValue("code"))
+		idp.mu.Unlock()
+
+		challenge := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+		if !ok || auth.Get("code_challenge") != base64.RawURLEncoding.EncodeToString(challenge[:]) {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]string{
+			"id_token": jwks.sign(t, "key1", map[string]interface{}{
+				"iss":   idp.server.URL,
+				"aud":   testClientID,
+				"exp":   time.Now().Add(time.Hour).Unix(),
+				"email": idp.email,
+				"nonce": auth.Get("nonce"),
+			}),
+		})
+	})
+
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+// setupOIDC signs users in through a fake IdP with a users table to store them in
+func setupOIDC(t *testing.T) *fakeIdP {
+	t.Helper()
+
+	dbtest.Open(t)
+
+	idp := newFakeIdP(t)
+	err := Setup(Options{
+		OIDC: OIDCConfig{
+			Issuer:      idp.server.URL,
+			ClientID:    testClientID,
+			RedirectURL: "http://shows.example.com/auth/callback",
+		},
+		Secret: "secret",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { oidc = nil })
+
+	return idp
+}
+
+// signIn follows the login flow, returning the callback's response
+func signIn(t *testing.T, idp *fakeIdP, next string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	LoginHandler(w, httptest.NewRequest("GET", "/auth/login?next="+url.QueryEscape(next), nil))
+	if w.Code != http.StatusFound {
+		t.Fatalf("login status = %d, want a redirect to the IdP", w.Code)
+	}
+	authorize := w.Header().Get("Location")
+	if !strings.Contains(authorize, "code_challenge_method=S256") {
+		t.Errorf("authorize URL %s doesn't use PKCE", authorize)
+	}
+
+	// the browser signs in at the IdP and is sent back
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	res, err := client.Get(authorize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	callback, err := url.Parse(res.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest("GET", "/auth/callback?"+callback.RawQuery, nil)
+	for _, cookie := range w.Result().Cookies() {
+		req.AddCookie(cookie)
+	}
+	w = httptest.NewRecorder()
+	CallbackHandler(w, req)
+	return w
+}
+
+func sessionFrom(t *testing.T, w *httptest.ResponseRecorder) *http.Cookie {
+	t.Helper()
+
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == sessionCookie && cookie.Value != "" {
+			return cookie
+		}
+	}
+	t.Fatal("no session cookie set")
+	return nil
+}
+
+func TestOIDCLogin(t *testing.T) {
+	idp := setupOIDC(t)
+
+	w := signIn(t, idp, "/all?sort=name")
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/all?sort=name" {
+		t.Fatalf("callback = %d %s, want a redirect back to the page", w.Code, w.Header().Get("Location"))
+	}
+	cookie := sessionFrom(t, w)
+
+	var gotID int64
+	var gotEmail string
+	handler := Middleware(func(w http.ResponseWriter, r *http.Request) {
+		gotID, _ = GetUserID(r)
+		gotEmail, _ = GetUserEmail(r)
+	})
+	req := httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(cookie)
+	handler(httptest.NewRecorder(), req)
+	if gotID != 1 || gotEmail != "jane@example.com" {
+		t.Errorf("signed in as %d %q, want user 1 jane@example.com", gotID, gotEmail)
+	}
+
+	// signing in again finds the same user
+	cookie = sessionFrom(t, signIn(t, idp, "/"))
+	req = httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(cookie)
+	handler(httptest.NewRecorder(), req)
+	if gotID != 1 {
+		t.Errorf("second sign in is user %d, want 1", gotID)
+	}
+}
+
+func TestOIDCRejectsBadState(t *testing.T) {
+	setupOIDC(t)
+
+	w := httptest.NewRecorder()
+	LoginHandler(w, httptest.NewRequest("GET", "/auth/login", nil))
+
+	req := httptest.NewRequest("GET", "/auth/callback?code=abc&state=forged", nil)
+	for _, cookie := range w.Result().Cookies() {
+		req.AddCookie(cookie)
+	}
+	w = httptest.NewRecorder()
+	CallbackHandler(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("callback with the wrong state = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestMiddlewareWithoutSession(t *testing.T) {
+	setupOIDC(t)
+	handler := Middleware(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("handler called without a session")
+	})
+
+	// pages send people to sign in
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest("GET", "/all?sort=name", nil))
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "/auth/login?next=%2Fall%3Fsort%3Dname" {
+		t.Errorf("page = %d %s, want a redirect to sign in", w.Code, w.Header().Get("Location"))
+	}
+
+	// the API and tampered sessions are refused
+	w = httptest.NewRecorder()
+	handler(w, httptest.NewRequest("GET", "/api/v1/list", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("API = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+
+	forged, _ := signValue("login", session{UserID: 2, Email: "admin@example.com", Expiry: time.Now().Add(time.Hour).Unix()})
+	req := httptest.NewRequest("GET", "/api/v1/list", nil)
+	req.AddCookie(&http.Cookie{Name: sessionCookie, Value: forged})
+	w = httptest.NewRecorder()
+	handler(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("session signed for another cookie = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestLogout(t *testing.T) {
+	idp := setupOIDC(t)
+	cookie := sessionFrom(t, signIn(t, idp, "/"))
+
+	req := httptest.NewRequest("POST", "/auth/logout", nil)
+	req.AddCookie(cookie)
+	req.Header.Set(CSRFHeader, csrfToken(1))
+	w := httptest.NewRecorder()
+	Middleware(LogoutHandler)(w, req)
+
+	cleared := false
+	for _, c := range w.Result().Cookies() {
+		if c.Name == sessionCookie && c.MaxAge < 0 {
+			cleared = true
+		}
+	}
+	if !cleared {
+		t.Error("logout didn't clear the session cookie")
+	}
+}
+
+func TestLocalPath(t *testing.T) {
+	tests := map[string]string{
+		"/all?sort=name":      "/all?sort=name",
+		"":                    "/",
+		"https://example.com": "/",
+		"//example.com":       "/",
+		"/\\example.com":      "/",
+	}
+	for next, want := range tests {
+		if got := localPath(next); got != want {
+			t.Errorf("localPath(%q) = %q, want %q", next, got, want)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Cookies are JSON signed with the same secret as CSRF tokens, so they don't
+// need storing but are only valid until the secret changes.
+
+const (
+	sessionCookie = "goshowtrack_session"
+	// how long users stay signed in
+	sessionMaxAge = 30 * 24 * time.Hour
+)
+
+type session struct {
+	UserID int64  `json:"uid"`
+	Email  string `json:"email"`
+	Expiry int64  `json:"exp"`
+}
+
+// signValue encodes value as JSON with an HMAC, purpose stops a value signed
+// for one cookie being used as another
+func signValue(purpose string, value interface{}) (string, error) {
+	payload, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + cookieMAC(purpose, encoded), nil
+}
+
+// readValue checks the signature of a value from signValue and decodes it into output
+func readValue(purpose string, signed string, output interface{}) error {
+	encoded, mac, ok := strings.Cut(signed, ".")
+	if !ok || !hmac.Equal([]byte(mac), []byte(cookieMAC(purpose, encoded))) {
+		return errors.New("invalid signature")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(payload, output)
+}
+
+func cookieMAC(purpose string, encoded string) string {
+	mac := hmac.New(sha256.New, csrfSecret)
+	mac.Write([]byte(purpose + ":" + encoded))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// setCookie sets a signed cookie that expires after maxAge
+func setCookie(w http.ResponseWriter, name string, value string, maxAge time.Duration) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   int(maxAge.Seconds()),
+		HttpOnly: true,
+		Secure:   secureCookies,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func clearCookie(w http.ResponseWriter, name string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   secureCookies,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// startSession signs the user in until the session expires
+func startSession(w http.ResponseWriter, userID int64, email string) error {
+	value, err := signValue("session", session{
+		UserID: userID,
+		Email:  email,
+		Expiry: time.Now().Add(sessionMaxAge).Unix(),
+	})
+	if err != nil {
+		return err
+	}
+
+	setCookie(w, sessionCookie, value, sessionMaxAge)
+	return nil
+}
+
+// readSession returns the signed in user's session, or false if they aren't signed in
+func readSession(r *http.Request) (session, bool) {
+	var s session
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return s, false
+	}
+
+	err = readValue("session", cookie.Value, &s)
+	if err != nil || time.Now().Unix() > s.Expiry {
+		return session{}, false
+	}
+	return s, true
+}
+
+// HasSession reports whether the user signed in with a session cookie, so
+// they sign out with /auth/logout
+func HasSession(r *http.Request) bool {
+	_, ok := readSession(r)
+	return ok
+}
@@ -0,0 +1,147 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jccroft1/goshowtrack/db"
+)
+
+// API tokens let scripts call the app with an Authorization: Bearer header.
+// Only a hash is stored, tokens are random enough that a fast hash is fine.
+
+const (
+	// ScopeRead tokens can only make GET requests
+	ScopeRead = "read"
+	// ScopeReadWrite tokens can do anything the user can
+	ScopeReadWrite = "read-write"
+
+	// tokenPrefix makes tokens easy to spot, e.g. by secret scanners
+	tokenPrefix = "gst_"
+	// last_used_at is only updated this often, so reads don't all become writes
+	lastUsedInterval = time.Minute
+)
+
+// Token is an API token without its secret
+type Token struct {
+	ID       int64
+	Name     string
+	Scope    string
+	Created  time.Time
+	LastUsed time.Time
+}
+
+// ValidScope reports whether scope is one a token can have
+func ValidScope(scope string) bool {
+	return scope == ScopeRead || scope == ScopeReadWrite
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateToken creates a token for the user and returns it, it can't be seen again
+func CreateToken(userID int64, name string, scope string) (string, error) {
+	if !ValidScope(scope) {
+		return "", fmt.Errorf("unknown token scope %q", scope)
+	}
+
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate token: %v", err)
+	}
+	token := tokenPrefix + base64.RawURLEncoding.EncodeToString(b)
+
+	_, err = db.Connection.Exec(`INSERT INTO api_tokens (user_id, name, hash, scope, created_at) VALUES (?, ?, ?, ?, ?);`,
+		userID, name, hashToken(token), scope, time.Now().UTC().Format(time.RFC3339))
+	if err != nil {
+		return "", fmt.Errorf("failed to save token: %v", err)
+	}
+
+	return token, nil
+}
+
+// ListTokens returns the user's tokens, newest first
+func ListTokens(userID int64) ([]Token, error) {
+	rows, err := db.Connection.Query(`SELECT id, name, scope, created_at, last_used_at FROM api_tokens WHERE user_id = ? ORDER BY id DESC;`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load tokens: %v", err)
+	}
+	defer rows.Close()
+
+	var tokens []Token
+	for rows.Next() {
+		var token Token
+		var created string
+		var lastUsed sql.NullString
+		err := rows.Scan(&token.ID, &token.Name, &token.Scope, &created, &lastUsed)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan token: %v", err)
+		}
+		token.Created, _ = time.Parse(time.RFC3339, created)
+		token.LastUsed, _ = time.Parse(time.RFC3339, lastUsed.String)
+		tokens = append(tokens, token)
+	}
+
+	return tokens, rows.Err()
+}
+
+// RevokeToken deletes one of the user's tokens
+func RevokeToken(userID int64, tokenID int64) error {
+	result, err := db.Connection.Exec(`DELETE FROM api_tokens WHERE id = ? AND user_id = ?;`, tokenID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke token: %v", err)
+	}
+
+	deleted, _ := result.RowsAffected()
+	if deleted == 0 {
+		return errors.New("token not found")
+	}
+	return nil
+}
+
+// bearerToken returns the token in the Authorization header, or "" if there isn't one
+func bearerToken(r *http.Request) string {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+// validateToken returns the user and scope of an API token
+func validateToken(token string) (int64, string, string, error) {
+	var tokenID, userID int64
+	var email, scope sql.NullString
+	var lastUsed sql.NullString
+	err := db.Connection.QueryRow(`SELECT api_tokens.id, api_tokens.user_id, users.email, api_tokens.scope, api_tokens.last_used_at
+		FROM api_tokens LEFT JOIN users ON users.id = api_tokens.user_id
+		WHERE api_tokens.hash = ?;`, hashToken(token)).Scan(&tokenID, &userID, &email, &scope, &lastUsed)
+	if err == sql.ErrNoRows {
+		return 0, "", "", errors.New("unknown API token")
+	}
+	if err != nil {
+		return 0, "", "", fmt.Errorf("failed to check API token: %v", err)
+	}
+
+	now := time.Now().UTC()
+	last, _ := time.Parse(time.RFC3339, lastUsed.String)
+	if now.Sub(last) > lastUsedInterval {
+		_, err = db.Connection.Exec(`UPDATE api_tokens SET last_used_at = ? WHERE id = ?;`, now.Format(time.RFC3339), tokenID)
+		if err != nil {
+			return 0, "", "", fmt.Errorf("failed to update API token: %v", err)
+		}
+	}
+
+	return userID, email.String, scope.String, nil
+}
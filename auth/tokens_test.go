@@ -0,0 +1,109 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jccroft1/goshowtrack/db"
+	"github.com/jccroft1/goshowtrack/db/dbtest"
+)
+
+// setupUsers opens a database with one user
+func setupUsers(t *testing.T) {
+	t.Helper()
+
+	dbtest.Open(t)
+	_, err := db.Connection.Exec(`INSERT INTO users (email) VALUES ('jane@example.com');`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = Setup(Options{TeamDomain: "example", Audience: "aud", Secret: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { verifier = nil })
+}
+
+// tokenRequest makes a request with token, returning the status and the user the handler saw
+func tokenRequest(t *testing.T, method string, token string) (int, int64) {
+	t.Helper()
+
+	var gotID int64
+	handler := Middleware(func(w http.ResponseWriter, r *http.Request) {
+		gotID, _ = GetUserID(r)
+	})
+	req := httptest.NewRequest(method, "/api/v1/shows/1", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	handler(w, req)
+	return w.Code, gotID
+}
+
+func TestTokenScopes(t *testing.T) {
+	setupUsers(t)
+
+	read, err := CreateToken(1, "dashboard", ScopeRead)
+	if err != nil {
+		t.Fatal(err)
+	}
+	write, err := CreateToken(1, "sync", ScopeReadWrite)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if code, id := tokenRequest(t, "GET", read); code != http.StatusOK || id != 1 {
+		t.Errorf("GET with a read token = %d as user %d, want 200 as user 1", code, id)
+	}
+	if code, _ := tokenRequest(t, "PUT", read); code != http.StatusForbidden {
+		t.Errorf("PUT with a read token = %d, want %d", code, http.StatusForbidden)
+	}
+	// no CSRF header needed
+	if code, id := tokenRequest(t, "PUT", write); code != http.StatusOK || id != 1 {
+		t.Errorf("PUT with a read-write token = %d as user %d, want 200 as user 1", code, id)
+	}
+
+	tokens, err := ListTokens(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 2 || tokens[0].Name != "sync" || tokens[1].Name != "dashboard" {
+		t.Fatalf("tokens = %+v, want sync then dashboard", tokens)
+	}
+	for _, token := range tokens {
+		if token.LastUsed.IsZero() {
+			t.Errorf("token %s has no last used time", token.Name)
+		}
+	}
+}
+
+func TestRevokedToken(t *testing.T) {
+	setupUsers(t)
+
+	token, err := CreateToken(1, "old script", ScopeReadWrite)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var stored string
+	db.Connection.QueryRow(`SELECT hash FROM api_tokens;`).Scan(&stored)
+	if stored == token {
+		t.Error("token stored in plain text")
+	}
+
+	tokens, _ := ListTokens(1)
+	if err := RevokeToken(2, tokens[0].ID); err == nil {
+		t.Error("revoked another user's token")
+	}
+	if err := RevokeToken(1, tokens[0].ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if code, _ := tokenRequest(t, "GET", token); code != http.StatusUnauthorized {
+		t.Errorf("revoked token = %d, want %d", code, http.StatusUnauthorized)
+	}
+	if code, _ := tokenRequest(t, "GET", "gst_unknown"); code != http.StatusUnauthorized {
+		t.Errorf("unknown token = %d, want %d", code, http.StatusUnauthorized)
+	}
+}
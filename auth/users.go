@@ -0,0 +1,167 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jccroft1/goshowtrack/db"
+)
+
+// last_seen_at is only updated this often, so page views don't all become writes
+const lastSeenInterval = time.Minute
+
+var (
+	errUserDisabled = errors.New("user is disabled")
+	errUnknownUser  = errors.New("user doesn't exist")
+	errSignupClosed = errors.New("email isn't allowed to sign up")
+)
+
+// emails and domains allowed to sign up, anyone can when it's empty
+var signupAllowlist = map[string]bool{}
+
+// User is a user as shown on the admin pages
+type User struct {
+	ID       int64
+	Email    string
+	Admin    bool
+	Disabled bool
+	LastSeen time.Time
+	// Shows is how many shows are on their list
+	Shows int
+}
+
+// SetSignupAllowlist limits who can sign up to a comma separated list of
+// emails and domains, e.g. "jane@example.com,example.org". Existing users
+// and admins can always sign in.
+func SetSignupAllowlist(list string) {
+	signupAllowlist = map[string]bool{}
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry != "" {
+			signupAllowlist[strings.TrimPrefix(entry, "@")] = true
+		}
+	}
+}
+
+// signupAllowed reports whether a new user with the email can be created
+func signupAllowed(email string) bool {
+	email = strings.ToLower(email)
+	if len(signupAllowlist) == 0 || admins[email] || signupAllowlist[email] {
+		return true
+	}
+
+	_, domain, ok := strings.Cut(email, "@")
+	return ok && signupAllowlist[domain]
+}
+
+// checkUser returns whether the signed in user is an admin, or an error if
+// they've been disabled or deleted. It also records when they were last seen,
+// and makes anyone in ADMIN_EMAILS an admin.
+func checkUser(id int64, email string) (bool, error) {
+	var admin, disabled bool
+	var lastSeen sql.NullString
+	err := db.Connection.QueryRow(`SELECT is_admin, disabled, last_seen_at FROM users WHERE id = ?;`, id).Scan(&admin, &disabled, &lastSeen)
+	if err == sql.ErrNoRows {
+		return false, errUnknownUser
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to load user: %v", err)
+	}
+	if disabled {
+		return false, errUserDisabled
+	}
+
+	now := time.Now().UTC()
+	last, _ := time.Parse(time.RFC3339, lastSeen.String)
+	promote := !admin && admins[strings.ToLower(email)]
+	if promote || now.Sub(last) > lastSeenInterval {
+		admin = admin || promote
+		_, err = db.Connection.Exec(`UPDATE users SET last_seen_at = ?, is_admin = ? WHERE id = ?;`, now.Format(time.RFC3339), admin, id)
+		if err != nil {
+			return false, fmt.Errorf("failed to update user: %v", err)
+		}
+	}
+
+	return admin, nil
+}
+
+// ListUsers returns every user with how many shows they track, most recently seen first
+func ListUsers() ([]User, error) {
+	rows, err := db.Connection.Query(`SELECT users.id, users.email, users.is_admin, users.disabled, users.last_seen_at, COUNT(user_shows.id)
+		FROM users LEFT JOIN user_shows ON user_shows.user_id = users.id
+		GROUP BY users.id
+		ORDER BY users.last_seen_at IS NULL, users.last_seen_at DESC, users.id;`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load users: %v", err)
+	}
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		var user User
+		var email, lastSeen sql.NullString
+		err := rows.Scan(&user.ID, &email, &user.Admin, &user.Disabled, &lastSeen, &user.Shows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan user: %v", err)
+		}
+		user.Email = email.String
+		user.LastSeen, _ = time.Parse(time.RFC3339, lastSeen.String)
+		users = append(users, user)
+	}
+
+	return users, rows.Err()
+}
+
+// SetDisabled stops a user signing in or using their API tokens, or lets them back in
+func SetDisabled(userID int64, disabled bool) error {
+	return updateUser(`UPDATE users SET disabled = ? WHERE id = ?;`, disabled, userID)
+}
+
+// SetAdmin gives a user access to the admin pages, or takes it away
+func SetAdmin(userID int64, admin bool) error {
+	return updateUser(`UPDATE users SET is_admin = ? WHERE id = ?;`, admin, userID)
+}
+
+func updateUser(query string, value bool, userID int64) error {
+	result, err := db.Connection.Exec(query, value, userID)
+	if err != nil {
+		return fmt.Errorf("failed to update user: %v", err)
+	}
+
+	updated, _ := result.RowsAffected()
+	if updated == 0 {
+		return errUnknownUser
+	}
+	return nil
+}
+
+// DeleteUser removes a user along with their shows, progress and API tokens.
+// They can sign up again afterwards, disable them to keep them out.
+func DeleteUser(userID int64) error {
+	tx, err := db.Connection.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, table := range []string{"user_episodes", "user_shows", "api_tokens"} {
+		_, err = tx.Exec(`DELETE FROM `+table+` WHERE user_id = ?;`, userID)
+		if err != nil {
+			return fmt.Errorf("failed to delete %s: %v", table, err)
+		}
+	}
+
+	result, err := tx.Exec(`DELETE FROM users WHERE id = ?;`, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete user: %v", err)
+	}
+	deleted, _ := result.RowsAffected()
+	if deleted == 0 {
+		return errUnknownUser
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,124 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jccroft1/goshowtrack/db"
+)
+
+func TestDisabledUser(t *testing.T) {
+	setupUsers(t)
+	token, err := CreateToken(1, "script", ScopeRead)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = SetDisabled(1, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code, _ := tokenRequest(t, "GET", token); code != http.StatusForbidden {
+		t.Errorf("token of a disabled user = %d, want %d", code, http.StatusForbidden)
+	}
+
+	err = SetDisabled(1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code, _ := tokenRequest(t, "GET", token); code != http.StatusOK {
+		t.Errorf("token of a re-enabled user = %d, want %d", code, http.StatusOK)
+	}
+
+	users, err := ListUsers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 || users[0].LastSeen.IsZero() {
+		t.Errorf("users = %+v, want jane seen just now", users)
+	}
+}
+
+func TestAdminEmails(t *testing.T) {
+	setupUsers(t)
+	SetAdmins("Jane@example.com")
+	t.Cleanup(func() { SetAdmins("") })
+
+	admin, err := checkUser(1, "jane@example.com")
+	if err != nil || !admin {
+		t.Fatalf("checkUser = %v %v, want an admin", admin, err)
+	}
+
+	// the flag is kept once they're promoted
+	SetAdmins("")
+	token, _ := CreateToken(1, "script", ScopeRead)
+	var isAdmin bool
+	handler := Middleware(func(w http.ResponseWriter, r *http.Request) {
+		isAdmin = IsAdmin(r)
+	})
+	req := httptest.NewRequest("GET", "/admin/users", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	handler(httptest.NewRecorder(), req)
+	if !isAdmin {
+		t.Error("promoted user isn't an admin")
+	}
+}
+
+func TestSignupAllowlist(t *testing.T) {
+	setupUsers(t)
+	SetSignupAllowlist("bob@example.com, @example.org")
+	t.Cleanup(func() { SetSignupAllowlist("") })
+
+	for _, email := range []string{"bob@example.com", "amy@example.org", "jane@example.com"} {
+		if _, err := userIDForEmail(email); err != nil {
+			t.Errorf("userIDForEmail(%q) = %v, want them signed in", email, err)
+		}
+	}
+	for _, email := range []string{"eve@example.com", "eve@sub.example.org"} {
+		if _, err := userIDForEmail(email); !errors.Is(err, errSignupClosed) {
+			t.Errorf("userIDForEmail(%q) = %v, want %v", email, err, errSignupClosed)
+		}
+	}
+}
+
+func TestDeleteUser(t *testing.T) {
+	setupUsers(t)
+	_, err := db.Connection.Exec(`
+		INSERT INTO users (email) VALUES ('bob@example.com');
+		INSERT INTO user_shows (user_id, show_id) VALUES (1, 10), (2, 10);
+		INSERT INTO user_episodes (user_id, show_id) VALUES (1, 10), (2, 10);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	CreateToken(1, "script", ScopeRead)
+
+	err = DeleteUser(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	remaining := map[string]string{
+		"users":         `SELECT COUNT(*) FROM users WHERE id = 1;`,
+		"user_shows":    `SELECT COUNT(*) FROM user_shows WHERE user_id = 1;`,
+		"user_episodes": `SELECT COUNT(*) FROM user_episodes WHERE user_id = 1;`,
+		"api_tokens":    `SELECT COUNT(*) FROM api_tokens WHERE user_id = 1;`,
+	}
+	for table, query := range remaining {
+		var count int
+		db.Connection.QueryRow(query).Scan(&count)
+		if count != 0 {
+			t.Errorf("%d rows left in %s", count, table)
+		}
+	}
+
+	users, _ := ListUsers()
+	if len(users) != 1 || users[0].Email != "bob@example.com" || users[0].Shows != 1 {
+		t.Errorf("users = %+v, want only bob with 1 show", users)
+	}
+
+	if err := DeleteUser(1); !errors.Is(err, errUnknownUser) {
+		t.Errorf("deleting again = %v, want %v", err, errUnknownUser)
+	}
+}
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jccroft1/goshowtrack/auth"
+	"github.com/jccroft1/goshowtrack/config"
+	"github.com/jccroft1/goshowtrack/db"
+	"github.com/jccroft1/goshowtrack/posters"
+	"github.com/jccroft1/goshowtrack/scheduler"
+	"github.com/jccroft1/goshowtrack/tvdbapi"
+	"github.com/jccroft1/goshowtrack/userdata"
+)
+
+// runCommand runs the command in args, the server if there isn't one
+func runCommand(cfg *config.Config, cache *posters.Cache, args []string) error {
+	if len(args) == 0 {
+		return serveCommand(cfg, cache)
+	}
+
+	switch args[0] {
+	case "serve":
+		return serveCommand(cfg, cache)
+	case "migrate":
+		return migrateCommand()
+	case "refresh":
+		return refreshCommand(args[1:])
+	case "export":
+		return exportCommand(args[1:])
+	case "import", "restore":
+		// restore was its name before import
+		return importCommand(args[1:])
+	case "user":
+		return userCommand(args[1:])
+	case "backup":
+		return backupCommand(args[1:])
+	case "search":
+		return searchCommand(args[1:])
+	case "job":
+		return jobCommand(args[1:])
+	default:
+		return fmt.Errorf("unknown command %q, expected serve, migrate, refresh, export, import, user, backup, search, job or healthcheck", args[0])
+	}
+}
+
+// migrateCommand brings the database schema up to date without starting the
+// server, e.g. before rolling out a new version
+//
+//	goshowtrack migrate
+func migrateCommand() error {
+	// db.Setup has already applied any migrations
+	current, latest, err := db.SchemaVersion()
+	if err != nil {
+		return err
+	}
+
+	slog.Info("Database is up to date", "version", current, "latest", latest)
+	return nil
+}
+
+// refreshCommand refetches shows from the provider, the stale ones unless a
+// show or all of them are given
+//
+//	goshowtrack refresh [-show id | -all]
+func refreshCommand(args []string) error {
+	fs := flag.NewFlagSet("refresh", flag.ExitOnError)
+	showID := fs.Int("show", 0, "ID of the show to refresh")
+	all := fs.Bool("all", false, "refresh every cached show, not just stale ones")
+	fs.Parse(args)
+
+	ctx := context.Background()
+	switch {
+	case *showID != 0 && *all:
+		return fmt.Errorf("usage: refresh [-show id | -all]")
+	case *showID != 0:
+		show, err := tvdbapi.GetShowDetails(ctx, *showID, true)
+		if err != nil {
+			return err
+		}
+		slog.Info("Refreshed show", "show", show.ID, "name", show.Name, "seasons", len(show.Seasons))
+		return nil
+	case *all:
+		return tvdbapi.RefreshAllShows(ctx)
+	default:
+		return tvdbapi.RefreshShows(ctx)
+	}
+}
+
+// exportCommand writes a user's data to a file or stdout
+//
+//	goshowtrack export -email you@example.com [-format json|csv] [-o file]
+func exportCommand(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	email := fs.String("email", "", "email of the user to export")
+	format := fs.String("format", "json", "export format, json or csv")
+	output := fs.String("o", "", "file to write to, defaults to stdout")
+	fs.Parse(args)
+
+	if *email == "" {
+		return fmt.Errorf("-email is required")
+	}
+
+	userID, err := userdata.UserID(*email, false)
+	if err != nil {
+		return err
+	}
+
+	export, err := userdata.Load(userID)
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	return userdata.Write(w, *format, export)
+}
+
+// importCommand merges an export into a user's data, creating the user if
+// they haven't signed in to this instance yet
+//
+//	goshowtrack import -email you@example.com file
+func importCommand(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	email := fs.String("email", "", "email of the user to import into")
+	fs.Parse(args)
+
+	if *email == "" || fs.NArg() != 1 {
+		return fmt.Errorf("usage: import -email you@example.com file")
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	export, err := userdata.Read(f)
+	if err != nil {
+		return err
+	}
+
+	userID, err := userdata.UserID(*email, true)
+	if err != nil {
+		return err
+	}
+
+	result, err := userdata.Restore(userID, export)
+	if err != nil {
+		return err
+	}
+
+	slog.Info("Imported user data", "shows", result.Shows, "episodes", result.Episodes)
+	return nil
+}
+
+// userCommand lists users, adds them before they've signed in, or disables
+// and enables them
+//
+//	goshowtrack user list
+//	goshowtrack user add you@example.com
+//	goshowtrack user disable you@example.com
+//	goshowtrack user enable you@example.com
+func userCommand(args []string) error {
+	usage := fmt.Errorf("usage: user list | user add|disable|enable <email>")
+	if len(args) == 0 {
+		return usage
+	}
+
+	if args[0] == "list" {
+		users, err := auth.ListUsers()
+		if err != nil {
+			return err
+		}
+		for _, user := range users {
+			lastSeen := "never"
+			if !user.LastSeen.IsZero() {
+				lastSeen = user.LastSeen.Local().Format(time.DateTime)
+			}
+			var flags []string
+			if user.Admin {
+				flags = append(flags, "admin")
+			}
+			if user.Disabled {
+				flags = append(flags, "disabled")
+			}
+			fmt.Printf("%-4d %-32s %4d shows  last seen %-19s %s\n", user.ID, user.Email, user.Shows, lastSeen, strings.Join(flags, ","))
+		}
+		return nil
+	}
+
+	if len(args) != 2 {
+		return usage
+	}
+	email := args[1]
+
+	switch args[0] {
+	case "add":
+		userID, err := userdata.UserID(email, true)
+		if err != nil {
+			return err
+		}
+		slog.Info("Added user", "user", userID, "email", email)
+		return nil
+	case "disable", "enable":
+		userID, err := userdata.UserID(email, false)
+		if err != nil {
+			return err
+		}
+		err = auth.SetDisabled(userID, args[0] == "disable")
+		if err != nil {
+			return err
+		}
+		slog.Info("Updated user", "user", userID, "email", email, "action", args[0])
+		return nil
+	default:
+		return usage
+	}
+}
+
+// backupCommand backs up the database while the server keeps running, and
+// lists, checks and restores backups
+//
+//	goshowtrack backup [-o file]
+//	goshowtrack backup list
+//	goshowtrack backup verify <file>
+//	goshowtrack backup restore <file>
+func backupCommand(args []string) error {
+	ctx := context.Background()
+	usage := fmt.Errorf("usage: backup [-o file] | backup list | backup verify|restore <file>")
+
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		switch {
+		case args[0] == "list" && len(args) == 1:
+			backups, err := db.ListBackups()
+			if err != nil {
+				return err
+			}
+			for _, backup := range backups {
+				fmt.Printf("%-19s %8d KB  %s\n", backup.TakenAt.Local().Format(time.DateTime), backup.Size/1024, backup.Path)
+			}
+			return nil
+		case args[0] == "verify" && len(args) == 2:
+			version, err := db.VerifyBackup(ctx, args[1])
+			if err != nil {
+				return err
+			}
+			slog.Info("Backup is good", "file", args[1], "version", version)
+			return nil
+		case args[0] == "restore" && len(args) == 2:
+			err := db.RestoreBackup(ctx, args[1])
+			if err != nil {
+				return err
+			}
+			slog.Info("Restored database", "file", args[1])
+			return nil
+		default:
+			return usage
+		}
+	}
+
+	fs := flag.NewFlagSet("backup", flag.ExitOnError)
+	output := fs.String("o", "", "file to write the backup to, defaults to a timestamped file in the backup directory")
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		return usage
+	}
+
+	if *output == "" {
+		_, err := db.BackupNow(ctx)
+		return err
+	}
+
+	err := db.Backup(ctx, *output)
+	if err != nil {
+		return err
+	}
+	slog.Info("Backed up database", "file", *output)
+	return nil
+}
+
+// searchCommand searches the provider directly, to check what it returns
+//
+//	goshowtrack search <query>
+func searchCommand(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: search <query>")
+	}
+
+	shows, err := tvdbapi.SearchShow(context.Background(), strings.Join(args, " "))
+	if err != nil {
+		return err
+	}
+	for _, show := range shows {
+		fmt.Printf("%-8d %-10s %s\n", show.ID, show.AirDate, show.Name)
+	}
+	return nil
+}
+
+// jobCommand lists the background jobs or runs one now
+//
+//	goshowtrack job list
+//	goshowtrack job run refresh-shows
+func jobCommand(args []string) error {
+	usage := fmt.Errorf("usage: job list | job run <name>")
+	if len(args) == 0 {
+		return usage
+	}
+
+	switch args[0] {
+	case "list":
+		jobs, err := scheduler.List()
+		if err != nil {
+			return err
+		}
+		for _, job := range jobs {
+			lastRun := "never"
+			if !job.LastRun.IsZero() {
+				lastRun = job.LastRun.Local().Format(time.DateTime)
+			}
+			fmt.Printf("%-16s every %-8v last run %-19s %s\n", job.Name, job.Interval, lastRun, job.LastError)
+		}
+		return nil
+	case "run":
+		if len(args) != 2 {
+			return usage
+		}
+		return scheduler.Run(context.Background(), args[1])
+	default:
+		return usage
+	}
+}
+
+// healthcheckCommand checks the running server is ready, for container
+// health checks as the image doesn't have curl
+//
+//	goshowtrack healthcheck [-url http://localhost:8080/readyz]
+func healthcheckCommand(args []string, addr string) error {
+	fs := flag.NewFlagSet("healthcheck", flag.ExitOnError)
+	url := fs.String("url", readyzURL(addr), "readiness endpoint to check")
+	fs.Parse(args)
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	res, err := client.Get(*url)
+	if err != nil {
+		return fmt.Errorf("server isn't responding: %v", err)
+	}
+	defer res.Body.Close()
+
+	io.Copy(os.Stdout, io.LimitReader(res.Body, 64<<10))
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("server isn't ready, status %d", res.StatusCode)
+	}
+	return nil
+}
+
+// readyzURL returns the readiness endpoint of a server listening on addr
+func readyzURL(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || host == "" || host == "0.0.0.0" || host == "::" {
+		host = "localhost"
+	}
+	if port == "" {
+		port = "80"
+	}
+	return "http://" + net.JoinHostPort(host, port) + "/readyz"
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReadyzURL(t *testing.T) {
+	tests := []struct {
+		addr string
+		want string
+	}{
+		{addr: ":8080", want: "http://localhost:8080/readyz"},
+		{addr: "0.0.0.0:80", want: "http://localhost:80/readyz"},
+		{addr: "[::]:8080", want: "http://localhost:8080/readyz"},
+		{addr: "127.0.0.1:9000", want: "http://127.0.0.1:9000/readyz"},
+		{addr: "[::1]:8080", want: "http://[::1]:8080/readyz"},
+		{addr: "host:", want: "http://host:80/readyz"},
+	}
+
+	for _, tt := range tests {
+		if got := readyzURL(tt.addr); got != tt.want {
+			t.Errorf("readyzURL(%q) = %q, want %q", tt.addr, got, tt.want)
+		}
+	}
+}
+
+func TestCommandUsageErrors(t *testing.T) {
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{args: []string{"refresh", "-show", "1", "-all"}, want: "usage: refresh"},
+		{args: []string{"user"}, want: "usage: user"},
+		{args: []string{"user", "add"}, want: "usage: user"},
+		{args: []string{"user", "add", "a@example.com", "b@example.com"}, want: "usage: user"},
+		{args: []string{"user", "delete", "a@example.com"}, want: "usage: user"},
+		{args: []string{"backup", "list", "extra"}, want: "usage: backup"},
+		{args: []string{"backup", "verify"}, want: "usage: backup"},
+		{args: []string{"backup", "restore", "a.db", "b.db"}, want: "usage: backup"},
+		{args: []string{"backup", "prune"}, want: "usage: backup"},
+		{args: []string{"backup", "-o", "a.db", "b.db"}, want: "usage: backup"},
+		{args: []string{"job"}, want: "usage: job"},
+		{args: []string{"job", "run"}, want: "usage: job"},
+		{args: []string{"job", "run", "a", "b"}, want: "usage: job"},
+		{args: []string{"job", "stop"}, want: "usage: job"},
+		{args: []string{"search"}, want: "usage: search"},
+		{args: []string{"import", "-email", "a@example.com"}, want: "usage: import"},
+		{args: []string{"export"}, want: "-email is required"},
+		{args: []string{"frobnicate"}, want: "unknown command"},
+	}
+
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			err := runCommand(nil, nil, tt.args)
+			if err == nil || !strings.HasPrefix(err.Error(), tt.want) {
+				t.Errorf("runCommand(%q) = %v, want %s...", tt.args, err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,385 @@
+// Package config loads the app's settings from defaults, a config file,
+// environment variables and flags, each overriding the ones before it.
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jccroft1/goshowtrack/auth"
+	"github.com/jccroft1/goshowtrack/db"
+	"github.com/jccroft1/goshowtrack/posters"
+	"github.com/jccroft1/goshowtrack/tvdbapi"
+)
+
+// Config is every setting the app takes, the packages it's passed to own
+// the types of their sections
+type Config struct {
+	Server   Server
+	Database db.Options
+	Provider tvdbapi.Options
+	Posters  posters.Options
+	Auth     auth.Options
+	Jobs     Jobs
+	Log      Log
+	Metrics  Metrics
+}
+
+type Server struct {
+	// Addr is the address the server listens on, e.g. ":8080"
+	Addr string
+}
+
+type Jobs struct {
+	// RefreshInterval is how often cached shows are checked for refreshes
+	RefreshInterval time.Duration
+	// PopularShowsInterval is how often the shows used for search
+	// suggestions are reloaded
+	PopularShowsInterval time.Duration
+	// PosterCleanupInterval is how often unused posters are deleted
+	PosterCleanupInterval time.Duration
+	// BackupInterval is how often the database is backed up
+	BackupInterval time.Duration
+}
+
+type Log struct {
+	// Format is "text" or "json"
+	Format  string
+	Verbose bool
+}
+
+type Metrics struct {
+	// Token is required by /metrics as a bearer token if set
+	Token string
+}
+
+// Default returns the settings used when nothing else sets them
+func Default() *Config {
+	return &Config{
+		Server: Server{Addr: ":8080"},
+		Database: db.Options{
+			Driver:  db.SQLite,
+			Path:    "./data/data.db",
+			Backups: db.BackupOptions{Dir: "./data/backups", Keep: 7},
+		},
+		Provider: tvdbapi.Options{
+			Name:              "tmdb",
+			RequestInterval:   120 * time.Millisecond,
+			PopularShowsPages: 100,
+		},
+		Jobs: Jobs{
+			RefreshInterval:       6 * time.Hour,
+			PopularShowsInterval:  200 * time.Hour,
+			PosterCleanupInterval: 7 * 24 * time.Hour,
+			BackupInterval:        24 * time.Hour,
+		},
+		Log: Log{Format: "text"},
+	}
+}
+
+// setting is a single value with the names it's set by in each source
+type setting struct {
+	// key is its name in the config file, "section.name"
+	key string
+	// env and flag are empty if it can't be set that way
+	env  string
+	flag string
+	// secret settings are redacted when printed, and have no flag so they
+	// don't show up in the process list
+	secret bool
+	usage  string
+	// value points to the field in Config, a *string, *bool, *int or *time.Duration
+	value any
+}
+
+func (c *Config) settings() []setting {
+	return []setting{
+		{key: "server.addr", env: "LISTEN_ADDR", flag: "addr", usage: "address to listen on", value: &c.Server.Addr},
+		{key: "database.driver", env: "DB_DRIVER", flag: "db-driver", usage: "database to store data in, sqlite or postgres", value: &c.Database.Driver},
+		{key: "database.path", env: "DB_PATH", flag: "db", usage: "SQLite database file", value: &c.Database.Path},
+		{key: "database.url", env: "DATABASE_URL", secret: true, usage: "Postgres connection string", value: &c.Database.URL},
+		{key: "database.backup_dir", env: "BACKUP_DIR", flag: "backup-dir", usage: "directory for scheduled backups, empty turns them off", value: &c.Database.Backups.Dir},
+		{key: "database.backup_keep", env: "BACKUP_KEEP", flag: "backup-keep", usage: "how many backups to keep, 0 keeps them all", value: &c.Database.Backups.Keep},
+		{key: "database.backup_max_age", env: "BACKUP_MAX_AGE", flag: "backup-max-age", usage: "remove backups older than this, 0 keeps them however old", value: &c.Database.Backups.MaxAge},
+		{key: "posters.dir", env: "POSTERS_DIR", flag: "posters-dir", usage: "directory posters are downloaded to, defaults to posters next to the SQLite database", value: &c.Posters.Dir},
+		{key: "provider.name", env: "PROVIDER", flag: "provider", usage: "show metadata provider", value: &c.Provider.Name},
+		{key: "provider.token", env: "TVDB_TOKEN", secret: true, usage: "provider API token", value: &c.Provider.Token},
+		{key: "provider.request_interval", env: "PROVIDER_REQUEST_INTERVAL", flag: "request-interval", usage: "least time between requests to the provider", value: &c.Provider.RequestInterval},
+		{key: "provider.popular_shows_pages", env: "POPULAR_SHOWS_PAGES", flag: "popular-shows-pages", usage: "pages of popular shows to load", value: &c.Provider.PopularShowsPages},
+		{key: "auth.disable", env: "DISABLE_AUTH", flag: "disable-auth", usage: "sign everyone in as a single user", value: &c.Auth.Disable},
+		{key: "auth.team_domain", env: "CF_TEAM_DOMAIN", flag: "cf-team-domain", usage: "Cloudflare Access team domain", value: &c.Auth.TeamDomain},
+		{key: "auth.audience", env: "CF_AUD", flag: "cf-aud", usage: "Cloudflare Access application audience", value: &c.Auth.Audience},
+		{key: "auth.secret", env: "AUTH_SECRET", secret: true, usage: "key signing sessions and forms", value: &c.Auth.Secret},
+		{key: "auth.admin_emails", env: "ADMIN_EMAILS", flag: "admin-emails", usage: "comma separated emails of admins", value: &c.Auth.Admins},
+		{key: "auth.signup_allowlist", env: "SIGNUP_ALLOWLIST", flag: "signup-allowlist", usage: "comma separated emails and domains allowed to sign up", value: &c.Auth.SignupAllowlist},
+		{key: "oidc.issuer", env: "OIDC_ISSUER", flag: "oidc-issuer", usage: "OpenID Connect issuer URL", value: &c.Auth.OIDC.Issuer},
+		{key: "oidc.client_id", env: "OIDC_CLIENT_ID", flag: "oidc-client-id", usage: "OpenID Connect client ID", value: &c.Auth.OIDC.ClientID},
+		{key: "oidc.client_secret", env: "OIDC_CLIENT_SECRET", secret: true, usage: "OpenID Connect client secret", value: &c.Auth.OIDC.ClientSecret},
+		{key: "oidc.redirect_url", env: "OIDC_REDIRECT_URL", flag: "oidc-redirect-url", usage: "OpenID Connect callback URL", value: &c.Auth.OIDC.RedirectURL},
+		{key: "jobs.refresh_interval", env: "REFRESH_INTERVAL", flag: "refresh-interval", usage: "how often cached shows are checked for refreshes", value: &c.Jobs.RefreshInterval},
+		{key: "jobs.popular_shows_interval", env: "POPULAR_SHOWS_INTERVAL", flag: "popular-shows-interval", usage: "how often popular shows are reloaded", value: &c.Jobs.PopularShowsInterval},
+		{key: "jobs.poster_cleanup_interval", env: "POSTER_CLEANUP_INTERVAL", flag: "poster-cleanup-interval", usage: "how often unused posters are deleted", value: &c.Jobs.PosterCleanupInterval},
+		{key: "jobs.backup_interval", env: "BACKUP_INTERVAL", flag: "backup-interval", usage: "how often the database is backed up", value: &c.Jobs.BackupInterval},
+		{key: "log.format", env: "LOG_FORMAT", flag: "log-format", usage: "log format, text or json", value: &c.Log.Format},
+		{key: "log.verbose", env: "LOG_VERBOSE", flag: "v", usage: "enable debug logging", value: &c.Log.Verbose},
+		{key: "metrics.token", env: "METRICS_TOKEN", secret: true, usage: "bearer token required by /metrics", value: &c.Metrics.Token},
+	}
+}
+
+// Options are the flags that aren't settings
+type Options struct {
+	// File is the config file that was loaded, if any
+	File string
+	// PrintConfig asks for the config to be printed instead of running
+	PrintConfig bool
+	// Args are the arguments left after the flags, e.g. a command to run
+	Args []string
+}
+
+// Load reads the config from the defaults, then the file given by -config or
+// CONFIG_FILE, then environment variables, then flags in args. The result is
+// validated before it's returned.
+func Load(args []string, getenv func(string) string) (*Config, Options, error) {
+	c := Default()
+	var options Options
+
+	fs := flag.NewFlagSet("goshowtrack", flag.ContinueOnError)
+	fs.StringVar(&options.File, "config", getenv("CONFIG_FILE"), "config file to load")
+	fs.BoolVar(&options.PrintConfig, "print-config", false, "print the config, with secrets redacted, and exit")
+	settings := c.settings()
+	flagged := map[string]*flagValue{}
+	for _, s := range settings {
+		if s.flag != "" {
+			flagged[s.flag] = &flagValue{setting: s}
+			fs.Var(flagged[s.flag], s.flag, s.usage)
+		}
+	}
+	err := fs.Parse(args)
+	if err != nil {
+		return nil, options, err
+	}
+	options.Args = fs.Args()
+
+	// flags are parsed first to find the file, but applied last
+	if options.File != "" {
+		err := c.loadFile(options.File)
+		if err != nil {
+			return nil, options, err
+		}
+	}
+
+	for _, s := range settings {
+		if s.env == "" {
+			continue
+		}
+		// empty is unset, compose files often set variables to an empty ${VAR}
+		raw := getenv(s.env)
+		if raw == "" {
+			continue
+		}
+		err := parseValue(s.value, raw)
+		if err != nil {
+			return nil, options, fmt.Errorf("%s: %v", s.env, err)
+		}
+	}
+
+	fs.Visit(func(f *flag.Flag) {
+		if v, ok := flagged[f.Name]; ok && err == nil {
+			err = parseValue(v.setting.value, v.raw)
+		}
+	})
+	if err != nil {
+		return nil, options, err
+	}
+
+	// posters are kept with the rest of the data unless they're moved
+	if c.Posters.Dir == "" {
+		c.Posters.Dir = filepath.Join(filepath.Dir(c.Database.Path), "posters")
+	}
+
+	return c, options, c.Validate()
+}
+
+// flagValue holds a flag until the file and environment have been applied
+type flagValue struct {
+	setting setting
+	raw     string
+}
+
+func (v *flagValue) String() string {
+	if v == nil || v.setting.value == nil {
+		return ""
+	}
+	return formatValue(v.setting.value)
+}
+
+// Set checks the value parses, it's applied by Load once the flag's turn comes
+func (v *flagValue) Set(raw string) error {
+	err := parseValue(newLike(v.setting.value), raw)
+	if err != nil {
+		return err
+	}
+	v.raw = raw
+	return nil
+}
+
+// IsBoolFlag lets bool settings be given as -v without a value
+func (v *flagValue) IsBoolFlag() bool {
+	_, ok := v.setting.value.(*bool)
+	return ok
+}
+
+// newLike returns a new pointer of the same type as value, to parse into
+// without changing the setting
+func newLike(value any) any {
+	switch value.(type) {
+	case *string:
+		return new(string)
+	case *bool:
+		return new(bool)
+	case *int:
+		return new(int)
+	case *time.Duration:
+		return new(time.Duration)
+	}
+	panic(fmt.Sprintf("config: unsupported setting type %T", value))
+}
+
+func parseValue(value any, raw string) error {
+	raw = strings.TrimSpace(raw)
+	switch v := value.(type) {
+	case *string:
+		*v = raw
+	case *bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("invalid bool %q", raw)
+		}
+		*v = b
+	case *int:
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", raw)
+		}
+		*v = n
+	case *time.Duration:
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q, e.g. 6h or 30m", raw)
+		}
+		*v = d
+	default:
+		panic(fmt.Sprintf("config: unsupported setting type %T", value))
+	}
+	return nil
+}
+
+func formatValue(value any) string {
+	switch v := value.(type) {
+	case *string:
+		return *v
+	case *bool:
+		return strconv.FormatBool(*v)
+	case *int:
+		return strconv.Itoa(*v)
+	case *time.Duration:
+		return v.String()
+	}
+	panic(fmt.Sprintf("config: unsupported setting type %T", value))
+}
+
+// Validate checks the settings are usable, auth.Setup checks the sign in
+// settings once it has them
+func (c *Config) Validate() error {
+	var errs []error
+	if c.Server.Addr == "" {
+		errs = append(errs, errors.New("server.addr is required"))
+	}
+	switch c.Database.Driver {
+	case db.SQLite:
+		if c.Database.Path == "" {
+			errs = append(errs, errors.New("database.path is required"))
+		}
+	case db.Postgres:
+		if c.Database.URL == "" {
+			errs = append(errs, errors.New("database.url is required for postgres"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("database.driver must be %s or %s, got %q", db.SQLite, db.Postgres, c.Database.Driver))
+	}
+	if c.Database.Backups.Keep < 0 {
+		errs = append(errs, fmt.Errorf("database.backup_keep can't be negative, got %d", c.Database.Backups.Keep))
+	}
+	if c.Database.Backups.MaxAge < 0 {
+		errs = append(errs, fmt.Errorf("database.backup_max_age can't be negative, got %v", c.Database.Backups.MaxAge))
+	}
+	if c.Provider.RequestInterval <= 0 {
+		errs = append(errs, fmt.Errorf("provider.request_interval must be more than 0, got %v", c.Provider.RequestInterval))
+	}
+	if c.Provider.PopularShowsPages < 1 || c.Provider.PopularShowsPages > 500 {
+		// TMDB doesn't return pages past 500
+		errs = append(errs, fmt.Errorf("provider.popular_shows_pages must be between 1 and 500, got %d", c.Provider.PopularShowsPages))
+	}
+	for key, interval := range map[string]time.Duration{
+		"jobs.refresh_interval":        c.Jobs.RefreshInterval,
+		"jobs.popular_shows_interval":  c.Jobs.PopularShowsInterval,
+		"jobs.poster_cleanup_interval": c.Jobs.PosterCleanupInterval,
+		"jobs.backup_interval":         c.Jobs.BackupInterval,
+	} {
+		if interval < time.Minute {
+			errs = append(errs, fmt.Errorf("%s must be at least 1m, got %v", key, interval))
+		}
+	}
+	if c.Log.Format != "text" && c.Log.Format != "json" {
+		errs = append(errs, fmt.Errorf("log.format must be text or json, got %q", c.Log.Format))
+	}
+
+	return errors.Join(errs...)
+}
+
+// Print writes the config in the file format, with secrets redacted
+func (c *Config) Print(w io.Writer) {
+	section := ""
+	for _, s := range c.settings() {
+		name, key, _ := strings.Cut(s.key, ".")
+		if name != section {
+			if section != "" {
+				fmt.Fprintln(w)
+			}
+			fmt.Fprintf(w, "[%s]\n", name)
+			section = name
+		}
+
+		value := formatValue(s.value)
+		if s.secret && value != "" {
+			value = "REDACTED"
+		}
+		switch s.value.(type) {
+		case *string, *time.Duration:
+			value = strconv.Quote(value)
+		}
+		fmt.Fprintf(w, "%s = %s\n", key, value)
+	}
+}
+
+// loadFile applies the settings in a config file
+func (c *Config) loadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open config: %v", err)
+	}
+	defer f.Close()
+
+	err = c.decodeFile(f)
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	return nil
+}
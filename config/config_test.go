@@ -0,0 +1,162 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.toml")
+	err := os.WriteFile(path, []byte(contents), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func env(vars map[string]string) func(string) string {
+	return func(name string) string {
+		return vars[name]
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	path := writeConfig(t, `
+# set by every source
+[server]
+addr = ":9000" # overridden
+
+[database]
+path = '/var/lib/goshowtrack/data.db'
+
+[jobs]
+refresh_interval = "1h"
+`)
+
+	cfg, options, err := Load([]string{"-config", path, "-addr", ":9002", "-v", "export", "-email", "jane@example.com"}, env(map[string]string{
+		"LISTEN_ADDR": ":9001",
+		"TVDB_TOKEN":  "token",
+		"DB_PATH":     "",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Server.Addr != ":9002" {
+		t.Errorf("Server.Addr = %q, want the flag's :9002", cfg.Server.Addr)
+	}
+	if cfg.Database.Path != "/var/lib/goshowtrack/data.db" {
+		t.Errorf("Database.Path = %q, want the file's as DB_PATH is empty", cfg.Database.Path)
+	}
+	if cfg.Provider.Token != "token" {
+		t.Errorf("Provider.Token = %q, want the env's", cfg.Provider.Token)
+	}
+	if cfg.Jobs.RefreshInterval != time.Hour {
+		t.Errorf("Jobs.RefreshInterval = %v, want 1h", cfg.Jobs.RefreshInterval)
+	}
+	if cfg.Jobs.PopularShowsInterval != Default().Jobs.PopularShowsInterval {
+		t.Errorf("Jobs.PopularShowsInterval = %v, want the default", cfg.Jobs.PopularShowsInterval)
+	}
+	if cfg.Posters.Dir != "/var/lib/goshowtrack/posters" {
+		t.Errorf("Posters.Dir = %q, want it next to the database", cfg.Posters.Dir)
+	}
+	if !cfg.Log.Verbose {
+		t.Error("Log.Verbose = false, want -v to set it")
+	}
+	if got := strings.Join(options.Args, " "); got != "export -email jane@example.com" {
+		t.Errorf("Args = %q, want the command", got)
+	}
+}
+
+func TestPostersDir(t *testing.T) {
+	cfg, _, err := Load(nil, env(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Posters.Dir != filepath.Join("data", "posters") {
+		t.Errorf("Posters.Dir = %q, want data/posters by default", cfg.Posters.Dir)
+	}
+
+	cfg, _, err = Load([]string{"-posters-dir", "/cache/posters"}, env(map[string]string{"DB_PATH": "/data/data.db"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Posters.Dir != "/cache/posters" {
+		t.Errorf("Posters.Dir = %q, want the flag's", cfg.Posters.Dir)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		args []string
+		env  map[string]string
+		want string
+	}{
+		{name: "unknown setting", file: "[server]\nport = 8080\n", want: `unknown setting "server.port"`},
+		{name: "no section", file: "addr = \":8080\"\n", want: "must be in a [section]"},
+		{name: "bad string", file: "[server]\naddr = \":8080\n", want: "line 2"},
+		{name: "bad file value", file: "[provider]\npopular_shows_pages = \"lots\"\n", want: "incompatible types"},
+		{name: "bad file duration", file: "[jobs]\nrefresh_interval = \"soon\"\n", want: `invalid duration: "soon"`},
+		{name: "nested section", file: "[server.tls]\ncert = \"cert.pem\"\n", want: `unknown setting "server.tls"`},
+		{name: "bad env", env: map[string]string{"DISABLE_AUTH": "yes please"}, want: "DISABLE_AUTH"},
+		{name: "bad flag", args: []string{"-refresh-interval", "daily"}, want: "invalid duration"},
+		{name: "too short", args: []string{"-refresh-interval", "1s"}, want: "jobs.refresh_interval must be at least 1m"},
+		{name: "db driver", env: map[string]string{"DB_DRIVER": "mysql"}, want: "database.driver must be sqlite or postgres"},
+		{name: "postgres url", args: []string{"-db-driver", "postgres"}, want: "database.url is required"},
+		{name: "log format", env: map[string]string{"LOG_FORMAT": "xml"}, want: "log.format must be text or json"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := tt.args
+			if tt.file != "" {
+				args = append([]string{"-config", writeConfig(t, tt.file)}, args...)
+			}
+
+			_, _, err := Load(args, env(tt.env))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("Load() error = %v, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrintRedactsSecrets(t *testing.T) {
+	cfg, _, err := Load(nil, env(map[string]string{
+		"TVDB_TOKEN":          "tmdb-token",
+		"OIDC_CLIENT_ID":      "client",
+		"ADMIN_EMAILS":        "jane@example.com",
+		"POPULAR_SHOWS_PAGES": "5",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var b strings.Builder
+	cfg.Print(&b)
+	printed := b.String()
+
+	if strings.Contains(printed, "tmdb-token") {
+		t.Errorf("printed config contains the provider token:\n%s", printed)
+	}
+	for _, want := range []string{`token = "REDACTED"`, `client_id = "client"`, `secret = ""`, "popular_shows_pages = 5"} {
+		if !strings.Contains(printed, want) {
+			t.Errorf("printed config is missing %s:\n%s", want, printed)
+		}
+	}
+
+	// the printed config can be loaded again
+	reloaded, _, err := Load([]string{"-config", writeConfig(t, printed)}, env(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reloaded.Provider.PopularShowsPages != 5 || reloaded.Auth.Admins != "jane@example.com" {
+		t.Errorf("reloaded config = %+v, want the printed settings", reloaded)
+	}
+}
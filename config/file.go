@@ -0,0 +1,65 @@
+package config
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Config files are TOML, with a table for each section of settings:
+//
+//	# comment
+//	[server]
+//	addr = ":8080"
+//
+//	[provider]
+//	popular_shows_pages = 100
+//
+//	[jobs]
+//	refresh_interval = "6h"
+//
+// Values are decoded into the setting's type, durations are strings.
+
+// decodeFile applies the settings in a config file
+func (c *Config) decodeFile(r io.Reader) error {
+	var sections map[string]toml.Primitive
+	md, err := toml.NewDecoder(r).Decode(&sections)
+	if err != nil {
+		return err
+	}
+
+	settings := map[string]setting{}
+	for _, s := range c.settings() {
+		settings[s.key] = s
+	}
+
+	// keys are checked in the file's order so the first mistake is reported
+	values := map[string]map[string]toml.Primitive{}
+	for _, key := range md.Keys() {
+		if len(key) == 1 && md.Type(key...) != "Hash" {
+			return fmt.Errorf("%s must be in a [section]", key)
+		}
+		if len(key) != 2 {
+			continue
+		}
+		s, ok := settings[key.String()]
+		if !ok {
+			return fmt.Errorf("unknown setting %q", key.String())
+		}
+
+		section, ok := values[key[0]]
+		if !ok {
+			err := md.PrimitiveDecode(sections[key[0]], &section)
+			if err != nil {
+				return err
+			}
+			values[key[0]] = section
+		}
+		err := md.PrimitiveDecode(section[key[1]], s.value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
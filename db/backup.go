@@ -0,0 +1,321 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// backups are named by when they were taken, so sorting by name sorts by age
+const (
+	backupPrefix     = "goshowtrack-"
+	backupSuffix     = ".db"
+	backupTimeFormat = "20060102-150405.000"
+)
+
+// ErrBackupsUnsupported is returned by the backup functions on Postgres, which
+// has its own tools for backups
+var ErrBackupsUnsupported = errors.New("backups are only supported with SQLite, use pg_dump and pg_restore for Postgres")
+
+// BackupFile is a backup in the backup directory
+type BackupFile struct {
+	Path    string
+	TakenAt time.Time
+	Size    int64
+}
+
+// Backup writes a consistent copy of the database to path while the app is
+// running, and checks the copy before it's kept. It refuses to overwrite an
+// existing file.
+func Backup(ctx context.Context, path string) error {
+	if Dialect() != SQLite {
+		return ErrBackupsUnsupported
+	}
+
+	_, err := os.Stat(path)
+	if err == nil {
+		return fmt.Errorf("%s already exists", path)
+	}
+
+	// written next to path and renamed once it's checked, so a failed
+	// backup never looks like a good one
+	tmp := path + ".tmp"
+	os.Remove(tmp)
+
+	// VACUUM INTO copies from a read transaction, so writes carry on and the
+	// copy doesn't need the WAL file
+	_, err = Connection.ExecContext(ctx, `VACUUM INTO ?;`, tmp)
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to back up database: %v", err)
+	}
+
+	_, err = VerifyBackup(ctx, tmp)
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// VerifyBackup checks a backup isn't corrupt and that this version of the app
+// can use it, returning its schema version
+func VerifyBackup(ctx context.Context, path string) (int, error) {
+	_, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+
+	uri, err := readOnlyURI(path)
+	if err != nil {
+		return 0, err
+	}
+	conn, err := sql.Open("sqlite3", uri)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	var result string
+	err = conn.QueryRowContext(ctx, `PRAGMA integrity_check(1);`).Scan(&result)
+	if err != nil {
+		return 0, fmt.Errorf("failed to check %s: %v", path, err)
+	}
+	if result != "ok" {
+		return 0, fmt.Errorf("%s is corrupt: %s", path, result)
+	}
+
+	version, err := schemaVersion(conn)
+	if err != nil {
+		return 0, fmt.Errorf("%s isn't a goshowtrack database: %v", path, err)
+	}
+	latest := migrations[len(migrations)-1].version
+	if version > latest {
+		return 0, fmt.Errorf("%s has schema version %d, newer than this binary supports (%d)", path, version, latest)
+	}
+
+	return version, nil
+}
+
+// BackupNow takes a timestamped backup in the backup directory, then
+// removes the old backups retention no longer keeps
+func BackupNow(ctx context.Context) (string, error) {
+	path, err := backupToDir(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	err = pruneBackups(ctx, time.Now())
+	if err != nil {
+		return path, fmt.Errorf("backed up to %s but failed to remove old backups: %v", path, err)
+	}
+	return path, nil
+}
+
+// backupToDir takes a timestamped backup in the backup directory
+func backupToDir(ctx context.Context) (string, error) {
+	if backupOptions.Dir == "" {
+		return "", errors.New("no backup directory is configured")
+	}
+
+	err := os.MkdirAll(backupOptions.Dir, 0o755)
+	if err != nil {
+		return "", fmt.Errorf("failed to create backup directory: %v", err)
+	}
+
+	path := filepath.Join(backupOptions.Dir, backupPrefix+time.Now().UTC().Format(backupTimeFormat)+backupSuffix)
+	err = Backup(ctx, path)
+	if err != nil {
+		return "", err
+	}
+	slog.InfoContext(ctx, "Backed up database", "file", path)
+	return path, nil
+}
+
+// RunBackups is the backup job, it takes a backup and prunes old ones
+func RunBackups(ctx context.Context) error {
+	_, err := BackupNow(ctx)
+	return err
+}
+
+// ListBackups returns the backups in the backup directory, newest first
+func ListBackups() ([]BackupFile, error) {
+	entries, err := os.ReadDir(backupOptions.Dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list backups: %v", err)
+	}
+
+	var backups []BackupFile
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, backupPrefix) || !strings.HasSuffix(name, backupSuffix) {
+			continue
+		}
+		takenAt, err := time.Parse(backupTimeFormat, strings.TrimSuffix(strings.TrimPrefix(name, backupPrefix), backupSuffix))
+		if err != nil {
+			// not one of ours
+			continue
+		}
+
+		backup := BackupFile{Path: filepath.Join(backupOptions.Dir, name), TakenAt: takenAt}
+		if info, err := entry.Info(); err == nil {
+			backup.Size = info.Size()
+		}
+		backups = append(backups, backup)
+	}
+
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].TakenAt.After(backups[j].TakenAt)
+	})
+	return backups, nil
+}
+
+// pruneBackups removes backups past the newest Keep, and those older than
+// MaxAge. The newest backup is always kept.
+func pruneBackups(ctx context.Context, now time.Time) error {
+	backups, err := ListBackups()
+	if err != nil {
+		return err
+	}
+
+	for i, backup := range backups {
+		if i == 0 {
+			continue
+		}
+		tooMany := backupOptions.Keep > 0 && i >= backupOptions.Keep
+		tooOld := backupOptions.MaxAge > 0 && now.Sub(backup.TakenAt) > backupOptions.MaxAge
+		if !tooMany && !tooOld {
+			continue
+		}
+
+		err := os.Remove(backup.Path)
+		if err != nil {
+			return err
+		}
+		slog.InfoContext(ctx, "Removed old backup", "file", backup.Path)
+	}
+	return nil
+}
+
+// RestoreBackup replaces the database's contents with a backup. The backup is
+// checked first and the current database is backed up, then it's copied in
+// with SQLite's backup API so the swap is all or nothing, even with the
+// server running.
+func RestoreBackup(ctx context.Context, path string) error {
+	if Dialect() != SQLite {
+		return ErrBackupsUnsupported
+	}
+
+	_, err := VerifyBackup(ctx, path)
+	if err != nil {
+		return err
+	}
+
+	// not pruned, that could remove the backup being restored
+	if backupOptions.Dir != "" {
+		current, err := backupToDir(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to back up the current database: %v", err)
+		}
+		slog.InfoContext(ctx, "Backed up the current database before restoring", "file", current)
+	} else {
+		slog.WarnContext(ctx, "No backup directory is configured, the current database isn't backed up before restoring")
+	}
+
+	uri, err := readOnlyURI(path)
+	if err != nil {
+		return err
+	}
+	src, err := sql.Open("sqlite3", uri)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	srcConn, err := src.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer srcConn.Close()
+
+	destConn, err := Connection.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer destConn.Close()
+
+	err = destConn.Raw(func(dest any) error {
+		return srcConn.Raw(func(src any) error {
+			destSQLite, err := sqliteConn(dest)
+			if err != nil {
+				return err
+			}
+			srcSQLite, err := sqliteConn(src)
+			if err != nil {
+				return err
+			}
+			return copyDatabase(destSQLite, srcSQLite)
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("failed to restore %s: %v", path, err)
+	}
+	destConn.Close()
+
+	// the backup may be from an older version
+	return migrate(Connection)
+}
+
+// readOnlyURI opens the SQLite database at path read only, escaping any
+// characters in it that have a meaning in URIs. Relative paths are made
+// absolute, file://name would be read as a host.
+func readOnlyURI(path string) (string, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	uri := url.URL{Scheme: "file", Path: filepath.ToSlash(path), RawQuery: "mode=ro"}
+	return uri.String(), nil
+}
+
+// sqliteConn returns the SQLite connection under a driver connection,
+// unwrapping the instrumentation
+func sqliteConn(conn any) (*sqlite3.SQLiteConn, error) {
+	if instrumented, ok := conn.(*instrumentedConn); ok {
+		conn = instrumented.Conn
+	}
+	sqlite, ok := conn.(*sqlite3.SQLiteConn)
+	if !ok {
+		return nil, fmt.Errorf("expected an SQLite connection, got %T", conn)
+	}
+	return sqlite, nil
+}
+
+func copyDatabase(dest *sqlite3.SQLiteConn, src *sqlite3.SQLiteConn) error {
+	backup, err := dest.Backup("main", src, "main")
+	if err != nil {
+		return err
+	}
+
+	// every page in one step, so the database is never half restored
+	_, err = backup.Step(-1)
+	if err != nil {
+		backup.Close()
+		return err
+	}
+	return backup.Finish()
+}
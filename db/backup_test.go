@@ -0,0 +1,247 @@
+package db_test
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jccroft1/goshowtrack/db"
+	"github.com/jccroft1/goshowtrack/db/dbtest"
+)
+
+// openSQLite opens a test database, skipping the test when it isn't SQLite
+// as only SQLite databases are backed up
+func openSQLite(t *testing.T) *sql.DB {
+	t.Helper()
+
+	conn := dbtest.Open(t)
+	if db.Dialect() != db.SQLite {
+		t.Skipf("backups need SQLite, testing with %s", db.Dialect())
+	}
+	return conn
+}
+
+func TestBackup(t *testing.T) {
+	conn := openSQLite(t)
+
+	_, err := conn.Exec(`INSERT INTO users (email) VALUES ('jane@example.com');`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "backup.db")
+	err = db.Backup(context.Background(), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	backup, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backup.Close()
+
+	var email string
+	err = backup.QueryRow(`SELECT email FROM users;`).Scan(&email)
+	if err != nil || email != "jane@example.com" {
+		t.Fatalf("backup has user %q, %v, want jane@example.com", email, err)
+	}
+
+	// the backup isn't overwritten
+	err = db.Backup(context.Background(), path)
+	if err == nil {
+		t.Error("Backup() to an existing file succeeded, want an error")
+	}
+}
+
+func TestPruneBackups(t *testing.T) {
+	dir := t.TempDir()
+	options := db.BackupOptions{Dir: dir, Keep: 3, MaxAge: 10 * 24 * time.Hour}
+	db.SetBackupOptions(t, options)
+
+	now := time.Date(2025, 6, 20, 3, 0, 0, 0, time.UTC)
+	for _, days := range []int{0, 1, 2, 3, 15} {
+		err := os.WriteFile(filepath.Join(dir, db.BackupName(now.AddDate(0, 0, -days))), nil, 0o600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// other files in the directory are left alone
+	err := os.WriteFile(filepath.Join(dir, "notes.txt"), nil, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.PruneBackups(context.Background(), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	backups, err := db.ListBackups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var kept []string
+	for _, backup := range backups {
+		kept = append(kept, backup.TakenAt.Format(time.DateOnly))
+	}
+	if got := strings.Join(kept, " "); got != "2025-06-20 2025-06-19 2025-06-18" {
+		t.Errorf("kept backups %s, want the newest 3", got)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "notes.txt")); err != nil {
+		t.Errorf("notes.txt was removed: %v", err)
+	}
+
+	// the newest backup is kept however old it is
+	options.MaxAge = time.Hour
+	db.SetBackupOptions(t, options)
+	err = db.PruneBackups(context.Background(), now.AddDate(1, 0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	backups, _ = db.ListBackups()
+	if len(backups) != 1 {
+		t.Errorf("%d backups left, want the newest", len(backups))
+	}
+}
+
+func TestVerifyBackup(t *testing.T) {
+	dir := t.TempDir()
+
+	garbage := filepath.Join(dir, "garbage.db")
+	os.WriteFile(garbage, []byte("not a database, just some text that's long enough to be read as one"), 0o600)
+	if _, err := db.VerifyBackup(context.Background(), garbage); err == nil {
+		t.Error("VerifyBackup() of a text file succeeded, want an error")
+	}
+
+	// an SQLite database, but not one of ours
+	other := filepath.Join(dir, "other.db")
+	conn, err := sql.Open("sqlite3", other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = conn.Exec(`CREATE TABLE notes (id INTEGER);`)
+	conn.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.VerifyBackup(context.Background(), other); err == nil {
+		t.Error("VerifyBackup() of another app's database succeeded, want an error")
+	}
+}
+
+func TestRestoreBackup(t *testing.T) {
+	conn := openSQLite(t)
+	db.SetBackupOptions(t, db.BackupOptions{Dir: filepath.Join(t.TempDir(), "backups"), Keep: 1})
+
+	_, err := conn.Exec(`INSERT INTO users (email) VALUES ('jane@example.com');`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	backup, err := db.BackupNow(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = conn.Exec(`DELETE FROM users;`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.RestoreBackup(context.Background(), backup)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var email string
+	err = conn.QueryRow(`SELECT email FROM users;`).Scan(&email)
+	if err != nil || email != "jane@example.com" {
+		t.Fatalf("restored user %q, %v, want jane@example.com", email, err)
+	}
+
+	backups, err := db.ListBackups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 2 {
+		t.Errorf("%d backups after restoring, want the restored one and the one taken before restoring", len(backups))
+	}
+}
+
+func TestReadOnlyURI(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{path: "/data/goshowtrack.db", want: "file:///data/goshowtrack.db?mode=ro"},
+		{path: "/data/what?#now.db", want: "file:///data/what%3F%23now.db?mode=ro"},
+		{path: "/data/my backups/1.db", want: "file:///data/my%20backups/1.db?mode=ro"},
+	}
+
+	for _, tt := range tests {
+		got, err := db.ReadOnlyURI(tt.path)
+		if err != nil || got != tt.want {
+			t.Errorf("ReadOnlyURI(%q) = %q, %v, want %q", tt.path, got, err, tt.want)
+		}
+	}
+
+	// relative paths would be read as a host
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := db.ReadOnlyURI("goshowtrack.db")
+	if want := "file://" + filepath.ToSlash(filepath.Join(wd, "goshowtrack.db")) + "?mode=ro"; err != nil || got != want {
+		t.Errorf("ReadOnlyURI(goshowtrack.db) = %q, %v, want %q", got, err, want)
+	}
+}
+
+func TestRestoreBackupFromAwkwardPath(t *testing.T) {
+	conn := openSQLite(t)
+	db.SetBackupOptions(t, db.BackupOptions{})
+
+	_, err := conn.Exec(`INSERT INTO users (email) VALUES ('jane@example.com');`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// characters with a meaning in URIs are escaped
+	path := filepath.Join(t.TempDir(), "what?#now", "back up.db")
+	err = os.Mkdir(filepath.Dir(path), 0o700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Backup(context.Background(), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.VerifyBackup(context.Background(), path); err != nil {
+		t.Fatalf("VerifyBackup() failed: %v", err)
+	}
+
+	_, err = conn.Exec(`DELETE FROM users;`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.RestoreBackup(context.Background(), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var email string
+	err = conn.QueryRow(`SELECT email FROM users;`).Scan(&email)
+	if err != nil || email != "jane@example.com" {
+		t.Fatalf("restored user %q, %v, want jane@example.com", email, err)
+	}
+}
+
+func TestSQLiteConn(t *testing.T) {
+	if _, err := db.SQLiteConn("not a connection"); err == nil {
+		t.Error("SQLiteConn() of a string succeeded, want an error")
+	}
+}
@@ -0,0 +1,51 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+// The tests in package db_test open their databases with dbtest, which
+// imports this package, so the internals they check are exported here.
+
+var (
+	Migrate      = migrate
+	PruneBackups = pruneBackups
+	ReadOnlyURI  = readOnlyURI
+	SQLiteConn   = sqliteConn
+)
+
+// MigrationVersions lists each migration's version, in the order they're applied
+func MigrationVersions() []int {
+	var versions []int
+	for _, m := range migrations {
+		versions = append(versions, m.version)
+	}
+	return versions
+}
+
+// InitialSchema is the first migration in the dialect, the tables as they
+// were created before migrations existed
+func InitialSchema(dialect string) string {
+	if dialect == Postgres && migrations[0].postgres != "" {
+		return migrations[0].postgres
+	}
+	return migrations[0].up
+}
+
+// SetBackupOptions changes where backups are kept until the test ends
+func SetBackupOptions(t testing.TB, options BackupOptions) {
+	old := backupOptions
+	backupOptions = options
+	t.Cleanup(func() { backupOptions = old })
+}
+
+// BackupName is the file a backup taken at the time is saved as
+func BackupName(takenAt time.Time) string {
+	return backupPrefix + takenAt.Format(backupTimeFormat) + backupSuffix
+}
+
+// QueryCount is the number of queries timed for the operation
+func QueryCount(op string) uint64 {
+	return queryDuration.Count(op)
+}
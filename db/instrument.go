@@ -0,0 +1,176 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"time"
+
+	"github.com/jccroft1/goshowtrack/metrics"
+	"github.com/mattn/go-sqlite3"
+)
+
+// driverName is sqlite3 with every query timed
+const driverName = "sqlite3-instrumented"
+
+var queryDuration = metrics.NewHistogram("goshowtrack_db_query_duration_seconds",
+	"Time taken by SQLite queries, including reading their rows.", metrics.QueryBuckets, "op")
+
+func init() {
+	sql.Register(driverName, instrumentedDriver{&sqlite3.SQLiteDriver{}})
+
+	metrics.NewGaugeFunc("goshowtrack_users", "Users with an account.", count(`SELECT COUNT(*) FROM users;`))
+	metrics.NewGaugeFunc("goshowtrack_tracked_shows", "Shows on at least one user's list.", count(`SELECT COUNT(DISTINCT show_id) FROM user_shows;`))
+	metrics.NewGaugeFunc("goshowtrack_cached_shows", "Shows cached from the provider.", count(`SELECT COUNT(*) FROM shows;`))
+}
+
+// count returns a gauge reading the number a query returns
+func count(query string) func() (float64, error) {
+	return func() (float64, error) {
+		var n float64
+		err := Connection.QueryRow(query).Scan(&n)
+		return n, err
+	}
+}
+
+// instrumentedDriver wraps a driver to time queries run through it
+type instrumentedDriver struct {
+	driver.Driver
+}
+
+func (d instrumentedDriver) Open(name string) (driver.Conn, error) {
+	conn, err := d.Driver.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &instrumentedConn{conn}, nil
+}
+
+type instrumentedConn struct {
+	driver.Conn
+}
+
+func (c *instrumentedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	execer, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+
+	start := time.Now()
+	result, err := execer.ExecContext(ctx, query, args)
+	if err != driver.ErrSkip {
+		queryDuration.Since(start, "exec")
+	}
+	return result, err
+}
+
+func (c *instrumentedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	queryer, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+
+	start := time.Now()
+	rows, err := queryer.QueryContext(ctx, query, args)
+	if err != nil {
+		if err != driver.ErrSkip {
+			queryDuration.Since(start, "query")
+		}
+		return nil, err
+	}
+	return &instrumentedRows{Rows: rows, start: start}, nil
+}
+
+func (c *instrumentedConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	var stmt driver.Stmt
+	var err error
+	if preparer, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		stmt, err = preparer.PrepareContext(ctx, query)
+	} else {
+		stmt, err = c.Conn.Prepare(query)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &instrumentedStmt{stmt}, nil
+}
+
+func (c *instrumentedConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if beginner, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return beginner.BeginTx(ctx, opts)
+	}
+	return c.Conn.Begin()
+}
+
+func (c *instrumentedConn) Ping(ctx context.Context) error {
+	if pinger, ok := c.Conn.(driver.Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *instrumentedConn) ResetSession(ctx context.Context) error {
+	if resetter, ok := c.Conn.(driver.SessionResetter); ok {
+		return resetter.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *instrumentedConn) IsValid() bool {
+	if validator, ok := c.Conn.(driver.Validator); ok {
+		return validator.IsValid()
+	}
+	return true
+}
+
+// instrumentedStmt times prepared statements, database/sql uses them for
+// queries the connection can't run directly
+type instrumentedStmt struct {
+	driver.Stmt
+}
+
+func (s *instrumentedStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	start := time.Now()
+	defer queryDuration.Since(start, "exec")
+
+	if execer, ok := s.Stmt.(driver.StmtExecContext); ok {
+		return execer.ExecContext(ctx, args)
+	}
+	return s.Stmt.Exec(values(args))
+}
+
+func (s *instrumentedStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	start := time.Now()
+	var rows driver.Rows
+	var err error
+	if queryer, ok := s.Stmt.(driver.StmtQueryContext); ok {
+		rows, err = queryer.QueryContext(ctx, args)
+	} else {
+		rows, err = s.Stmt.Query(values(args))
+	}
+	if err != nil {
+		queryDuration.Since(start, "query")
+		return nil, err
+	}
+	return &instrumentedRows{Rows: rows, start: start}, nil
+}
+
+func values(args []driver.NamedValue) []driver.Value {
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		values[i] = arg.Value
+	}
+	return values
+}
+
+// instrumentedRows records the query once its rows are closed, SQLite does
+// most of the work while they're read
+type instrumentedRows struct {
+	driver.Rows
+	start time.Time
+}
+
+func (r *instrumentedRows) Close() error {
+	queryDuration.Since(r.start, "query")
+	return r.Rows.Close()
+}
@@ -0,0 +1,48 @@
+package db_test
+
+import (
+	"testing"
+
+	"github.com/jccroft1/goshowtrack/db"
+	"github.com/jccroft1/goshowtrack/db/dbtest"
+)
+
+func TestInstrumentedDriver(t *testing.T) {
+	conn := dbtest.Open(t)
+
+	before := map[string]uint64{}
+	for _, op := range []string{"exec", "query"} {
+		before[op] = db.QueryCount(op)
+	}
+
+	_, err := conn.Exec(`CREATE TABLE numbers (n INTEGER); INSERT INTO numbers (n) VALUES (1), (2);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err := conn.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = tx.Exec(`INSERT INTO numbers (n) VALUES (?);`, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var sum int
+	err = conn.QueryRow(`SELECT SUM(n) FROM numbers WHERE n > ?;`, 0).Scan(&sum)
+	if err != nil || sum != 6 {
+		t.Fatalf("sum = %d %v, want 6", sum, err)
+	}
+
+	if got := db.QueryCount("exec") - before["exec"]; got != 2 {
+		t.Errorf("timed %d execs, want 2", got)
+	}
+	if got := db.QueryCount("query") - before["query"]; got != 1 {
+		t.Errorf("timed %d queries, want 1", got)
+	}
+}
@@ -0,0 +1,336 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+type migration struct {
+	version int
+	name    string
+	up      string
+	// postgres replaces up on Postgres when SQLite's SQL won't run there
+	postgres string
+}
+
+// migrations are applied in order and must never be edited once released,
+// add a new migration to change the schema instead. Both SQL dialects must
+// end up with the same tables and columns.
+var migrations = []migration{
+	{
+		version: 1,
+		name:    "initial schema",
+		up: `
+		CREATE TABLE IF NOT EXISTS users (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			email TEXT UNIQUE
+		);
+
+		CREATE TABLE IF NOT EXISTS shows (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			show_id INTEGER UNIQUE,
+			name TEXT,
+			status TEXT,
+			air_date TEXT,
+			description TEXT,
+			poster_path TEXT
+		);
+
+		CREATE TABLE IF NOT EXISTS seasons (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			show_id INTEGER,
+			name TEXT,
+			season_number INTEGER,
+			episode_count INTEGER,
+			air_date TEXT,
+			last_air_date TEXT,
+			UNIQUE(show_id, season_number)
+		);
+
+		CREATE TABLE IF NOT EXISTS user_shows (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER,
+			show_id INTEGER,
+			UNIQUE(user_id, show_id)
+		);
+
+		CREATE TABLE IF NOT EXISTS user_seasons (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER,
+			show_id INTEGER,
+			season_number INTEGER,
+			UNIQUE(user_id, show_id)
+		);`,
+		postgres: `
+		CREATE TABLE IF NOT EXISTS users (
+			id BIGSERIAL PRIMARY KEY,
+			email TEXT UNIQUE
+		);
+
+		CREATE TABLE IF NOT EXISTS shows (
+			id BIGSERIAL PRIMARY KEY,
+			show_id INTEGER UNIQUE,
+			name TEXT,
+			status TEXT,
+			air_date TEXT,
+			description TEXT,
+			poster_path TEXT
+		);
+
+		CREATE TABLE IF NOT EXISTS seasons (
+			id BIGSERIAL PRIMARY KEY,
+			show_id INTEGER,
+			name TEXT,
+			season_number INTEGER,
+			episode_count INTEGER,
+			air_date TEXT,
+			last_air_date TEXT,
+			UNIQUE(show_id, season_number)
+		);
+
+		CREATE TABLE IF NOT EXISTS user_shows (
+			id BIGSERIAL PRIMARY KEY,
+			user_id BIGINT,
+			show_id INTEGER,
+			UNIQUE(user_id, show_id)
+		);
+
+		CREATE TABLE IF NOT EXISTS user_seasons (
+			id BIGSERIAL PRIMARY KEY,
+			user_id BIGINT,
+			show_id INTEGER,
+			season_number INTEGER,
+			UNIQUE(user_id, show_id)
+		);`,
+	},
+	{
+		version: 2,
+		name:    "episodes",
+		up: `
+		CREATE TABLE IF NOT EXISTS episodes (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			show_id INTEGER,
+			season_number INTEGER,
+			episode_number INTEGER,
+			name TEXT,
+			air_date TEXT,
+			UNIQUE(show_id, season_number, episode_number)
+		);
+
+		CREATE TABLE IF NOT EXISTS user_episodes (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER,
+			show_id INTEGER,
+			season_number INTEGER,
+			episode_number INTEGER,
+			UNIQUE(user_id, show_id, season_number, episode_number)
+		);
+
+		-- replace "highest season watched" with every episode in those seasons
+		WITH RECURSIVE numbers(n) AS (
+			SELECT 1 UNION ALL SELECT n + 1 FROM numbers WHERE n < (SELECT IFNULL(MAX(episode_count), 0) FROM seasons)
+		)
+		INSERT OR IGNORE INTO user_episodes (user_id, show_id, season_number, episode_number)
+		SELECT us.user_id, us.show_id, s.season_number, numbers.n
+		FROM user_seasons us
+		JOIN seasons s ON s.show_id = us.show_id AND s.season_number BETWEEN 1 AND us.season_number
+		JOIN numbers ON numbers.n <= s.episode_count;
+
+		DROP TABLE user_seasons;`,
+		postgres: `
+		CREATE TABLE IF NOT EXISTS episodes (
+			id BIGSERIAL PRIMARY KEY,
+			show_id INTEGER,
+			season_number INTEGER,
+			episode_number INTEGER,
+			name TEXT,
+			air_date TEXT,
+			UNIQUE(show_id, season_number, episode_number)
+		);
+
+		CREATE TABLE IF NOT EXISTS user_episodes (
+			id BIGSERIAL PRIMARY KEY,
+			user_id BIGINT,
+			show_id INTEGER,
+			season_number INTEGER,
+			episode_number INTEGER,
+			UNIQUE(user_id, show_id, season_number, episode_number)
+		);
+
+		INSERT INTO user_episodes (user_id, show_id, season_number, episode_number)
+		SELECT us.user_id, us.show_id, s.season_number, n
+		FROM user_seasons us
+		JOIN seasons s ON s.show_id = us.show_id AND s.season_number BETWEEN 1 AND us.season_number
+		CROSS JOIN LATERAL generate_series(1, s.episode_count) AS n
+		ON CONFLICT DO NOTHING;
+
+		DROP TABLE user_seasons;`,
+	},
+	{
+		version: 3,
+		name:    "calendar tokens",
+		up: `
+		ALTER TABLE users ADD COLUMN calendar_token TEXT;
+		CREATE UNIQUE INDEX users_calendar_token ON users (calendar_token);`,
+	},
+	{
+		version: 4,
+		name:    "jobs",
+		up: `
+		CREATE TABLE jobs (
+			name TEXT PRIMARY KEY,
+			last_run_at TEXT,
+			last_duration_ms INTEGER,
+			last_error TEXT,
+			next_run_at TEXT
+		);`,
+	},
+	{
+		version: 5,
+		name:    "posters",
+		up: `
+		CREATE TABLE posters (
+			show_id INTEGER,
+			size TEXT,
+			source_url TEXT,
+			hash TEXT,
+			content_type TEXT,
+			fetched_at TEXT,
+			UNIQUE(show_id, size)
+		);
+		CREATE INDEX posters_hash ON posters (hash);`,
+	},
+	{
+		version: 6,
+		name:    "fetched at",
+		up: `
+		ALTER TABLE shows ADD COLUMN fetched_at TEXT;
+		ALTER TABLE seasons ADD COLUMN fetched_at TEXT;`,
+	},
+	{
+		version: 7,
+		name:    "api tokens",
+		up: `
+		CREATE TABLE api_tokens (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER,
+			name TEXT,
+			hash TEXT UNIQUE,
+			scope TEXT,
+			created_at TEXT,
+			last_used_at TEXT
+		);
+		CREATE INDEX api_tokens_user ON api_tokens (user_id);`,
+		postgres: `
+		CREATE TABLE api_tokens (
+			id BIGSERIAL PRIMARY KEY,
+			user_id BIGINT,
+			name TEXT,
+			hash TEXT UNIQUE,
+			scope TEXT,
+			created_at TEXT,
+			last_used_at TEXT
+		);
+		CREATE INDEX api_tokens_user ON api_tokens (user_id);`,
+	},
+	{
+		version: 8,
+		name:    "user admin",
+		up: `
+		ALTER TABLE users ADD COLUMN is_admin INTEGER NOT NULL DEFAULT 0;
+		ALTER TABLE users ADD COLUMN disabled INTEGER NOT NULL DEFAULT 0;
+		ALTER TABLE users ADD COLUMN last_seen_at TEXT;`,
+		postgres: `
+		ALTER TABLE users ADD COLUMN is_admin BOOLEAN NOT NULL DEFAULT FALSE;
+		ALTER TABLE users ADD COLUMN disabled BOOLEAN NOT NULL DEFAULT FALSE;
+		ALTER TABLE users ADD COLUMN last_seen_at TEXT;`,
+	},
+}
+
+// migrationLock is the Postgres advisory lock held while migrating, so
+// replicas starting together don't apply the same migration twice
+const migrationLock = 4120589
+
+// migrate brings the schema up to the latest version in a single transaction.
+// It refuses to touch a database created by a newer version of the app.
+func migrate(conn *sql.DB) error {
+	dialect := dialectOf(conn)
+
+	tx, err := conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if dialect == Postgres {
+		_, err = tx.Exec(`SELECT pg_advisory_xact_lock(?);`, migrationLock)
+		if err != nil {
+			return fmt.Errorf("failed to lock schema_migrations: %v", err)
+		}
+	}
+
+	_, err = tx.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT,
+		applied_at TEXT
+	);`)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %v", err)
+	}
+
+	current, err := schemaVersion(tx)
+	if err != nil {
+		return err
+	}
+
+	latest := migrations[len(migrations)-1].version
+	if current > latest {
+		return fmt.Errorf("database schema version %d is newer than this binary supports (%d), upgrade the app", current, latest)
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+
+		up := m.up
+		if dialect == Postgres && m.postgres != "" {
+			up = m.postgres
+		}
+
+		slog.Info("Applying migration", "version", m.version, "name", m.name)
+		_, err = tx.Exec(up)
+		if err != nil {
+			return fmt.Errorf("migration %d (%s) failed: %v", m.version, m.name, err)
+		}
+
+		_, err = tx.Exec(`INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?);`,
+			m.version, m.name, time.Now().UTC().Format(time.RFC3339))
+		if err != nil {
+			return fmt.Errorf("failed to record migration %d: %v", m.version, err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// SchemaVersion returns the latest migration applied to the database, and
+// the latest this binary knows about
+func SchemaVersion() (int, int, error) {
+	current, err := schemaVersion(Connection)
+	return current, migrations[len(migrations)-1].version, err
+}
+
+// schemaVersion returns the latest applied migration, 0 for a new database
+func schemaVersion(conn interface {
+	QueryRow(query string, args ...any) *sql.Row
+}) (int, error) {
+	var version int
+	err := conn.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations;`).Scan(&version)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get schema version: %v", err)
+	}
+	return version, nil
+}
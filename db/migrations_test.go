@@ -0,0 +1,84 @@
+package db_test
+
+import (
+	"testing"
+
+	"github.com/jccroft1/goshowtrack/db"
+	"github.com/jccroft1/goshowtrack/db/dbtest"
+)
+
+func TestMigrationsAreSequential(t *testing.T) {
+	for i, version := range db.MigrationVersions() {
+		if version != i+1 {
+			t.Errorf("migration %d has version %d, want %d", i, version, i+1)
+		}
+	}
+}
+
+func TestMigrateNewDatabase(t *testing.T) {
+	conn := dbtest.OpenUnmigrated(t)
+
+	// running twice should be a no-op the second time
+	for i := 0; i < 2; i++ {
+		err := db.Migrate(conn)
+		if err != nil {
+			t.Fatalf("migrate() run %d failed: %v", i+1, err)
+		}
+	}
+
+	version, latest, err := db.SchemaVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != latest {
+		t.Errorf("schema version = %d, want %d", version, latest)
+	}
+}
+
+func TestMigrateExistingInstall(t *testing.T) {
+	conn := dbtest.OpenUnmigrated(t)
+
+	// tables as created before migrations existed
+	_, err := conn.Exec(db.InitialSchema(db.Dialect()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = conn.Exec(`
+		INSERT INTO seasons (show_id, season_number, episode_count) VALUES (10, 1, 3), (10, 2, 2), (10, 3, 5);
+		INSERT INTO user_seasons (user_id, show_id, season_number) VALUES (1, 10, 2);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.Migrate(conn)
+	if err != nil {
+		t.Fatalf("migrate() failed: %v", err)
+	}
+
+	var watched int
+	err = conn.QueryRow(`SELECT COUNT(*) FROM user_episodes WHERE user_id = 1 AND show_id = 10`).Scan(&watched)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if watched != 5 {
+		t.Errorf("converted %d watched episodes, want 5", watched)
+	}
+}
+
+func TestMigrateRefusesNewerDatabase(t *testing.T) {
+	conn := dbtest.Open(t)
+
+	_, latest, err := db.SchemaVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = conn.Exec(`INSERT INTO schema_migrations (version, name) VALUES (?, 'from the future')`, latest+1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.Migrate(conn)
+	if err == nil {
+		t.Fatal("migrate() succeeded on a newer database, want error")
+	}
+}
@@ -0,0 +1,40 @@
+package db_test
+
+import (
+	"testing"
+
+	"github.com/jccroft1/goshowtrack/db"
+	"github.com/jccroft1/goshowtrack/db/dbtest"
+)
+
+func TestOpen(t *testing.T) {
+	conn := dbtest.Open(t)
+
+	current, latest, err := db.SchemaVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current != latest {
+		t.Errorf("schema version = %d, want %d", current, latest)
+	}
+
+	// the queries the app runs work with either driver
+	var id int64
+	err = conn.QueryRow(`INSERT INTO users (email) VALUES (?) RETURNING id;`, "jane@example.com").Scan(&id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = conn.Exec(`INSERT INTO users (email) VALUES (?) ON CONFLICT DO NOTHING;`, "jane@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var admin, disabled bool
+	err = conn.QueryRow(`SELECT is_admin, disabled FROM users WHERE id = ? AND email = ?;`, id, "jane@example.com").Scan(&admin, &disabled)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if admin || disabled {
+		t.Errorf("new user admin = %v, disabled = %v, want neither", admin, disabled)
+	}
+}
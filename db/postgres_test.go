@@ -0,0 +1,73 @@
+package db
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+)
+
+func TestRebind(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{query: `SELECT id FROM users;`, want: `SELECT id FROM users;`},
+		{query: `SELECT id FROM users WHERE email = ?;`, want: `SELECT id FROM users WHERE email = $1;`},
+		{
+			query: `INSERT INTO user_shows (user_id, show_id) VALUES (?, ?) ON CONFLICT DO NOTHING;`,
+			want:  `INSERT INTO user_shows (user_id, show_id) VALUES ($1, $2) ON CONFLICT DO NOTHING;`,
+		},
+		{query: `SELECT '?', "a?b" FROM t WHERE x = ?`, want: `SELECT '?', "a?b" FROM t WHERE x = $1`},
+		{query: `SELECT 'it''s ?' WHERE x = ?`, want: `SELECT 'it''s ?' WHERE x = $1`},
+		{query: "-- why?\nSELECT ? /* or ? */, ?", want: "-- why?\nSELECT $1 /* or ? */, $2"},
+		{query: `SELECT 'unterminated ?`, want: `SELECT 'unterminated ?`},
+	}
+
+	for _, tt := range tests {
+		if got := rebind(tt.query); got != tt.want {
+			t.Errorf("rebind(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+// plainConn is a connection supporting nothing optional
+type plainConn struct {
+	driver.Conn
+}
+
+// checkedConn reports itself broken, like a connection the server dropped
+type checkedConn struct {
+	driver.Conn
+}
+
+func (checkedConn) ResetSession(ctx context.Context) error { return driver.ErrBadConn }
+func (checkedConn) IsValid() bool                          { return false }
+
+func TestConnWrappersCheckSession(t *testing.T) {
+	type conn interface {
+		driver.SessionResetter
+		driver.Validator
+	}
+	wrappers := map[string]func(driver.Conn) conn{
+		"instrumented": func(c driver.Conn) conn { return &instrumentedConn{c} },
+		"postgres":     func(c driver.Conn) conn { return &postgresConn{c} },
+	}
+
+	for name, wrap := range wrappers {
+		c := wrap(plainConn{})
+		if err := c.ResetSession(context.Background()); err != nil {
+			t.Errorf("%s: ResetSession without support = %v, want nil", name, err)
+		}
+		if !c.IsValid() {
+			t.Errorf("%s: IsValid without support = false, want true", name)
+		}
+
+		c = wrap(checkedConn{})
+		if err := c.ResetSession(context.Background()); err != driver.ErrBadConn {
+			t.Errorf("%s: ResetSession = %v, want %v", name, err, driver.ErrBadConn)
+		}
+		if c.IsValid() {
+			t.Errorf("%s: IsValid = true, want false", name)
+		}
+	}
+}
@@ -2,4 +2,8 @@ module github.com/jccroft1/goshowtrack
 
 go 1.23.0
 
-require github.com/mattn/go-sqlite3 v1.14.29
+require (
+	github.com/BurntSushi/toml v1.6.0
+	github.com/lib/pq v1.10.9
+	github.com/mattn/go-sqlite3 v1.14.29
+)
@@ -0,0 +1,171 @@
+package importer
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// csvColumns maps each field to the header names used for it by the exports
+type csvColumns struct {
+	title   []string
+	year    []string
+	tvdb    []string
+	season  []string
+	episode []string
+}
+
+// TV Time exports use TVDB IDs for shows
+var tvTimeColumns = csvColumns{
+	title:   []string{"tv_show_name", "series_name", "show_name"},
+	tvdb:    []string{"tv_show_id", "series_id", "tvdb_id"},
+	season:  []string{"episode_season_number", "season_number"},
+	episode: []string{"episode_number"},
+}
+
+var simpleColumns = csvColumns{
+	title:   []string{"title"},
+	year:    []string{"year"},
+	season:  []string{"season"},
+	episode: []string{"episode"},
+}
+
+func parseTVTime(r io.Reader) ([]Row, []RowError, error) {
+	reader := newCSVReader(r)
+	header, err := reader.Read()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read TV Time export: %v", err)
+	}
+
+	return readCSVRows(reader, header, tvTimeColumns)
+}
+
+// parseCSV reads title,year,season,episode rows, the header is optional
+func parseCSV(r io.Reader) ([]Row, []RowError, error) {
+	reader := newCSVReader(r)
+	first, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read CSV: %v", err)
+	}
+
+	header := []string{"title", "year", "season", "episode"}
+	if strings.EqualFold(strings.TrimSpace(strings.TrimPrefix(first[0], "\ufeff")), "title") {
+		return readCSVRows(reader, first, simpleColumns)
+	}
+
+	line, _ := reader.FieldPos(0)
+	firstRows, firstErrors := csvRow(first, columnIndexes(header, simpleColumns), line)
+
+	rows, rowErrors, err := readCSVRows(reader, header, simpleColumns)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return append(firstRows, rows...), append(firstErrors, rowErrors...), nil
+}
+
+func newCSVReader(r io.Reader) *csv.Reader {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	return reader
+}
+
+// readCSVRows reads the remaining records
+func readCSVRows(reader *csv.Reader, header []string, columns csvColumns) ([]Row, []RowError, error) {
+	indexes := columnIndexes(header, columns)
+	if indexes["title"] == -1 && indexes["tvdb"] == -1 {
+		return nil, nil, errors.New("couldn't find a show name column in the header")
+	}
+
+	var rows []Row
+	var rowErrors []RowError
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				rowErrors = append(rowErrors, RowError{Line: parseErr.StartLine, Message: parseErr.Err.Error()})
+				continue
+			}
+			return nil, nil, err
+		}
+
+		line, _ := reader.FieldPos(0)
+		newRows, newErrors := csvRow(record, indexes, line)
+		rows = append(rows, newRows...)
+		rowErrors = append(rowErrors, newErrors...)
+	}
+
+	return rows, rowErrors, nil
+}
+
+// columnIndexes finds each field in the header, -1 if it's missing
+func columnIndexes(header []string, columns csvColumns) map[string]int {
+	find := func(names []string) int {
+		for i, h := range header {
+			h = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(h, "\ufeff")))
+			for _, name := range names {
+				if h == name {
+					return i
+				}
+			}
+		}
+		return -1
+	}
+
+	return map[string]int{
+		"title":   find(columns.title),
+		"year":    find(columns.year),
+		"tvdb":    find(columns.tvdb),
+		"season":  find(columns.season),
+		"episode": find(columns.episode),
+	}
+}
+
+func csvRow(record []string, indexes map[string]int, line int) ([]Row, []RowError) {
+	field := func(name string) string {
+		i := indexes[name]
+		if i == -1 || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	row := Row{
+		Line:  line,
+		Title: field("title"),
+		IDs:   map[string]string{"tvdb": field("tvdb")},
+	}
+	if row.Title == "" && row.IDs["tvdb"] == "" {
+		// blank lines are skipped rather than reported
+		if strings.TrimSpace(strings.Join(record, "")) == "" {
+			return nil, nil
+		}
+		return nil, []RowError{{Line: line, Message: "missing show name"}}
+	}
+
+	var err error
+	row.Year, err = optionalInt(field("year"))
+	if err != nil {
+		return nil, []RowError{{Line: line, Message: fmt.Sprintf("invalid year %q", field("year"))}}
+	}
+	row.Season, err = optionalInt(field("season"))
+	if err != nil {
+		return nil, []RowError{{Line: line, Message: fmt.Sprintf("invalid season %q", field("season"))}}
+	}
+	row.Episode, err = optionalInt(field("episode"))
+	if err != nil {
+		return nil, []RowError{{Line: line, Message: fmt.Sprintf("invalid episode %q", field("episode"))}}
+	}
+
+	return []Row{row}, nil
+}
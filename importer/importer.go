@@ -0,0 +1,100 @@
+// Package importer reads watch history exported from other trackers and
+// matches it against the provider's catalog.
+package importer
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Formats maps the format names accepted by Parse to a description for the UI
+var Formats = []struct {
+	Name        string
+	Description string
+}{
+	{"trakt", "Trakt JSON export (watched-shows.json or history.json)"},
+	{"tvtime", "TV Time CSV export (seen_episode.csv)"},
+	{"csv", "CSV with title,year,season,episode on each line"},
+}
+
+// Row is a single watched entry from an export. Season 0 means the show
+// should be added without any progress, specials aren't tracked so they're
+// treated the same. Episode 0 means the whole season was watched.
+type Row struct {
+	Line    int
+	Title   string
+	Year    int
+	IDs     map[string]string // external IDs by source, "tmdb", "tvdb" or "imdb"
+	Season  int
+	Episode int
+}
+
+// RowError is a row that couldn't be read
+type RowError struct {
+	Line    int
+	Message string
+}
+
+// Parse reads the export in the named format. Rows that can't be read are
+// returned as errors instead of failing the whole import.
+func Parse(format string, r io.Reader) ([]Row, []RowError, error) {
+	var (
+		rows      []Row
+		rowErrors []RowError
+		err       error
+	)
+	switch format {
+	case "trakt":
+		rows, rowErrors, err = parseTrakt(r)
+	case "tvtime":
+		rows, rowErrors, err = parseTVTime(r)
+	case "csv":
+		rows, rowErrors, err = parseCSV(r)
+	default:
+		return nil, nil, fmt.Errorf("unknown import format %q", format)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for i, row := range rows {
+		rows[i] = cleanRow(row)
+	}
+
+	return rows, rowErrors, nil
+}
+
+var titleYear = regexp.MustCompile(`^(.*\S)\s*\((\d{4})\)$`)
+
+// cleanRow moves a year in the title, e.g. "Doctor Who (2005)", into Year
+func cleanRow(row Row) Row {
+	row.Title = strings.TrimSpace(row.Title)
+
+	match := titleYear.FindStringSubmatch(row.Title)
+	if match != nil {
+		row.Title = match[1]
+		if row.Year == 0 {
+			row.Year, _ = strconv.Atoi(match[2])
+		}
+	}
+
+	for source, id := range row.IDs {
+		if id == "" || id == "0" {
+			delete(row.IDs, source)
+		}
+	}
+
+	return row
+}
+
+// optionalInt parses a number that may be left blank
+func optionalInt(s string) (int, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+	return strconv.Atoi(s)
+}
@@ -0,0 +1,191 @@
+package importer
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jccroft1/goshowtrack/tvdbapi"
+)
+
+func TestParseTrakt(t *testing.T) {
+	watchedShows := `[
+		{
+			"plays": 3,
+			"show": {"title": "Breaking Bad", "year": 2008, "ids": {"trakt": 1, "tvdb": 81189, "imdb": "tt0903747", "tmdb": 1396}},
+			"seasons": [{"number": 1, "episodes": [{"number": 1}, {"number": 2}]}, {"number": 2, "episodes": [{"number": 1}]}]
+		},
+		{
+			"show": {"title": "Severance", "year": 2022, "ids": {"tmdb": null, "imdb": null}}
+		}
+	]`
+	history := `[
+		{"type": "episode", "episode": {"season": 1, "number": 3}, "show": {"title": "Doctor Who (2005)", "ids": {"tvdb": 78804}}},
+		{"type": "movie", "movie": {"title": "Inception"}}
+	]`
+
+	rows, rowErrors, err := Parse("trakt", strings.NewReader(watchedShows))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rowErrors) != 0 {
+		t.Errorf("unexpected row errors: %v", rowErrors)
+	}
+	if len(rows) != 4 {
+		t.Fatalf("got %d rows, want 4: %+v", len(rows), rows)
+	}
+
+	want := Row{
+		Line: 1, Title: "Breaking Bad", Year: 2008, Season: 2, Episode: 1,
+		IDs: map[string]string{"tmdb": "1396", "tvdb": "81189", "imdb": "tt0903747"},
+	}
+	if !reflect.DeepEqual(rows[2], want) {
+		t.Errorf("row = %+v, want %+v", rows[2], want)
+	}
+	if rows[3].Season != 0 || len(rows[3].IDs) != 0 {
+		t.Errorf("show without progress = %+v, want no season or IDs", rows[3])
+	}
+
+	rows, rowErrors, err = Parse("trakt", strings.NewReader(history))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || rows[0].Title != "Doctor Who" || rows[0].Year != 2005 || rows[0].Episode != 3 {
+		t.Errorf("history rows = %+v", rows)
+	}
+	if len(rowErrors) != 1 || rowErrors[0].Line != 2 {
+		t.Errorf("history row errors = %+v, want the movie on line 2", rowErrors)
+	}
+}
+
+func TestParseTVTime(t *testing.T) {
+	export := "\ufeffepisode_id,tv_show_name,tv_show_id,episode_season_number,episode_number,created_at\n" +
+		"1,The Office (US),73244,1,1,2020-01-01 00:00:00\n" +
+		"2,The Office (US),73244,one,2,2020-01-01 00:00:00\n"
+
+	rows, rowErrors, err := Parse("tvtime", strings.NewReader(export))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Row{{Line: 2, Title: "The Office (US)", IDs: map[string]string{"tvdb": "73244"}, Season: 1, Episode: 1}}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("rows = %+v, want %+v", rows, want)
+	}
+	if len(rowErrors) != 1 || rowErrors[0].Line != 3 {
+		t.Errorf("row errors = %+v, want the invalid season on line 3", rowErrors)
+	}
+
+	_, _, err = Parse("tvtime", strings.NewReader("a,b,c\n1,2,3\n"))
+	if err == nil {
+		t.Error("expected an error for an export without a show column")
+	}
+}
+
+func TestParseCSV(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []Row
+	}{
+		{
+			name:  "no header",
+			input: "Severance,2022,1,1\n\nThe Bear,,2,\nAndor\n",
+			want: []Row{
+				{Line: 1, Title: "Severance", Year: 2022, Season: 1, Episode: 1, IDs: map[string]string{}},
+				{Line: 3, Title: "The Bear", Season: 2, IDs: map[string]string{}},
+				{Line: 4, Title: "Andor", IDs: map[string]string{}},
+			},
+		},
+		{
+			name:  "header",
+			input: "Title,Year,Season,Episode\n\"Love, Death & Robots\",2019,1,2\n",
+			want: []Row{
+				{Line: 2, Title: "Love, Death & Robots", Year: 2019, Season: 1, Episode: 2, IDs: map[string]string{}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rows, rowErrors, err := Parse("csv", strings.NewReader(tt.input))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(rowErrors) != 0 {
+				t.Errorf("unexpected row errors: %v", rowErrors)
+			}
+			if !reflect.DeepEqual(rows, tt.want) {
+				t.Errorf("rows = %+v, want %+v", rows, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseUnknownFormat(t *testing.T) {
+	_, _, err := Parse("letterboxd", strings.NewReader(""))
+	if err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
+
+func TestPickShow(t *testing.T) {
+	office := tvdbapi.Show{ID: 1, Name: "The Office", AirDate: "2005-03-24"}
+	officeUK := tvdbapi.Show{ID: 2, Name: "The Office", AirDate: "2001-07-09"}
+	shield := tvdbapi.Show{ID: 3, Name: "Marvel's Agents of S.H.I.E.L.D.", AirDate: "2013-09-24"}
+	shieldDoc := tvdbapi.Show{ID: 4, Name: "Agents of S.H.I.E.L.D.: Declassified", AirDate: "2015-01-01"}
+
+	tests := []struct {
+		name           string
+		title          string
+		year           int
+		results        []tvdbapi.Show
+		wantID         int
+		wantCandidates int
+	}{
+		{"no results", "Nothing", 0, nil, 0, 0},
+		{"single name match", "the office", 0, []tvdbapi.Show{office, shield}, 1, 0},
+		{"same name without year", "The Office", 0, []tvdbapi.Show{office, officeUK}, 0, 2},
+		{"same name picked by year", "The Office", 2001, []tvdbapi.Show{office, officeUK}, 2, 0},
+		{"different spelling picked by year", "Agents of SHIELD", 2013, []tvdbapi.Show{shield, shieldDoc}, 3, 0},
+		{"different spelling without year", "Agents of SHIELD", 0, []tvdbapi.Show{shield, shieldDoc}, 0, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			show, candidates := pickShow(tt.title, tt.year, tt.results)
+
+			gotID := 0
+			if show != nil {
+				gotID = show.ID
+			}
+			if gotID != tt.wantID || len(candidates) != tt.wantCandidates {
+				t.Errorf("pickShow() = %d with %d candidates, want %d with %d", gotID, len(candidates), tt.wantID, tt.wantCandidates)
+			}
+		})
+	}
+}
+
+func TestSelectEpisodes(t *testing.T) {
+	seasons := []tvdbapi.Season{
+		{Number: 1, Episodes: []tvdbapi.Episode{{SeasonNumber: 1, Number: 1}, {SeasonNumber: 1, Number: 2}}},
+		{Number: 2, Episodes: []tvdbapi.Episode{{SeasonNumber: 2, Number: 1}, {SeasonNumber: 2, Number: 2}}},
+	}
+	rows := []Row{
+		{Line: 1, Season: 2, Episode: 2},
+		{Line: 2, Season: 1},
+		{Line: 3, Season: 1, Episode: 1}, // already covered by the whole season
+		{Line: 4, Season: 2, Episode: 9},
+		{Line: 5, Season: 0},
+	}
+
+	episodes, missing := SelectEpisodes(seasons, rows)
+
+	want := []tvdbapi.Episode{{SeasonNumber: 1, Number: 1}, {SeasonNumber: 1, Number: 2}, {SeasonNumber: 2, Number: 2}}
+	if !reflect.DeepEqual(episodes, want) {
+		t.Errorf("episodes = %+v, want %+v", episodes, want)
+	}
+	if len(missing) != 1 || missing[0].Line != 4 {
+		t.Errorf("missing = %+v, want line 4", missing)
+	}
+}
@@ -0,0 +1,228 @@
+package importer
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+
+	"github.com/jccroft1/goshowtrack/tvdbapi"
+)
+
+type Status string
+
+const (
+	Matched   Status = "matched"
+	Ambiguous Status = "ambiguous"
+	Failed    Status = "failed"
+)
+
+// most candidates listed for an ambiguous show
+const maxCandidates = 5
+
+// external ID sources, most reliable first
+var idSources = []string{"tmdb", "tvdb", "imdb"}
+
+// ShowMatch is every row for one show in the export and the show it matched
+type ShowMatch struct {
+	Title  string
+	Year   int
+	Rows   []Row
+	Status Status
+
+	// Show is set when the status is Matched
+	Show *tvdbapi.Show
+	// Candidates are the closest search results when the status is Ambiguous
+	Candidates []tvdbapi.Show
+	Reason     string
+}
+
+// Match groups the rows by show and finds each show in the provider's catalog,
+// first by external ID then by title and year
+func Match(ctx context.Context, rows []Row) []ShowMatch {
+	matches := groupRows(rows)
+	for i := range matches {
+		matchShow(ctx, &matches[i])
+	}
+	return matches
+}
+
+// groupRows groups rows for the same show, keeping the order they first appear
+func groupRows(rows []Row) []ShowMatch {
+	var matches []ShowMatch
+	index := map[string]int{}
+	for _, row := range rows {
+		key := showKey(row)
+		i, ok := index[key]
+		if !ok {
+			i = len(matches)
+			index[key] = i
+			matches = append(matches, ShowMatch{Title: row.Title, Year: row.Year})
+		}
+		matches[i].Rows = append(matches[i].Rows, row)
+	}
+	return matches
+}
+
+func showKey(row Row) string {
+	for _, source := range idSources {
+		if id := row.IDs[source]; id != "" {
+			return source + ":" + id
+		}
+	}
+	return fmt.Sprintf("%s|%d", tvdbapi.NormalizeShowName(row.Title), row.Year)
+}
+
+func matchShow(ctx context.Context, m *ShowMatch) {
+	ids := m.Rows[0].IDs
+	for _, source := range idSources {
+		id := ids[source]
+		if id == "" {
+			continue
+		}
+
+		show, err := tvdbapi.FindShowByExternalID(ctx, source, id)
+		if err != nil {
+			slog.WarnContext(ctx, "Failed to find show by external ID", "source", source, "id", id, "err", err)
+			continue
+		}
+		if show != nil {
+			m.Status = Matched
+			m.Show = show
+			if m.Title == "" {
+				m.Title = show.Name
+			}
+			return
+		}
+	}
+
+	if m.Title == "" {
+		m.Status = Failed
+		m.Reason = "no show found for its ID"
+		return
+	}
+
+	results, err := tvdbapi.SearchShow(ctx, m.Title)
+	if err != nil {
+		slog.WarnContext(ctx, "Import search failed", "err", err)
+		m.Status = Failed
+		m.Reason = "search failed, try importing again"
+		return
+	}
+
+	show, candidates := pickShow(m.Title, m.Year, results)
+	switch {
+	case show != nil:
+		m.Status = Matched
+		m.Show = show
+	case len(candidates) > 0:
+		m.Status = Ambiguous
+		m.Candidates = candidates
+		m.Reason = "more than one show could match"
+	default:
+		m.Status = Failed
+		m.Reason = "no shows found"
+	}
+}
+
+// pickShow chooses the search result for title, or returns the closest
+// candidates if there isn't a single clear match. A result matches if its
+// name is the same once normalized, or if it's the only result released in year.
+func pickShow(title string, year int, results []tvdbapi.Show) (*tvdbapi.Show, []tvdbapi.Show) {
+	if len(results) == 0 {
+		return nil, nil
+	}
+
+	name := tvdbapi.NormalizeShowName(title)
+	var sameName []tvdbapi.Show
+	for _, show := range results {
+		if tvdbapi.NormalizeShowName(show.Name) == name {
+			sameName = append(sameName, show)
+		}
+	}
+
+	if year != 0 {
+		sameNameAndYear := releasedIn(sameName, year)
+		if len(sameNameAndYear) == 1 {
+			return &sameNameAndYear[0], nil
+		}
+		if len(sameNameAndYear) > 1 {
+			return nil, limit(sameNameAndYear)
+		}
+
+		// titles are often written slightly differently, e.g. punctuation
+		sameYear := releasedIn(results, year)
+		if len(sameYear) == 1 && len(sameName) == 0 {
+			return &sameYear[0], nil
+		}
+	} else if len(sameName) == 1 {
+		return &sameName[0], nil
+	}
+
+	if len(sameName) > 0 {
+		return nil, limit(sameName)
+	}
+	return nil, limit(results)
+}
+
+func releasedIn(shows []tvdbapi.Show, year int) []tvdbapi.Show {
+	prefix := fmt.Sprintf("%d-", year)
+	var found []tvdbapi.Show
+	for _, show := range shows {
+		if strings.HasPrefix(show.AirDate, prefix) {
+			found = append(found, show)
+		}
+	}
+	return found
+}
+
+func limit(shows []tvdbapi.Show) []tvdbapi.Show {
+	if len(shows) > maxCandidates {
+		return shows[:maxCandidates]
+	}
+	return shows
+}
+
+// SelectEpisodes returns the show's episodes named by the rows, and the rows
+// that don't match an episode
+func SelectEpisodes(seasons []tvdbapi.Season, rows []Row) ([]tvdbapi.Episode, []Row) {
+	type key struct{ season, episode int }
+	selected := map[key]tvdbapi.Episode{}
+	var missing []Row
+
+	for _, row := range rows {
+		if row.Season == 0 {
+			continue
+		}
+
+		found := false
+		for _, season := range seasons {
+			if season.Number != row.Season {
+				continue
+			}
+			for _, e := range season.Episodes {
+				if row.Episode == 0 || row.Episode == e.Number {
+					selected[key{e.SeasonNumber, e.Number}] = e
+					found = true
+				}
+			}
+		}
+		if !found {
+			missing = append(missing, row)
+		}
+	}
+
+	episodes := make([]tvdbapi.Episode, 0, len(selected))
+	for _, e := range selected {
+		episodes = append(episodes, e)
+	}
+	sort.Slice(episodes, func(i, j int) bool {
+		if episodes[i].SeasonNumber != episodes[j].SeasonNumber {
+			return episodes[i].SeasonNumber < episodes[j].SeasonNumber
+		}
+		return episodes[i].Number < episodes[j].Number
+	})
+
+	return episodes, missing
+}
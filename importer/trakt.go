@@ -0,0 +1,92 @@
+package importer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// traktItem covers both watched-shows.json, with a list of seasons per show,
+// and history.json, with one item per play
+// https://trakt.docs.apiary.io/#reference/sync/get-watched
+type traktItem struct {
+	Type string `json:"type"`
+	Show *struct {
+		Title string `json:"title"`
+		Year  int    `json:"year"`
+		IDs   struct {
+			TMDB int    `json:"tmdb"`
+			TVDB int    `json:"tvdb"`
+			IMDB string `json:"imdb"`
+		} `json:"ids"`
+	} `json:"show"`
+	Seasons []struct {
+		Number   int `json:"number"`
+		Episodes []struct {
+			Number int `json:"number"`
+		} `json:"episodes"`
+	} `json:"seasons"`
+	Episode *struct {
+		Season int `json:"season"`
+		Number int `json:"number"`
+	} `json:"episode"`
+}
+
+func parseTrakt(r io.Reader) ([]Row, []RowError, error) {
+	var items []traktItem
+	err := json.NewDecoder(r).Decode(&items)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read Trakt export: %v", err)
+	}
+
+	var rows []Row
+	var rowErrors []RowError
+	for i, item := range items {
+		line := i + 1
+		if item.Show == nil {
+			message := "not a TV show"
+			if item.Type != "" {
+				message = fmt.Sprintf("%s entries aren't supported", item.Type)
+			}
+			rowErrors = append(rowErrors, RowError{Line: line, Message: message})
+			continue
+		}
+
+		show := Row{
+			Line:  line,
+			Title: item.Show.Title,
+			Year:  item.Show.Year,
+			IDs: map[string]string{
+				"tmdb": strconv.Itoa(item.Show.IDs.TMDB),
+				"tvdb": strconv.Itoa(item.Show.IDs.TVDB),
+				"imdb": item.Show.IDs.IMDB,
+			},
+		}
+
+		// history.json
+		if item.Episode != nil {
+			row := show
+			row.Season = item.Episode.Season
+			row.Episode = item.Episode.Number
+			rows = append(rows, row)
+			continue
+		}
+
+		// watched-shows.json, or a watchlist without any progress
+		if len(item.Seasons) == 0 {
+			rows = append(rows, show)
+			continue
+		}
+		for _, season := range item.Seasons {
+			for _, episode := range season.Episodes {
+				row := show
+				row.Season = season.Number
+				row.Episode = episode.Number
+				rows = append(rows, row)
+			}
+		}
+	}
+
+	return rows, rowErrors, nil
+}
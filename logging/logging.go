@@ -1,20 +1,119 @@
+// Package logging sets up structured logging with log/slog and logs every
+// request with an ID that follows it through the handlers.
 package logging
 
 import (
-	"bytes"
-	"log"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
 	"net/http"
+	"os"
+	"regexp"
+	"strconv"
 	"time"
+
+	"github.com/jccroft1/goshowtrack/metrics"
 )
 
+// RequestIDHeader carries the request ID, it's reused from the request if a
+// proxy already set one and always sent back in the response
+const RequestIDHeader = "X-Request-ID"
+
+// incoming IDs are only trusted if they look like one
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
 var (
-	Verbose bool
+	requestsTotal = metrics.NewCounter("goshowtrack_http_requests_total",
+		"HTTP requests handled.", "method", "route", "status")
+	requestDuration = metrics.NewHistogram("goshowtrack_http_request_duration_seconds",
+		"Time taken to handle HTTP requests.", metrics.DefaultBuckets, "method", "route")
 )
 
+// Setup makes slog the default logger, writing "text" or "json" to stderr.
+// Debug messages are only written if verbose is set.
+func Setup(format string, verbose bool) error {
+	handler, err := newHandler(os.Stderr, format, verbose)
+	if err != nil {
+		return err
+	}
+
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+func newHandler(w io.Writer, format string, verbose bool) (slog.Handler, error) {
+	level := slog.LevelInfo
+	if verbose {
+		level = slog.LevelDebug
+	}
+	options := &slog.HandlerOptions{Level: level}
+
+	switch format {
+	case "", "text":
+		return contextHandler{slog.NewTextHandler(w, options)}, nil
+	case "json":
+		return contextHandler{slog.NewJSONHandler(w, options)}, nil
+	default:
+		return nil, fmt.Errorf("unknown log format %q, use text or json", format)
+	}
+}
+
+// requestInfo is shared by everything handling a request, so the user found
+// by auth.Middleware makes it back to the access log
+type requestInfo struct {
+	id     string
+	userID int64
+}
+
+type requestKey struct{}
+
+// RequestID returns the ID of the request ctx belongs to, or "" outside of one
+func RequestID(ctx context.Context) string {
+	info, ok := ctx.Value(requestKey{}).(*requestInfo)
+	if !ok {
+		return ""
+	}
+	return info.id
+}
+
+// SetUserID records who made the request, for every log line after it
+func SetUserID(ctx context.Context, userID int64) {
+	info, ok := ctx.Value(requestKey{}).(*requestInfo)
+	if ok {
+		info.userID = userID
+	}
+}
+
+// contextHandler adds the request ID and user from the context to each
+// record, for logs written with slog's Context functions
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if info, ok := ctx.Value(requestKey{}).(*requestInfo); ok {
+		record.AddAttrs(slog.String("request_id", info.id))
+		if info.userID != 0 {
+			record.AddAttrs(slog.Int64("user_id", info.userID))
+		}
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
 type loggingResponseWriter struct {
 	http.ResponseWriter
 	statusCode int
-	body       bytes.Buffer
 }
 
 func (lrw *loggingResponseWriter) WriteHeader(code int) {
@@ -22,36 +121,49 @@ func (lrw *loggingResponseWriter) WriteHeader(code int) {
 	lrw.ResponseWriter.WriteHeader(code)
 }
 
-func (lrw *loggingResponseWriter) Write(b []byte) (int, error) {
-	lrw.body.Write(b) // capture body for logging
-	return lrw.ResponseWriter.Write(b)
+// Unwrap lets http.ResponseController reach the real writer
+func (lrw *loggingResponseWriter) Unwrap() http.ResponseWriter {
+	return lrw.ResponseWriter
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
 }
 
+// Middleware gives the request an ID, then logs it and records its metrics
+// once it's handled
 func Middleware(next func(w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request) {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
 
+		info := &requestInfo{id: r.Header.Get(RequestIDHeader)}
+		if !validRequestID.MatchString(info.id) {
+			info.id = newRequestID()
+		}
+		ctx := context.WithValue(r.Context(), requestKey{}, info)
+		w.Header().Set(RequestIDHeader, info.id)
+
 		lrw := &loggingResponseWriter{
 			ResponseWriter: w,
 			statusCode:     200, // default status
 		}
 
 		// Call the next handler
-		next(lrw, r)
+		next(lrw, r.WithContext(ctx))
 
-		// Log duration
 		duration := time.Since(start)
+		requestsTotal.Inc(r.Method, r.Pattern, strconv.Itoa(lrw.statusCode))
+		requestDuration.Observe(duration.Seconds(), r.Method, r.Pattern)
 
-		if Verbose {
-			log.Printf("[%s] %s %s from %s | Status: %d | Duration: %v\n",
-				r.Method,
-				r.URL.Path,
-				r.Proto,
-				r.RemoteAddr,
-				lrw.statusCode,
-				duration,
-			)
-		}
-
+		slog.LogAttrs(ctx, slog.LevelInfo, "request",
+			slog.String("method", r.Method),
+			slog.String("route", r.Pattern),
+			slog.String("path", r.URL.Path),
+			slog.String("remote_addr", r.RemoteAddr),
+			slog.Int("status", lrw.statusCode),
+			slog.Duration("duration", duration),
+		)
 	})
 }
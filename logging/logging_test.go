@@ -0,0 +1,98 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// captureLogs sends the default logger to a buffer as JSON until the test ends
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+
+	var buf bytes.Buffer
+	handler, err := newHandler(&buf, "json", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := slog.Default()
+	slog.SetDefault(slog.New(handler))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+
+	return &buf
+}
+
+func TestMiddleware(t *testing.T) {
+	logs := captureLogs(t)
+
+	var handlerID string
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /show/{id}", Middleware(func(w http.ResponseWriter, r *http.Request) {
+		handlerID = RequestID(r.Context())
+		SetUserID(r.Context(), 7)
+		slog.InfoContext(r.Context(), "in handler")
+		w.WriteHeader(http.StatusTeapot)
+	}))
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/show/1", nil))
+
+	id := w.Header().Get(RequestIDHeader)
+	if id == "" || id != handlerID {
+		t.Fatalf("response request ID %q, handler saw %q", id, handlerID)
+	}
+
+	var lines []map[string]interface{}
+	decoder := json.NewDecoder(logs)
+	for decoder.More() {
+		var line map[string]interface{}
+		if err := decoder.Decode(&line); err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) != 2 {
+		t.Fatalf("got %d log lines, want the handler's and the access log", len(lines))
+	}
+
+	for _, line := range lines {
+		if line["request_id"] != id || line["user_id"] != float64(7) {
+			t.Errorf("line %v is missing the request ID %s or user 7", line, id)
+		}
+	}
+	access := lines[1]
+	if access["route"] != "GET /show/{id}" || access["status"] != float64(http.StatusTeapot) || access["duration"] == nil {
+		t.Errorf("access log %v, want the route, status and duration", access)
+	}
+}
+
+func TestMiddlewareKeepsRequestID(t *testing.T) {
+	captureLogs(t)
+	handler := Middleware(func(w http.ResponseWriter, r *http.Request) {})
+
+	tests := map[string]bool{
+		"abc-123":                true,
+		"has spaces in it":       false,
+		"<script>":               false,
+		string(make([]byte, 65)): false,
+	}
+	for incoming, kept := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set(RequestIDHeader, incoming)
+		w := httptest.NewRecorder()
+		handler(w, req)
+
+		if got := w.Header().Get(RequestIDHeader); (got == incoming) != kept {
+			t.Errorf("incoming ID %q became %q, kept = %v", incoming, got, kept)
+		}
+	}
+}
+
+func TestSetupRejectsUnknownFormat(t *testing.T) {
+	if err := Setup("xml", false); err == nil {
+		t.Error("Setup accepted an unknown format")
+	}
+}
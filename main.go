@@ -1,93 +1,101 @@
 package main
 
 import (
-	"context"
 	"flag"
-	"log"
-	"net/http"
+	"fmt"
+	"log/slog"
 	"os"
-	"os/signal"
-	"syscall"
-	"time"
 
-	"github.com/jccroft1/goshowtrack/auth"
+	"github.com/jccroft1/goshowtrack/config"
 	"github.com/jccroft1/goshowtrack/db"
 	"github.com/jccroft1/goshowtrack/logging"
-	"github.com/jccroft1/goshowtrack/routes"
+	"github.com/jccroft1/goshowtrack/posters"
+	"github.com/jccroft1/goshowtrack/scheduler"
 	"github.com/jccroft1/goshowtrack/tvdbapi"
 )
 
 func main() {
-	log.SetFlags(log.Ldate | log.Ltime)
-	flag.BoolVar(&logging.Verbose, "v", false, "enable verbose logging")
-	flag.Parse()
-
-	dbClose := db.Setup()
-	defer dbClose()
-
-	TVDB_TOKEN := os.Getenv("TVDB_TOKEN")
-	tvdbapi.Setup(TVDB_TOKEN)
-
-	DISABLE_AUTH := os.Getenv("DISABLE_AUTH")
-	auth.Setup(DISABLE_AUTH == "true")
-
-	// Setup server
-	mux := http.NewServeMux()
-
-	fs := http.FileServer(http.Dir("./assets/"))
-	mux.Handle("GET /assets/", http.StripPrefix("/assets/", fs))
-
-	// main pages
-	mux.HandleFunc("GET /", logging.Middleware(auth.Middleware(routes.HomeHandler)))
-	mux.HandleFunc("GET /about", logging.Middleware(auth.Middleware(routes.AboutHandler)))
-	mux.HandleFunc("GET /start", logging.Middleware(auth.Middleware(routes.StartHandler)))
-	mux.HandleFunc("GET /comingsoon", logging.Middleware(auth.Middleware(routes.ComingSoonHandler)))
-	mux.HandleFunc("GET /all", logging.Middleware(auth.Middleware(routes.AllHandler)))
-
-	// search pages
-	mux.HandleFunc("GET /search", logging.Middleware(auth.Middleware(routes.SearchHandler)))
-	mux.HandleFunc("POST /search", logging.Middleware(auth.Middleware(routes.SearchResultsHandler)))
-	mux.HandleFunc("POST /bulk_add", logging.Middleware(auth.Middleware(routes.BulkAddHandler)))
-	mux.HandleFunc("GET /show/details", logging.Middleware(auth.Middleware(routes.ShowDetailsHandler)))
-	mux.HandleFunc("GET /autofill", logging.Middleware(auth.Middleware(routes.AutofillHandler)))
+	cfg, options, err := config.Load(os.Args[1:], os.Getenv)
+	if err == flag.ErrHelp {
+		return
+	}
+	if err != nil {
+		fatal("Invalid config", err)
+	}
 
-	// show actions
-	mux.HandleFunc("GET /show/add", logging.Middleware(auth.Middleware(routes.AddShowHandler)))
-	mux.HandleFunc("GET /show/remove", logging.Middleware(auth.Middleware(routes.RemoveShowHandler)))
-	mux.HandleFunc("GET /show/watched", logging.Middleware(auth.Middleware(routes.WatchedHandler)))
-	mux.HandleFunc("GET /show/unwatched", logging.Middleware(auth.Middleware(routes.UnwatchedHandler)))
+	if options.PrintConfig {
+		cfg.Print(os.Stdout)
+		return
+	}
 
-	server := &http.Server{
-		Addr:    ":8080",
-		Handler: mux,
+	err = logging.Setup(cfg.Log.Format, cfg.Log.Verbose)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if options.File != "" {
+		slog.Info("Loaded config", "file", options.File)
 	}
 
-	// Run server
-	go func() {
-		log.Println("Server running on http://localhost:8080")
+	args := options.Args
 
-		err := server.ListenAndServe()
-		if err != nil && err != http.ErrServerClosed {
-			log.Fatalf("Server error: %v", err)
+	// checks the running server, so it doesn't need the database
+	if len(args) > 0 && args[0] == "healthcheck" {
+		err := healthcheckCommand(args[1:], cfg.Server.Addr)
+		if err != nil {
+			fatal("Health check failed", err)
 		}
-	}()
+		return
+	}
 
-	stop := make(chan os.Signal, 1)
-	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	dbClose := db.Setup(cfg.Database)
+	defer dbClose()
 
-	// Wait for signal
-	<-stop
-	log.Println("Shutting down server...")
+	err = tvdbapi.Setup(cfg.Provider)
+	if err != nil {
+		fatal("Failed to setup provider", err)
+	}
 
-	// Graceful shutdown
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+	cache, err := posters.Setup(cfg.Posters)
+	if err != nil {
+		fatal("Failed to setup posters", err)
+	}
 
-	// Shutdown HTTP server
-	err := server.Shutdown(ctx)
+	scheduler.Register(scheduler.Job{
+		Name: "refresh-shows",
+		// only shows older than their TTL are refreshed
+		Interval: cfg.Jobs.RefreshInterval,
+		Run:      tvdbapi.RefreshShows,
+	})
+	scheduler.Register(scheduler.Job{
+		Name:     "popular-shows",
+		Interval: cfg.Jobs.PopularShowsInterval,
+		// only kept in memory for autofill
+		RunAtStartup: true,
+		Run:          tvdbapi.LoadPopularShows,
+	})
+	scheduler.Register(scheduler.Job{
+		Name:     "poster-cleanup",
+		Interval: cfg.Jobs.PosterCleanupInterval,
+		Run:      cache.Cleanup,
+	})
+	// Postgres has its own backup tools
+	if cfg.Database.Driver == db.SQLite && cfg.Database.Backups.Dir != "" {
+		scheduler.Register(scheduler.Job{
+			Name:     "backup",
+			Interval: cfg.Jobs.BackupInterval,
+			Run:      db.RunBackups,
+		})
+	}
+
+	err = runCommand(cfg, cache, args)
 	if err != nil {
-		log.Fatalf("Server shutdown failed: %v", err)
+		fatal("Command failed", err)
 	}
+}
 
-	log.Println("Server shutdown complete.")
+// fatal logs the error and exits, like log.Fatal
+func fatal(msg string, err error) {
+	slog.Error(msg, "err", err)
+	os.Exit(1)
 }
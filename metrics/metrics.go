@@ -0,0 +1,288 @@
+// Package metrics collects counters, histograms and gauges and serves them
+// at /metrics in the Prometheus text format.
+package metrics
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// DefaultBuckets suit web requests, from 5ms to 10s
+	DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+	// QueryBuckets suit database queries, from 100µs to 1s
+	QueryBuckets = []float64{.0001, .00025, .0005, .001, .0025, .005, .01, .025, .05, .1, .25, 1}
+	// JobBuckets suit background jobs, from 1s to an hour
+	JobBuckets = []float64{1, 5, 15, 60, 300, 900, 3600}
+)
+
+// collector is anything that can write itself out for a scrape
+type collector interface {
+	name() string
+	write(w io.Writer)
+}
+
+var (
+	registryMu sync.Mutex
+	registry   = map[string]collector{}
+)
+
+func register(c collector) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if _, ok := registry[c.name()]; ok {
+		panic("metrics: " + c.name() + " registered twice")
+	}
+	registry[c.name()] = c
+}
+
+// series holds the values of a metric for each combination of label values
+type series struct {
+	metric string
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string][]string
+}
+
+// key identifies a combination of label values, which are kept to write out
+func (s *series) key(labelValues []string) string {
+	if len(labelValues) != len(s.labels) {
+		panic(fmt.Sprintf("metrics: %s takes %d label values, got %d", s.metric, len(s.labels), len(labelValues)))
+	}
+
+	key := strings.Join(labelValues, "\xff")
+	if _, ok := s.values[key]; !ok {
+		s.values[key] = append([]string(nil), labelValues...)
+	}
+	return key
+}
+
+// sortedKeys returns the keys in a stable order, so scrapes are easy to diff
+func (s *series) sortedKeys() []string {
+	keys := make([]string, 0, len(s.values))
+	for key := range s.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (s *series) name() string {
+	return s.metric
+}
+
+func (s *series) header(w io.Writer, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", s.metric, s.help, s.metric, kind)
+}
+
+// Counter is a value that only goes up, e.g. requests served
+type Counter struct {
+	series
+	counts map[string]float64
+}
+
+// NewCounter registers a counter with the label names its values are split by
+func NewCounter(name string, help string, labels ...string) *Counter {
+	c := &Counter{
+		series: series{metric: name, help: help, labels: labels, values: map[string][]string{}},
+		counts: map[string]float64{},
+	}
+	register(c)
+	return c
+}
+
+// Inc adds one to the counter for the label values
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds v to the counter for the label values
+func (c *Counter) Add(v float64, labelValues ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.counts[c.key(labelValues)] += v
+}
+
+func (c *Counter) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.header(w, "counter")
+	for _, key := range c.sortedKeys() {
+		fmt.Fprintf(w, "%s%s %s\n", c.metric, formatLabels(c.labels, c.values[key]), formatValue(c.counts[key]))
+	}
+}
+
+// Histogram counts observations, e.g. request durations, into buckets
+type Histogram struct {
+	series
+	buckets []float64
+	counts  map[string][]uint64
+	sums    map[string]float64
+	totals  map[string]uint64
+}
+
+// NewHistogram registers a histogram with the upper bounds of its buckets,
+// in increasing order, and the label names its values are split by
+func NewHistogram(name string, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{
+		series:  series{metric: name, help: help, labels: labels, values: map[string][]string{}},
+		buckets: buckets,
+		counts:  map[string][]uint64{},
+		sums:    map[string]float64{},
+		totals:  map[string]uint64{},
+	}
+	register(h)
+	return h
+}
+
+// Observe records v for the label values
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	key := h.key(labelValues)
+	counts, ok := h.counts[key]
+	if !ok {
+		counts = make([]uint64, len(h.buckets))
+		h.counts[key] = counts
+	}
+	for i, bound := range h.buckets {
+		if v <= bound {
+			counts[i]++
+		}
+	}
+	h.sums[key] += v
+	h.totals[key]++
+}
+
+// Count returns how many values have been observed for the label values
+func (h *Histogram) Count(labelValues ...string) uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.totals[h.key(labelValues)]
+}
+
+// Since records the seconds since start for the label values
+func (h *Histogram) Since(start time.Time, labelValues ...string) {
+	h.Observe(time.Since(start).Seconds(), labelValues...)
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.header(w, "histogram")
+	labels := append(append([]string(nil), h.labels...), "le")
+	for _, key := range h.sortedKeys() {
+		values := h.values[key]
+		bucketValues := append(append([]string(nil), values...), "")
+		for i, bound := range h.buckets {
+			bucketValues[len(values)] = formatValue(bound)
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.metric, formatLabels(labels, bucketValues), h.counts[key][i])
+		}
+		bucketValues[len(values)] = "+Inf"
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.metric, formatLabels(labels, bucketValues), h.totals[key])
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.metric, formatLabels(h.labels, values), formatValue(h.sums[key]))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.metric, formatLabels(h.labels, values), h.totals[key])
+	}
+}
+
+// gaugeFunc is a value read when it's scraped, e.g. how many users there are
+type gaugeFunc struct {
+	metric string
+	help   string
+	read   func() (float64, error)
+}
+
+// NewGaugeFunc registers a gauge whose value comes from read on every scrape.
+// If read fails the gauge is left out of that scrape.
+func NewGaugeFunc(name string, help string, read func() (float64, error)) {
+	register(&gaugeFunc{metric: name, help: help, read: read})
+}
+
+func (g *gaugeFunc) name() string {
+	return g.metric
+}
+
+func (g *gaugeFunc) write(w io.Writer) {
+	v, err := g.read()
+	if err != nil {
+		slog.Error("Failed to read gauge", "metric", g.metric, "err", err)
+		return
+	}
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %s\n", g.metric, g.help, g.metric, g.metric, formatValue(v))
+}
+
+func formatLabels(names []string, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(name)
+		b.WriteString(`="`)
+		b.WriteString(labelReplacer.Replace(values[i]))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+var labelReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatValue(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Write writes every metric in the Prometheus text format
+func Write(w io.Writer) {
+	registryMu.Lock()
+	collectors := make([]collector, 0, len(registry))
+	for _, c := range registry {
+		collectors = append(collectors, c)
+	}
+	registryMu.Unlock()
+
+	sort.Slice(collectors, func(i, j int) bool {
+		return collectors[i].name() < collectors[j].name()
+	})
+	for _, c := range collectors {
+		c.write(w)
+	}
+}
+
+// Handler serves the metrics, GET /metrics. If token isn't empty scrapers
+// must send it as a bearer token.
+func Handler(token string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if token != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+token)) != 1 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		Write(w)
+	}
+}
@@ -0,0 +1,87 @@
+package metrics
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func scrape(t *testing.T) string {
+	t.Helper()
+
+	var b strings.Builder
+	Write(&b)
+	return b.String()
+}
+
+func TestCounter(t *testing.T) {
+	c := NewCounter("test_requests_total", "Requests.", "route", "status")
+	c.Inc("GET /", "200")
+	c.Inc("GET /", "200")
+	c.Add(3, `GET /show/"{id}"`, "404")
+
+	out := scrape(t)
+	for _, want := range []string{
+		"# HELP test_requests_total Requests.\n# TYPE test_requests_total counter\n",
+		`test_requests_total{route="GET /",status="200"} 2` + "\n",
+		`test_requests_total{route="GET /show/\"{id}\"",status="404"} 3` + "\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("scrape is missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	h := NewHistogram("test_duration_seconds", "Durations.", []float64{0.1, 1}, "job")
+	h.Observe(0.05, "a")
+	h.Observe(0.5, "a")
+	h.Observe(5, "a")
+
+	out := scrape(t)
+	for _, want := range []string{
+		"# TYPE test_duration_seconds histogram\n",
+		`test_duration_seconds_bucket{job="a",le="0.1"} 1` + "\n",
+		`test_duration_seconds_bucket{job="a",le="1"} 2` + "\n",
+		`test_duration_seconds_bucket{job="a",le="+Inf"} 3` + "\n",
+		`test_duration_seconds_sum{job="a"} 5.55` + "\n",
+		`test_duration_seconds_count{job="a"} 3` + "\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("scrape is missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestGaugeFunc(t *testing.T) {
+	NewGaugeFunc("test_users", "Users.", func() (float64, error) { return 42, nil })
+	NewGaugeFunc("test_broken", "Broken.", func() (float64, error) { return 0, errors.New("no database") })
+
+	out := scrape(t)
+	if !strings.Contains(out, "# TYPE test_users gauge\ntest_users 42\n") {
+		t.Errorf("scrape is missing test_users:\n%s", out)
+	}
+	if strings.Contains(out, "test_broken") {
+		t.Errorf("scrape includes a gauge that failed to read:\n%s", out)
+	}
+}
+
+func TestHandlerToken(t *testing.T) {
+	handler := Handler("secret")
+
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest("GET", "/metrics", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("scrape without the token = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	w = httptest.NewRecorder()
+	handler(w, req)
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") {
+		t.Errorf("scrape with the token = %d %s, want the metrics", w.Code, w.Header().Get("Content-Type"))
+	}
+}
@@ -0,0 +1,282 @@
+// Package posters keeps a local copy of show posters, so pages don't hot-link
+// the provider's CDN.
+package posters
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jccroft1/goshowtrack/db"
+	"github.com/jccroft1/goshowtrack/tvdbapi"
+)
+
+// largest poster downloaded, TMDB's are well under 1MB
+const maxPosterSize = 5 << 20
+
+var client = &http.Client{
+	Timeout: 10 * time.Second,
+}
+
+// Options configure where posters are kept
+type Options struct {
+	// Dir holds the downloaded images, by default the posters directory
+	// next to the SQLite database
+	Dir string
+}
+
+// Cache is the directory posters are downloaded to. They're stored by the
+// SHA-256 of their content, so shows sharing an image only store it once.
+type Cache struct {
+	dir string
+}
+
+// Setup returns the poster cache in options.Dir, creating the directory
+func Setup(options Options) (*Cache, error) {
+	if options.Dir == "" {
+		return nil, errors.New("a poster directory is required")
+	}
+
+	err := os.MkdirAll(options.Dir, 0o755)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create poster directory: %v", err)
+	}
+	return &Cache{dir: options.Dir}, nil
+}
+
+// Poster is a cached image on disk
+type Poster struct {
+	Path        string
+	Hash        string
+	ContentType string
+	FetchedAt   time.Time
+}
+
+// Get returns the show's poster in size, downloading it the first time it's
+// requested. It returns nil if the show doesn't have a poster.
+func (c *Cache) Get(ctx context.Context, showID int, size string) (*Poster, error) {
+	cached, source, err := c.load(showID, size)
+	if err != nil {
+		return nil, err
+	}
+
+	// only look up shows that aren't cached the first time, their poster
+	// isn't refreshed with the show so the cached copy is as good as any
+	url, err := tvdbapi.PosterURL(ctx, showID, size, cached == nil)
+	if err != nil {
+		// keep working offline with whatever is cached
+		if cached != nil {
+			slog.WarnContext(ctx, "Failed to check poster, using cached copy", "show", showID, "err", err)
+			return cached, nil
+		}
+		return nil, err
+	}
+	if url == "" {
+		return cached, nil
+	}
+	if cached != nil && source == url {
+		return cached, nil
+	}
+
+	poster, err := c.fetch(ctx, showID, size, url)
+	if err != nil {
+		if cached != nil {
+			slog.WarnContext(ctx, "Failed to update poster, using cached copy", "show", showID, "err", err)
+			return cached, nil
+		}
+		return nil, err
+	}
+	return poster, nil
+}
+
+// load returns the cached poster and the URL it came from, or nil if it's not cached
+func (c *Cache) load(showID int, size string) (*Poster, string, error) {
+	var poster Poster
+	var source, fetchedAt string
+	err := db.Connection.QueryRow(`SELECT source_url, hash, content_type, fetched_at FROM posters WHERE show_id = ? AND size = ?;`,
+		showID, size).Scan(&source, &poster.Hash, &poster.ContentType, &fetchedAt)
+	if err == sql.ErrNoRows {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to load poster: %v", err)
+	}
+
+	poster.Path = c.hashPath(poster.Hash)
+	poster.FetchedAt, _ = time.Parse(time.RFC3339, fetchedAt)
+
+	// the file has been removed from under us, fetch it again
+	_, err = os.Stat(poster.Path)
+	if err != nil {
+		return nil, "", nil
+	}
+
+	return &poster, source, nil
+}
+
+// fetch downloads the poster and saves it
+func (c *Cache) fetch(ctx context.Context, showID int, size string, url string) (*Poster, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download poster: %v", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download poster %s: %s", url, res.Status)
+	}
+	contentType, _, _ := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	if !strings.HasPrefix(contentType, "image/") {
+		return nil, fmt.Errorf("poster %s isn't an image: %q", url, contentType)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(res.Body, maxPosterSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to download poster: %v", err)
+	}
+	if len(data) > maxPosterSize {
+		return nil, fmt.Errorf("poster %s is larger than %d bytes", url, maxPosterSize)
+	}
+
+	sum := sha256.Sum256(data)
+	poster := &Poster{
+		Hash:        hex.EncodeToString(sum[:]),
+		ContentType: contentType,
+		FetchedAt:   time.Now().UTC().Truncate(time.Second),
+	}
+	poster.Path = c.hashPath(poster.Hash)
+
+	err = writeFile(poster.Path, data)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = db.Connection.Exec(`INSERT INTO posters (show_id, size, source_url, hash, content_type, fetched_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(show_id, size) DO UPDATE SET
+			source_url = excluded.source_url,
+			hash = excluded.hash,
+			content_type = excluded.content_type,
+			fetched_at = excluded.fetched_at;`,
+		showID, size, url, poster.Hash, poster.ContentType, poster.FetchedAt.Format(time.RFC3339))
+	if err != nil {
+		return nil, fmt.Errorf("failed to save poster: %v", err)
+	}
+
+	return poster, nil
+}
+
+// hashPath spreads files over subdirectories by the start of their hash
+func (c *Cache) hashPath(hash string) string {
+	return filepath.Join(c.dir, hash[:2], hash)
+}
+
+// writeFile writes the file unless it already exists. It's written to a
+// temporary file first so a partial image is never served.
+func writeFile(path string, data []byte) error {
+	_, err := os.Stat(path)
+	if err == nil {
+		return nil
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return fmt.Errorf("failed to create poster directory: %v", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to save poster: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save poster: %v", err)
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// Cleanup removes posters for shows no user tracks any more, then any files
+// no longer used by a poster
+func (c *Cache) Cleanup(ctx context.Context) error {
+	_, err := db.Connection.ExecContext(ctx, `DELETE FROM posters WHERE show_id NOT IN (SELECT show_id FROM user_shows);`)
+	if err != nil {
+		return fmt.Errorf("failed to remove untracked posters: %v", err)
+	}
+
+	used := map[string]bool{}
+	rows, err := db.Connection.QueryContext(ctx, `SELECT DISTINCT hash FROM posters;`)
+	if err != nil {
+		return fmt.Errorf("failed to load posters: %v", err)
+	}
+	for rows.Next() {
+		var hash string
+		err = rows.Scan(&hash)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		used[hash] = true
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	removed := 0
+	err = filepath.WalkDir(c.dir, func(path string, d os.DirEntry, err error) error {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if d.IsDir() || used[d.Name()] {
+			return nil
+		}
+
+		// leave downloads in progress alone
+		if strings.HasPrefix(d.Name(), ".tmp-") {
+			info, err := d.Info()
+			if err != nil || time.Since(info.ModTime()) < time.Hour {
+				return nil
+			}
+		}
+
+		err = os.Remove(path)
+		if err != nil {
+			return err
+		}
+		removed++
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to remove unused posters: %v", err)
+	}
+
+	slog.InfoContext(ctx, "Removed unused posters", "posters", removed)
+	return nil
+}
@@ -0,0 +1,140 @@
+package posters
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/jccroft1/goshowtrack/db"
+	"github.com/jccroft1/goshowtrack/db/dbtest"
+)
+
+// setupTest gives each test empty posters and user_shows tables and a poster directory
+func setupTest(t *testing.T) *Cache {
+	t.Helper()
+
+	dbtest.Open(t)
+	cache, err := Setup(Options{Dir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cache
+}
+
+func imageServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/a.jpg", "/copy-of-a.jpg":
+			w.Header().Set("Content-Type", "image/jpeg")
+			w.Write([]byte("poster a"))
+		case "/b.jpg":
+			w.Header().Set("Content-Type", "image/jpeg")
+			w.Write([]byte("poster b"))
+		case "/page.html":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("<html></html>"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestFetchStoresByContent(t *testing.T) {
+	cache := setupTest(t)
+	server := imageServer(t)
+
+	a, err := cache.fetch(context.Background(), 1, "small", server.URL+"/a.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(a.Path)
+	if err != nil || string(data) != "poster a" {
+		t.Fatalf("poster file = %q, %v, want the downloaded image", data, err)
+	}
+	if a.ContentType != "image/jpeg" {
+		t.Errorf("content type = %q, want image/jpeg", a.ContentType)
+	}
+
+	// the same image for another show is stored once
+	copyOfA, err := cache.fetch(context.Background(), 2, "small", server.URL+"/copy-of-a.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if copyOfA.Path != a.Path {
+		t.Errorf("identical posters stored at %s and %s", a.Path, copyOfA.Path)
+	}
+
+	cached, source, err := cache.load(1, "small")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cached == nil || cached.Hash != a.Hash || source != server.URL+"/a.jpg" {
+		t.Errorf("load = %+v, %q, want the fetched poster", cached, source)
+	}
+
+	// a new source replaces the cached poster
+	b, err := cache.fetch(context.Background(), 1, "small", server.URL+"/b.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cached, _, err = cache.load(1, "small")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cached.Hash != b.Hash {
+		t.Errorf("cached hash = %s, want the new poster %s", cached.Hash, b.Hash)
+	}
+
+	for _, path := range []string{"/missing.jpg", "/page.html"} {
+		_, err = cache.fetch(context.Background(), 3, "small", server.URL+path)
+		if err == nil {
+			t.Errorf("fetch %s succeeded, want an error", path)
+		}
+	}
+}
+
+func TestCleanupRemovesUntrackedPosters(t *testing.T) {
+	cache := setupTest(t)
+	server := imageServer(t)
+
+	tracked, err := cache.fetch(context.Background(), 1, "large", server.URL+"/a.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	untracked, err := cache.fetch(context.Background(), 2, "large", server.URL+"/b.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Connection.Exec(`INSERT INTO user_shows (user_id, show_id) VALUES (1, 1);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = cache.Cleanup(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = os.Stat(tracked.Path)
+	if err != nil {
+		t.Errorf("tracked poster removed: %v", err)
+	}
+	_, err = os.Stat(untracked.Path)
+	if !os.IsNotExist(err) {
+		t.Errorf("untracked poster kept: %v", err)
+	}
+
+	cached, _, err := cache.load(2, "large")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cached != nil {
+		t.Errorf("untracked poster still cached: %+v", cached)
+	}
+}
@@ -1,25 +1,60 @@
 package routes
 
 import (
+	"log/slog"
 	"net/http"
 
 	"github.com/jccroft1/goshowtrack/auth"
 )
 
 func AboutHandler(w http.ResponseWriter, req *http.Request) {
+	renderAbout(w, req, "")
+}
+
+// renderAbout renders the about page, newToken is shown once after it's created
+func renderAbout(w http.ResponseWriter, req *http.Request, newToken string) {
 	email, ok := auth.GetUserEmail(req)
 	if !ok {
 		http.Error(w, "User not authenticated", http.StatusUnauthorized)
 		return
 	}
 
+	userID, ok := auth.GetUserID(req)
+	if !ok {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	calendar, err := calendarURL(req, userID)
+	if err != nil {
+		slog.ErrorContext(req.Context(), "Failed to get calendar URL", "err", err)
+		http.Error(w, "Error querying database", http.StatusInternalServerError)
+		return
+	}
+
+	tokens, err := auth.ListTokens(userID)
+	if err != nil {
+		slog.ErrorContext(req.Context(), "Failed to list API tokens", "err", err)
+		http.Error(w, "Error querying database", http.StatusInternalServerError)
+		return
+	}
+
 	type AboutData struct {
-		Email string
+		Email       string
+		CalendarURL string
+		// signed in with OIDC rather than Cloudflare Access
+		Session  bool
+		Tokens   []auth.Token
+		NewToken string
 	}
 
 	data := AboutData{
-		Email: email,
+		Email:       email,
+		CalendarURL: calendar,
+		Session:     auth.HasSession(req),
+		Tokens:      tokens,
+		NewToken:    newToken,
 	}
 
-	renderTemplate(w, "about", data)
+	renderTemplate(w, req, "about", data)
 }
@@ -2,13 +2,11 @@ package routes
 
 import (
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"strconv"
 
 	"github.com/jccroft1/goshowtrack/auth"
-	"github.com/jccroft1/goshowtrack/db"
-	"github.com/jccroft1/goshowtrack/tvdbapi"
 )
 
 func AddShowHandler(w http.ResponseWriter, r *http.Request) {
@@ -20,25 +18,25 @@ func RemoveShowHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func userShowUpdate(w http.ResponseWriter, r *http.Request, add bool) {
-	queryStr := r.URL.Query().Get("id")
+	queryStr := r.FormValue("id")
 	if queryStr == "" {
-		log.Println("No ID provided")
+		slog.WarnContext(r.Context(), "No ID provided")
 		http.Error(w, "No ID provided", http.StatusBadRequest)
 		return
 	}
 
 	query, err := strconv.Atoi(queryStr)
 	if err != nil {
-		log.Println("Invalid ID provided", err)
+		slog.WarnContext(r.Context(), "Invalid ID provided", "err", err)
 		http.Error(w, "Invalid ID provided", http.StatusBadRequest)
 		return
 	}
 
 	// not strictly necessary but checks the show is valid and loads into cache
-	showDetails, err := tvdbapi.GetShowDetails(query, false)
+	showDetails, err := repo.Show(r.Context(), query, false)
 	if err != nil {
-		log.Println("Error searching TVDB: ", err)
-		http.Error(w, "Error searching TVDB", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Error searching TVDB", "err", err)
+		http.Error(w, "Error searching TVDB", providerStatus(err))
 		return
 	}
 
@@ -49,19 +47,16 @@ func userShowUpdate(w http.ResponseWriter, r *http.Request, add bool) {
 	}
 
 	if add {
-		// SQL to add show to user
-		err := addShow(userID, showDetails.ID)
+		err := repo.AddShow(r.Context(), userID, showDetails.ID)
 		if err != nil {
-			log.Println("Error adding show to user:", err)
+			slog.ErrorContext(r.Context(), "Error adding show to user", "err", err)
 			http.Error(w, "Failed to add show to user", http.StatusInternalServerError)
 			return
 		}
 	} else {
-		// SQL to remove show from user
-		sqlQuery := `DELETE FROM user_shows WHERE user_id = ? AND show_id = ?;`
-		_, err := db.Connection.Exec(sqlQuery, userID, showDetails.ID)
+		err := repo.RemoveShow(r.Context(), userID, showDetails.ID)
 		if err != nil {
-			log.Println("Error adding show to user:", err)
+			slog.ErrorContext(r.Context(), "Error adding show to user", "err", err)
 			http.Error(w, "Failed to add show to user", http.StatusInternalServerError)
 			return
 		}
@@ -71,18 +66,3 @@ func userShowUpdate(w http.ResponseWriter, r *http.Request, add bool) {
 	// redirect to show details page
 	http.Redirect(w, r, fmt.Sprintf("/show/details?id=%v", showDetails.ID), http.StatusSeeOther)
 }
-
-func addShow(userID int64, showID int) error {
-	alreadyAdded := userHasAddedShow(userID, showID)
-	if alreadyAdded {
-		return nil
-	}
-
-	sqlQuery := `INSERT INTO user_shows (user_id, show_id) VALUES (?, ?);`
-	_, err := db.Connection.Exec(sqlQuery, userID, showID)
-	if err != nil {
-		return fmt.Errorf("error adding show to user: %v", err)
-	}
-
-	return nil
-}
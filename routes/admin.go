@@ -0,0 +1,96 @@
+package routes
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/jccroft1/goshowtrack/auth"
+	"github.com/jccroft1/goshowtrack/scheduler"
+)
+
+// AdminJobsHandler lists the background jobs, GET /admin/jobs
+func AdminJobsHandler(w http.ResponseWriter, r *http.Request) {
+	jobs, err := scheduler.List()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to list jobs", "err", err)
+		http.Error(w, "Error querying database", http.StatusInternalServerError)
+		return
+	}
+
+	renderTemplate(w, r, "adminJobs", jobs)
+}
+
+// AdminRunJobHandler starts a job now, POST /admin/jobs/{name}/run
+func AdminRunJobHandler(w http.ResponseWriter, r *http.Request) {
+	err := scheduler.Trigger(r.PathValue("name"))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to start job", "err", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	http.Redirect(w, r, "/admin/jobs", http.StatusSeeOther)
+}
+
+// AdminUsersHandler lists the users, GET /admin/users
+func AdminUsersHandler(w http.ResponseWriter, r *http.Request) {
+	users, err := auth.ListUsers()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to list users", "err", err)
+		http.Error(w, "Error querying database", http.StatusInternalServerError)
+		return
+	}
+
+	currentID, _ := auth.GetUserID(r)
+
+	type AdminUsersData struct {
+		Users     []auth.User
+		CurrentID int64
+	}
+
+	renderTemplate(w, r, "adminUsers", AdminUsersData{
+		Users:     users,
+		CurrentID: currentID,
+	})
+}
+
+// AdminUpdateUserHandler changes a user, POST /admin/users/{id} with action
+// disable, enable, make-admin, remove-admin or delete
+func AdminUpdateUserHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	// admins can't lock themselves out
+	currentID, _ := auth.GetUserID(r)
+	if userID == currentID {
+		http.Error(w, "You can't change your own account", http.StatusBadRequest)
+		return
+	}
+
+	switch r.FormValue("action") {
+	case "disable":
+		err = auth.SetDisabled(userID, true)
+	case "enable":
+		err = auth.SetDisabled(userID, false)
+	case "make-admin":
+		err = auth.SetAdmin(userID, true)
+	case "remove-admin":
+		err = auth.SetAdmin(userID, false)
+	case "delete":
+		err = auth.DeleteUser(userID)
+	default:
+		http.Error(w, "Unknown action", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to update user", "user", userID, "err", err)
+		http.Error(w, "Failed to update user", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
@@ -0,0 +1,303 @@
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/jccroft1/goshowtrack/auth"
+	"github.com/jccroft1/goshowtrack/store"
+	"github.com/jccroft1/goshowtrack/tvdbapi"
+)
+
+// JSON versions of the HTML pages, registered under /api/v1
+
+func writeJSON(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	err := json.NewEncoder(w).Encode(data)
+	if err != nil {
+		slog.Error("Failed to write JSON response", "err", err)
+	}
+}
+
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+// pathInt parses a numeric path wildcard, e.g. {id}
+func pathInt(r *http.Request, name string) (int, bool) {
+	value, err := strconv.Atoi(r.PathValue(name))
+	if err != nil {
+		return 0, false
+	}
+	return value, true
+}
+
+// apiShow loads the show in the {id} wildcard, writing the error response if it fails
+func apiShow(w http.ResponseWriter, r *http.Request) (*tvdbapi.ShowDetail, bool) {
+	showID, ok := pathInt(r, "id")
+	if !ok {
+		writeJSONError(w, http.StatusBadRequest, "Invalid ID provided")
+		return nil, false
+	}
+
+	show, err := repo.Show(r.Context(), showID, false)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error searching TVDB", "err", err)
+		writeJSONError(w, providerStatus(err), "Error searching TVDB")
+		return nil, false
+	}
+
+	return show, true
+}
+
+// APISearchHandler searches the provider, GET /api/v1/search?query=
+func APISearchHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserID(r)
+	if !ok {
+		writeJSONError(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	query := r.URL.Query().Get("query")
+	if query == "" {
+		writeJSONError(w, http.StatusBadRequest, "Query is required")
+		return
+	}
+
+	searchResults, err := searchShows(r.Context(), query)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Search failed", "err", err)
+		writeJSONError(w, providerStatus(err), "Failed to search TVDB")
+		return
+	}
+
+	type Result struct {
+		ID          int    `json:"id"`
+		Name        string `json:"name"`
+		AirDate     string `json:"air_date"`
+		Description string `json:"description"`
+		Poster      string `json:"poster"`
+		Added       bool   `json:"added"`
+	}
+
+	added := userAddedShows(r.Context(), userID)
+	results := make([]Result, len(searchResults))
+	for i, show := range searchResults {
+		results[i] = Result{
+			ID:          show.ID,
+			Name:        show.Name,
+			AirDate:     show.AirDate,
+			Description: show.Description,
+			Poster:      show.PosterPath,
+			Added:       added[show.ID],
+		}
+	}
+
+	writeJSON(w, http.StatusOK, results)
+}
+
+// APIShowHandler returns a show with the user's progress, GET /api/v1/shows/{id}
+func APIShowHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserID(r)
+	if !ok {
+		writeJSONError(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	show, ok := apiShow(w, r)
+	if !ok {
+		return
+	}
+
+	data, err := buildDetails(r.Context(), userID, show)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to get users watched episodes", "err", err)
+		writeJSONError(w, http.StatusInternalServerError, "Error querying database")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, data)
+}
+
+// APIListHandler returns the user's shows, GET /api/v1/list?filter=home|start|comingsoon|all&sort=
+func APIListHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserID(r)
+	if !ok {
+		writeJSONError(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	filter := r.URL.Query().Get("filter")
+	if filter == "" {
+		filter = "all"
+	}
+
+	op, ok := listFilterByName(filter, r.URL.Query().Get("sort"))
+	if !ok {
+		writeJSONError(w, http.StatusBadRequest, "Unknown filter, expected home, start, comingsoon or all")
+		return
+	}
+
+	list, err := loadUserList(r.Context(), userID, op)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error fetch user show list", "err", err)
+		writeJSONError(w, http.StatusInternalServerError, "Failed to fetch user shows")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, list)
+}
+
+// APIAddShowHandler adds a show to the user's list, PUT /api/v1/list/{id}
+func APIAddShowHandler(w http.ResponseWriter, r *http.Request) {
+	apiShowUpdate(w, r, true)
+}
+
+// APIRemoveShowHandler removes a show from the user's list, DELETE /api/v1/list/{id}
+func APIRemoveShowHandler(w http.ResponseWriter, r *http.Request) {
+	apiShowUpdate(w, r, false)
+}
+
+func apiShowUpdate(w http.ResponseWriter, r *http.Request, add bool) {
+	userID, ok := auth.GetUserID(r)
+	if !ok {
+		writeJSONError(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	show, ok := apiShow(w, r)
+	if !ok {
+		return
+	}
+
+	var err error
+	if add {
+		err = repo.AddShow(r.Context(), userID, show.ID)
+	} else {
+		err = repo.RemoveShow(r.Context(), userID, show.ID)
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error updating user shows", "err", err)
+		writeJSONError(w, http.StatusInternalServerError, "Failed to update user shows")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"id": show.ID, "added": add})
+}
+
+type apiProgress struct {
+	ShowID       int                `json:"show_id"`
+	EpisodeCount int                `json:"episode_count"`
+	WatchedCount int                `json:"watched_count"`
+	Unwatched    int                `json:"unwatched"`
+	Watched      []store.EpisodeKey `json:"watched"`
+}
+
+func buildAPIProgress(ctx context.Context, userID int64, show *tvdbapi.ShowDetail) (apiProgress, error) {
+	progress, err := getWatchProgress(ctx, userID, show.ID)
+	if err != nil {
+		return apiProgress{}, err
+	}
+
+	episodes := allEpisodes(show.Seasons)
+	data := apiProgress{
+		ShowID:       show.ID,
+		EpisodeCount: len(episodes),
+		WatchedCount: progress.watchedCount(episodes),
+		Unwatched:    len(episodesToWatch(show.Seasons, progress)),
+		Watched:      []store.EpisodeKey{},
+	}
+	for _, e := range episodes {
+		if progress.Watched(e) {
+			data.Watched = append(data.Watched, store.EpisodeKey{Season: e.SeasonNumber, Episode: e.Number})
+		}
+	}
+
+	return data, nil
+}
+
+// APIProgressHandler returns the episodes the user has watched, GET /api/v1/shows/{id}/progress
+func APIProgressHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserID(r)
+	if !ok {
+		writeJSONError(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	show, ok := apiShow(w, r)
+	if !ok {
+		return
+	}
+
+	data, err := buildAPIProgress(r.Context(), userID, show)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to get users watched episodes", "err", err)
+		writeJSONError(w, http.StatusInternalServerError, "Error querying database")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, data)
+}
+
+// APIWatchedHandler marks a season (and the ones before it) or a single episode watched,
+// PUT /api/v1/shows/{id}/seasons/{season}/watched
+// PUT /api/v1/shows/{id}/seasons/{season}/episodes/{episode}/watched
+func APIWatchedHandler(w http.ResponseWriter, r *http.Request) {
+	apiWatchedUpdate(w, r, true)
+}
+
+// APIUnwatchedHandler marks a season (and the ones after it) or a single episode unwatched,
+// DELETE /api/v1/shows/{id}/seasons/{season}/watched
+// DELETE /api/v1/shows/{id}/seasons/{season}/episodes/{episode}/watched
+func APIUnwatchedHandler(w http.ResponseWriter, r *http.Request) {
+	apiWatchedUpdate(w, r, false)
+}
+
+func apiWatchedUpdate(w http.ResponseWriter, r *http.Request, watched bool) {
+	userID, ok := auth.GetUserID(r)
+	if !ok {
+		writeJSONError(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	seasonNumber, ok := pathInt(r, "season")
+	if !ok {
+		writeJSONError(w, http.StatusBadRequest, "Invalid season provided")
+		return
+	}
+
+	episodeNumber := 0
+	if r.PathValue("episode") != "" {
+		episodeNumber, ok = pathInt(r, "episode")
+		if !ok {
+			writeJSONError(w, http.StatusBadRequest, "Invalid episode provided")
+			return
+		}
+	}
+
+	show, ok := apiShow(w, r)
+	if !ok {
+		return
+	}
+
+	err := updateWatched(r.Context(), userID, show, seasonNumber, episodeNumber, watched)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error updating watched episodes", "err", err)
+		writeJSONError(w, http.StatusInternalServerError, "Error updating watched episodes")
+		return
+	}
+
+	data, err := buildAPIProgress(r.Context(), userID, show)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to get users watched episodes", "err", err)
+		writeJSONError(w, http.StatusInternalServerError, "Error querying database")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, data)
+}
@@ -0,0 +1,432 @@
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/jccroft1/goshowtrack/auth"
+	"github.com/jccroft1/goshowtrack/store"
+	"github.com/jccroft1/goshowtrack/tvdbapi"
+)
+
+// apiServer serves the API and list pages as serve.go does. Auth is
+// disabled, so every request is from user 1.
+type apiServer struct {
+	t    *testing.T
+	mux  *http.ServeMux
+	csrf string
+}
+
+func newAPIServer(t *testing.T) *apiServer {
+	t.Helper()
+
+	err := auth.Setup(auth.Options{Disable: true, Secret: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	routes := map[string]func(http.ResponseWriter, *http.Request){
+		"GET /api/v1/search":                                                    APISearchHandler,
+		"GET /api/v1/shows/{id}":                                                APIShowHandler,
+		"GET /api/v1/shows/{id}/progress":                                       APIProgressHandler,
+		"PUT /api/v1/shows/{id}/seasons/{season}/watched":                       APIWatchedHandler,
+		"DELETE /api/v1/shows/{id}/seasons/{season}/watched":                    APIUnwatchedHandler,
+		"PUT /api/v1/shows/{id}/seasons/{season}/episodes/{episode}/watched":    APIWatchedHandler,
+		"DELETE /api/v1/shows/{id}/seasons/{season}/episodes/{episode}/watched": APIUnwatchedHandler,
+		"GET /api/v1/list":                                                      APIListHandler,
+		"PUT /api/v1/list/{id}":                                                 APIAddShowHandler,
+		"DELETE /api/v1/list/{id}":                                              APIRemoveShowHandler,
+		"GET /{$}":                                                              HomeHandler,
+		"GET /start":                                                            StartHandler,
+		"GET /comingsoon":                                                       ComingSoonHandler,
+		"GET /all":                                                              AllHandler,
+	}
+	s := &apiServer{t: t, mux: http.NewServeMux()}
+	for pattern, handler := range routes {
+		s.mux.HandleFunc(pattern, auth.Middleware(handler))
+	}
+
+	// any signed in response carries the CSRF token writes need
+	w := s.do(http.MethodGet, "/api/v1/list")
+	s.csrf = w.Header().Get(auth.CSRFHeader)
+	if s.csrf == "" {
+		t.Fatal("no CSRF token in the response")
+	}
+	return s
+}
+
+func (s *apiServer) do(method string, target string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	req.Header.Set(auth.CSRFHeader, s.csrf)
+	w := httptest.NewRecorder()
+	s.mux.ServeHTTP(w, req)
+	return w
+}
+
+// call runs the request, checks its status and decodes the JSON response into v
+func (s *apiServer) call(method string, target string, status int, v any) {
+	s.t.Helper()
+
+	w := s.do(method, target)
+	if w.Code != status {
+		s.t.Fatalf("%s %s status = %d, want %d: %s", method, target, w.Code, status, w.Body.String())
+	}
+	if got := w.Header().Get("Content-Type"); got != "application/json" {
+		s.t.Errorf("%s %s Content-Type = %q, want application/json", method, target, got)
+	}
+	err := json.Unmarshal(w.Body.Bytes(), v)
+	if err != nil {
+		s.t.Fatalf("%s %s returned invalid JSON: %v\n%s", method, target, err, w.Body.String())
+	}
+}
+
+// keys lists an object's fields in order, to check a response's shape
+func keys(object map[string]any) string {
+	var names []string
+	for name := range object {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, " ")
+}
+
+// apiShows are added to the fake store for the API tests: user 1 has
+// started show 1, hasn't started show 2 and is waiting on show 3
+func apiShows(t *testing.T) *fakeStore {
+	t.Helper()
+
+	fake := newFakeStore(t,
+		testShow(1, "Watching", "Ended", 2, false),
+		testShow(2, "Not Started", "Ended", 1, false),
+		testShow(3, "Waiting", "Returning Series", 1, true),
+		testShow(4, "Not Added", "Ended", 1, false),
+	)
+	ctx := context.Background()
+	for _, showID := range []int{1, 2, 3} {
+		fake.AddShow(ctx, 1, showID)
+	}
+	fake.SetWatched(ctx, 1, 1, []store.EpisodeKey{{Season: 1, Episode: 1}, {Season: 1, Episode: 2}})
+	fake.SetWatched(ctx, 1, 3, []store.EpisodeKey{{Season: 1, Episode: 1}, {Season: 1, Episode: 2}})
+	return fake
+}
+
+func TestAPISearch(t *testing.T) {
+	apiShows(t)
+	old := searchShows
+	searchShows = func(ctx context.Context, query string) ([]tvdbapi.Show, error) {
+		if query == "down" {
+			return nil, tvdbapi.ErrUnavailable
+		}
+		return []tvdbapi.Show{
+			{ID: 1, Name: "Watching", AirDate: "2020-01-01", Description: "On the list", PosterPath: "/1.jpg"},
+			{ID: 4, Name: "Not Added", AirDate: "2021-01-01"},
+		}, nil
+	}
+	t.Cleanup(func() { searchShows = old })
+	s := newAPIServer(t)
+
+	var results []map[string]any
+	s.call(http.MethodGet, "/api/v1/search?query=watch", http.StatusOK, &results)
+	if len(results) != 2 {
+		t.Fatalf("got %d results, want 2", len(results))
+	}
+	if got, want := keys(results[0]), "added air_date description id name poster"; got != want {
+		t.Errorf("result fields = %s, want %s", got, want)
+	}
+	if results[0]["id"] != 1.0 || results[0]["added"] != true || results[0]["poster"] != "/1.jpg" {
+		t.Errorf("first result = %v, want show 1 added", results[0])
+	}
+	if results[1]["id"] != 4.0 || results[1]["added"] != false {
+		t.Errorf("second result = %v, want show 4 not added", results[1])
+	}
+
+	tests := []struct {
+		target string
+		status int
+	}{
+		{target: "/api/v1/search", status: http.StatusBadRequest},
+		{target: "/api/v1/search?query=", status: http.StatusBadRequest},
+		{target: "/api/v1/search?query=down", status: http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		var body map[string]any
+		s.call(http.MethodGet, tt.target, tt.status, &body)
+		if keys(body) != "error" {
+			t.Errorf("%s error response = %v, want an error message", tt.target, body)
+		}
+	}
+}
+
+func TestAPIShow(t *testing.T) {
+	apiShows(t)
+	s := newAPIServer(t)
+
+	var details map[string]any
+	s.call(http.MethodGet, "/api/v1/shows/1", http.StatusOK, &details)
+	if got, want := keys(details), "added show"; got != want {
+		t.Fatalf("fields = %s, want %s", got, want)
+	}
+	show := details["show"].(map[string]any)
+	if got, want := keys(show), "air_date description id last_updated name poster seasons status unwatched"; got != want {
+		t.Errorf("show fields = %s, want %s", got, want)
+	}
+	if details["added"] != true || show["id"] != 1.0 || show["unwatched"] != 2.0 {
+		t.Errorf("details = %v, want show 1 added with 2 to watch", details)
+	}
+
+	seasons := show["seasons"].([]any)
+	if len(seasons) != 2 {
+		t.Fatalf("got %d seasons, want 2", len(seasons))
+	}
+	season := seasons[0].(map[string]any)
+	if got, want := keys(season), "end_date episode_count episodes number released start_date watched watched_count"; got != want {
+		t.Errorf("season fields = %s, want %s", got, want)
+	}
+	if season["watched"] != true || seasons[1].(map[string]any)["watched"] != false {
+		t.Errorf("seasons = %v, want only season 1 watched", seasons)
+	}
+	episode := season["episodes"].([]any)[0].(map[string]any)
+	if got, want := keys(episode), "air_date name number released watched"; got != want {
+		t.Errorf("episode fields = %s, want %s", got, want)
+	}
+
+	s.call(http.MethodGet, "/api/v1/shows/4", http.StatusOK, &details)
+	if details["added"] != false {
+		t.Errorf("show 4 added = %v, want false", details["added"])
+	}
+
+	tests := []struct {
+		target string
+		status int
+	}{
+		{target: "/api/v1/shows/99", status: http.StatusNotFound},
+		{target: "/api/v1/shows/abc", status: http.StatusBadRequest},
+		{target: "/api/v1/shows/99/progress", status: http.StatusNotFound},
+		{target: "/api/v1/shows/abc/progress", status: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		var body map[string]any
+		s.call(http.MethodGet, tt.target, tt.status, &body)
+		if keys(body) != "error" {
+			t.Errorf("%s error response = %v, want an error message", tt.target, body)
+		}
+	}
+}
+
+func TestAPIList(t *testing.T) {
+	apiShows(t)
+	s := newAPIServer(t)
+
+	tests := []struct {
+		target string
+		want   []int
+	}{
+		{target: "/api/v1/list?filter=home", want: []int{1}},
+		{target: "/api/v1/list?filter=start", want: []int{2}},
+		{target: "/api/v1/list?filter=comingsoon", want: []int{3}},
+		{target: "/api/v1/list?filter=all", want: []int{2, 3, 1}},
+		{target: "/api/v1/list", want: []int{2, 3, 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			var list []map[string]any
+			s.call(http.MethodGet, tt.target, http.StatusOK, &list)
+
+			var ids []int
+			for _, show := range list {
+				ids = append(ids, int(show["id"].(float64)))
+			}
+			if !slices.Equal(ids, tt.want) {
+				t.Errorf("shows = %v, want %v", ids, tt.want)
+			}
+			if len(list) > 0 {
+				want := "air_date description episode_count id name poster season_count status unwatched watched_count"
+				if got := keys(list[0]); got != want {
+					t.Errorf("fields = %s, want %s", got, want)
+				}
+			}
+		})
+	}
+
+	var body map[string]any
+	s.call(http.MethodGet, "/api/v1/list?filter=later", http.StatusBadRequest, &body)
+	if keys(body) != "error" {
+		t.Errorf("unknown filter response = %v, want an error message", body)
+	}
+}
+
+// TestAPIListMatchesPages checks each API filter picks the same shows, in the
+// same order, as the page it mirrors
+func TestAPIListMatchesPages(t *testing.T) {
+	apiShows(t)
+	s := newAPIServer(t)
+
+	// pages are rendered from the templates in the repository root
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chdir("..")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	showLink := regexp.MustCompile(`href="/show/details\?id=(\d+)"`)
+	pages := map[string]string{
+		"home":       "/",
+		"start":      "/start",
+		"comingsoon": "/comingsoon",
+		"all":        "/all",
+	}
+	for filter, page := range pages {
+		t.Run(filter, func(t *testing.T) {
+			var list []ShowData
+			s.call(http.MethodGet, "/api/v1/list?filter="+filter, http.StatusOK, &list)
+			var want []int
+			for _, show := range list {
+				want = append(want, show.ID)
+			}
+
+			w := s.do(http.MethodGet, page)
+			if w.Code != http.StatusOK {
+				t.Fatalf("%s status = %d, want 200: %s", page, w.Code, w.Body.String())
+			}
+			var got []int
+			for _, match := range showLink.FindAllStringSubmatch(w.Body.String(), -1) {
+				id, _ := strconv.Atoi(match[1])
+				// each show is linked from its poster and its name
+				if len(got) == 0 || got[len(got)-1] != id {
+					got = append(got, id)
+				}
+			}
+
+			if len(want) == 0 {
+				t.Fatalf("the %s filter is empty, the test shows should fill every list", filter)
+			}
+			if !slices.Equal(got, want) {
+				t.Errorf("%s lists %v, the API lists %v", page, got, want)
+			}
+		})
+	}
+}
+
+func TestAPIAddRemoveShow(t *testing.T) {
+	fake := apiShows(t)
+	s := newAPIServer(t)
+	ctx := context.Background()
+
+	var body map[string]any
+	s.call(http.MethodPut, "/api/v1/list/4", http.StatusOK, &body)
+	if body["id"] != 4.0 || body["added"] != true {
+		t.Errorf("add response = %v, want show 4 added", body)
+	}
+	if added, _ := fake.HasShow(ctx, 1, 4); !added {
+		t.Error("show 4 wasn't added")
+	}
+
+	// adding twice is fine
+	s.call(http.MethodPut, "/api/v1/list/4", http.StatusOK, &body)
+
+	s.call(http.MethodDelete, "/api/v1/list/4", http.StatusOK, &body)
+	if body["id"] != 4.0 || body["added"] != false {
+		t.Errorf("remove response = %v, want show 4 removed", body)
+	}
+	if added, _ := fake.HasShow(ctx, 1, 4); added {
+		t.Error("show 4 wasn't removed")
+	}
+
+	tests := []struct {
+		method string
+		target string
+		status int
+	}{
+		{method: http.MethodPut, target: "/api/v1/list/99", status: http.StatusNotFound},
+		{method: http.MethodDelete, target: "/api/v1/list/99", status: http.StatusNotFound},
+		{method: http.MethodPut, target: "/api/v1/list/abc", status: http.StatusBadRequest},
+		{method: http.MethodDelete, target: "/api/v1/list/abc", status: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		var body map[string]any
+		s.call(tt.method, tt.target, tt.status, &body)
+		if keys(body) != "error" {
+			t.Errorf("%s %s error response = %v, want an error message", tt.method, tt.target, body)
+		}
+	}
+	if ids, _ := fake.ShowIDs(ctx, 1); !slices.Equal(ids, []int{1, 2, 3}) {
+		t.Errorf("list = %v after bad requests, want it unchanged", ids)
+	}
+}
+
+func TestAPIWatched(t *testing.T) {
+	fake := apiShows(t)
+	s := newAPIServer(t)
+	ctx := context.Background()
+
+	var progress map[string]any
+	s.call(http.MethodGet, "/api/v1/shows/1/progress", http.StatusOK, &progress)
+	if got, want := keys(progress), "episode_count show_id unwatched watched watched_count"; got != want {
+		t.Errorf("progress fields = %s, want %s", got, want)
+	}
+	watched := progress["watched"].([]any)
+	if len(watched) != 2 || keys(watched[0].(map[string]any)) != "episode season" {
+		t.Errorf("watched = %v, want S1E1 and S1E2", watched)
+	}
+
+	tests := []struct {
+		name    string
+		method  string
+		target  string
+		watched int
+	}{
+		{name: "episode", method: http.MethodPut, target: "/api/v1/shows/1/seasons/2/episodes/1/watched", watched: 3},
+		{name: "season", method: http.MethodPut, target: "/api/v1/shows/1/seasons/2/watched", watched: 4},
+		{name: "unwatch episode", method: http.MethodDelete, target: "/api/v1/shows/1/seasons/2/episodes/2/watched", watched: 3},
+		{name: "unwatch season", method: http.MethodDelete, target: "/api/v1/shows/1/seasons/1/watched", watched: 0},
+		{name: "watch a show not on the list", method: http.MethodPut, target: "/api/v1/shows/4/seasons/1/watched", watched: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var progress apiProgress
+			s.call(tt.method, tt.target, http.StatusOK, &progress)
+			if progress.WatchedCount != tt.watched || len(progress.Watched) != tt.watched {
+				t.Errorf("progress = %+v, want %d watched", progress, tt.watched)
+			}
+			if progress.WatchedCount+progress.Unwatched != progress.EpisodeCount {
+				t.Errorf("progress = %+v, watched and unwatched don't add up to the released episodes", progress)
+			}
+		})
+	}
+	if added, _ := fake.HasShow(ctx, 1, 4); !added {
+		t.Error("watching show 4 didn't add it to the list")
+	}
+
+	bad := []struct {
+		method string
+		target string
+		status int
+	}{
+		{method: http.MethodPut, target: "/api/v1/shows/99/seasons/1/watched", status: http.StatusNotFound},
+		{method: http.MethodDelete, target: "/api/v1/shows/99/seasons/1/episodes/1/watched", status: http.StatusNotFound},
+		{method: http.MethodPut, target: "/api/v1/shows/abc/seasons/1/watched", status: http.StatusBadRequest},
+		{method: http.MethodPut, target: "/api/v1/shows/1/seasons/one/watched", status: http.StatusBadRequest},
+		{method: http.MethodPut, target: "/api/v1/shows/1/seasons/1/episodes/one/watched", status: http.StatusBadRequest},
+	}
+	for _, tt := range bad {
+		var body map[string]any
+		s.call(tt.method, tt.target, tt.status, &body)
+		if keys(body) != "error" {
+			t.Errorf("%s %s error response = %v, want an error message", tt.method, tt.target, body)
+		}
+	}
+}
@@ -1,12 +1,11 @@
 package routes
 
 import (
-	"log"
+	"log/slog"
 	"net/http"
 	"strings"
 
 	"github.com/jccroft1/goshowtrack/auth"
-	"github.com/jccroft1/goshowtrack/tvdbapi"
 )
 
 func BulkAddHandler(w http.ResponseWriter, req *http.Request) {
@@ -18,7 +17,7 @@ func BulkAddHandler(w http.ResponseWriter, req *http.Request) {
 
 	query := req.FormValue("query")
 	if query == "" {
-		log.Println("bulk add missing 'query'")
+		slog.WarnContext(req.Context(), "Bulk add missing 'query'")
 		http.Error(w, "Query is required", http.StatusBadRequest)
 		return
 	}
@@ -31,10 +30,10 @@ func BulkAddHandler(w http.ResponseWriter, req *http.Request) {
 			continue
 		}
 
-		shows, err := tvdbapi.SearchShow(line)
+		shows, err := searchShows(req.Context(), line)
 		if err != nil {
-			log.Println("Error searching TVDB: ", err)
-			http.Error(w, "Failed to search TVDB", http.StatusInternalServerError)
+			slog.ErrorContext(req.Context(), "Error searching TVDB", "err", err)
+			http.Error(w, "Failed to search TVDB", providerStatus(err))
 			return
 		}
 
@@ -44,16 +43,16 @@ func BulkAddHandler(w http.ResponseWriter, req *http.Request) {
 		}
 
 		// add the first result
-		showDetails, err := tvdbapi.GetShowDetails(shows[0].ID, false)
+		showDetails, err := repo.Show(req.Context(), shows[0].ID, false)
 		if err != nil {
-			log.Println("Error searching TVDB: ", err)
-			http.Error(w, "Error searching TVDB", http.StatusInternalServerError)
+			slog.ErrorContext(req.Context(), "Error searching TVDB", "err", err)
+			http.Error(w, "Error searching TVDB", providerStatus(err))
 			return
 		}
 
-		err = addShow(userID, showDetails.ID)
+		err = repo.AddShow(req.Context(), userID, showDetails.ID)
 		if err != nil {
-			log.Println("Failed to add show: ", err)
+			slog.ErrorContext(req.Context(), "Failed to add show", "err", err)
 			http.Error(w, "Failed to add show", http.StatusInternalServerError)
 			return
 		}
@@ -0,0 +1,234 @@
+package routes
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jccroft1/goshowtrack/auth"
+	"github.com/jccroft1/goshowtrack/tvdbapi"
+)
+
+// how far back events are kept in the feed, so calendar apps don't drop
+// episodes that aired in the last few weeks
+const calendarHistory = 30 * 24 * time.Hour
+
+type calendarEvent struct {
+	UID     string
+	Date    time.Time
+	Summary string
+	URL     string
+}
+
+// CalendarHandler serves the user's iCalendar feed, GET /calendar/{token}.ics
+// It's authenticated by the secret token in the URL instead of the usual
+// middleware so calendar apps can subscribe to it.
+func CalendarHandler(w http.ResponseWriter, r *http.Request) {
+	token := strings.TrimSuffix(r.PathValue("token"), ".ics")
+	if token == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	userID, ok, err := repo.CalendarUser(r.Context(), token)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to find calendar token", "err", err)
+		http.Error(w, "Error querying database", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	shows, err := repo.ListShows(r.Context(), userID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error fetch user show list", "err", err)
+		http.Error(w, "Failed to fetch user shows", http.StatusInternalServerError)
+		return
+	}
+
+	baseURL := requestBaseURL(r)
+	events := calendarEvents(shows, time.Now(), baseURL)
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	err = writeCalendar(w, events, time.Now())
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to write calendar", "err", err)
+	}
+}
+
+// CalendarTokenHandler creates a new calendar feed URL for the user,
+// replacing the old one, POST /calendar/token
+func CalendarTokenHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserID(r)
+	if !ok {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	b := make([]byte, 24)
+	_, err := rand.Read(b)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to generate calendar token", "err", err)
+		http.Error(w, "Failed to generate calendar token", http.StatusInternalServerError)
+		return
+	}
+
+	err = repo.SetCalendarToken(r.Context(), userID, hex.EncodeToString(b))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to save calendar token", "err", err)
+		http.Error(w, "Failed to save calendar token", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/about#calendar", http.StatusSeeOther)
+}
+
+// calendarURL returns the user's feed URL, or "" if they haven't created one
+func calendarURL(r *http.Request, userID int64) (string, error) {
+	token, err := repo.CalendarToken(r.Context(), userID)
+	if err != nil || token == "" {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s/calendar/%s.ics", requestBaseURL(r), token), nil
+}
+
+func requestBaseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// calendarEvents creates an event for every episode airing from a month ago
+// onwards. Seasons without episode details get a premiere and finale event.
+func calendarEvents(shows []*tvdbapi.ShowDetail, now time.Time, baseURL string) []calendarEvent {
+	from := now.Add(-calendarHistory)
+
+	events := []calendarEvent{}
+	add := func(uid string, dateStr string, summary string, showID int) {
+		date, err := time.Parse("2006-01-02", dateStr)
+		if err != nil || date.Before(from) {
+			return
+		}
+
+		events = append(events, calendarEvent{
+			UID:     uid + "@goshowtrack",
+			Date:    date,
+			Summary: summary,
+			URL:     fmt.Sprintf("%s/show/details?id=%d", baseURL, showID),
+		})
+	}
+
+	for _, show := range shows {
+		for _, season := range show.Seasons {
+			if len(season.Episodes) == 0 {
+				add(fmt.Sprintf("%d-s%d-premiere", show.ID, season.Number), season.AirDate,
+					fmt.Sprintf("%s: Season %d premiere", show.Name, season.Number), show.ID)
+				if season.LastAirDate != season.AirDate {
+					add(fmt.Sprintf("%d-s%d-finale", show.ID, season.Number), season.LastAirDate,
+						fmt.Sprintf("%s: Season %d finale", show.Name, season.Number), show.ID)
+				}
+				continue
+			}
+
+			for i, e := range season.Episodes {
+				summary := fmt.Sprintf("%s S%02dE%02d", show.Name, season.Number, e.Number)
+				if e.Name != "" {
+					summary += ": " + e.Name
+				}
+				if i == 0 {
+					summary += " (season premiere)"
+				} else if i == len(season.Episodes)-1 {
+					summary += " (season finale)"
+				}
+
+				add(fmt.Sprintf("%d-s%de%d", show.ID, season.Number, e.Number), e.AirDate, summary, show.ID)
+			}
+		}
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Date.Before(events[j].Date)
+	})
+
+	return events
+}
+
+// writeCalendar writes the events as all-day events in an iCalendar (RFC 5545) feed
+func writeCalendar(w io.Writer, events []calendarEvent, now time.Time) error {
+	lines := []string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"PRODID:-//goshowtrack//Go Show Track//EN",
+		"CALSCALE:GREGORIAN",
+		"METHOD:PUBLISH",
+		"X-WR-CALNAME:Go Show Track",
+	}
+
+	stamp := now.UTC().Format("20060102T150405Z")
+	for _, e := range events {
+		lines = append(lines,
+			"BEGIN:VEVENT",
+			"UID:"+escapeICalText(e.UID),
+			"DTSTAMP:"+stamp,
+			"DTSTART;VALUE=DATE:"+e.Date.Format("20060102"),
+			"DTEND;VALUE=DATE:"+e.Date.AddDate(0, 0, 1).Format("20060102"),
+			"SUMMARY:"+escapeICalText(e.Summary),
+			"URL:"+e.URL,
+			"TRANSP:TRANSPARENT",
+			"END:VEVENT",
+		)
+	}
+	lines = append(lines, "END:VCALENDAR")
+
+	for _, line := range lines {
+		_, err := io.WriteString(w, foldICalLine(line)+"\r\n")
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+var icalEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	";", `\;`,
+	",", `\,`,
+	"\r\n", `\n`,
+	"\n", `\n`,
+)
+
+func escapeICalText(s string) string {
+	return icalEscaper.Replace(s)
+}
+
+// foldICalLine splits lines longer than 75 bytes, continuation lines start
+// with a space. Multi-byte characters are never split.
+func foldICalLine(line string) string {
+	const limit = 75
+
+	var b strings.Builder
+	lineLen := 0
+	for _, r := range line {
+		size := len(string(r))
+		if lineLen+size > limit {
+			b.WriteString("\r\n ")
+			lineLen = 1
+		}
+		b.WriteRune(r)
+		lineLen += size
+	}
+
+	return b.String()
+}
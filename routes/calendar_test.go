@@ -0,0 +1,114 @@
+package routes
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jccroft1/goshowtrack/tvdbapi"
+)
+
+func TestCalendarEvents(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	shows := []*tvdbapi.ShowDetail{
+		{
+			ID:   1,
+			Name: "Severance",
+			Seasons: []tvdbapi.Season{
+				{
+					// long before the feed's history
+					Number:      1,
+					AirDate:     "2022-02-18",
+					LastAirDate: "2022-04-08",
+					Episodes: []tvdbapi.Episode{
+						{SeasonNumber: 1, Number: 1, Name: "Good News About Hell", AirDate: "2022-02-18"},
+					},
+				},
+				{
+					Number:      2,
+					AirDate:     "2025-05-20",
+					LastAirDate: "2025-06-20",
+					Episodes: []tvdbapi.Episode{
+						{SeasonNumber: 2, Number: 1, Name: "Hello, Ms. Cobel", AirDate: "2025-05-20"},
+						{SeasonNumber: 2, Number: 2, Name: "Goodbye", AirDate: "2025-06-06"},
+						{SeasonNumber: 2, Number: 3, Name: "Cold Harbor", AirDate: "2025-06-20"},
+					},
+				},
+			},
+		},
+		{
+			ID:   2,
+			Name: "Andor",
+			Seasons: []tvdbapi.Season{
+				// no episode details yet
+				{Number: 2, AirDate: "2025-07-01", LastAirDate: "2025-08-01"},
+			},
+		},
+	}
+
+	events := calendarEvents(shows, now, "http://localhost")
+
+	want := []string{
+		"Severance S02E01: Hello, Ms. Cobel (season premiere)",
+		"Severance S02E02: Goodbye",
+		"Severance S02E03: Cold Harbor (season finale)",
+		"Andor: Season 2 premiere",
+		"Andor: Season 2 finale",
+	}
+	if len(events) != len(want) {
+		t.Fatalf("got %d events, want %d: %+v", len(events), len(want), events)
+	}
+	for i, e := range events {
+		if e.Summary != want[i] {
+			t.Errorf("event %d summary = %q, want %q", i, e.Summary, want[i])
+		}
+	}
+}
+
+func TestWriteCalendar(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	events := []calendarEvent{
+		{
+			UID:     "1-s2e1@goshowtrack",
+			Date:    time.Date(2025, 6, 6, 0, 0, 0, 0, time.UTC),
+			Summary: "Show; with, special\\characters and a very long name that needs folding across lines",
+			URL:     "http://localhost/show/details?id=1",
+		},
+	}
+
+	var b strings.Builder
+	err := writeCalendar(&b, events, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := b.String()
+
+	for _, line := range strings.Split(strings.TrimSuffix(out, "\r\n"), "\r\n") {
+		if len(line) > 75 {
+			t.Errorf("line longer than 75 bytes: %q", line)
+		}
+	}
+
+	unfolded := strings.ReplaceAll(out, "\r\n ", "")
+	for _, want := range []string{
+		"DTSTART;VALUE=DATE:20250606\r\n",
+		"DTEND;VALUE=DATE:20250607\r\n",
+		`SUMMARY:Show\; with\, special\\characters and a very long name that needs folding across lines` + "\r\n",
+	} {
+		if !strings.Contains(unfolded, want) {
+			t.Errorf("calendar missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestFoldICalLineMultiByte(t *testing.T) {
+	line := "SUMMARY:" + strings.Repeat("é", 50)
+	for _, part := range strings.Split(foldICalLine(line), "\r\n") {
+		if len(part) > 75 {
+			t.Errorf("folded line is %d bytes, want at most 75", len(part))
+		}
+		if !strings.HasPrefix(part, "SUMMARY") && !strings.HasPrefix(part, " é") {
+			t.Errorf("multi-byte character was split: %q", part)
+		}
+	}
+}
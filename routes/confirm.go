@@ -0,0 +1,68 @@
+package routes
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+var confirmMessages = map[string]string{
+	"/show/add":       "Add %s to your list?",
+	"/show/remove":    "Remove %s from your list?",
+	"/show/watched":   "Mark %s as watched?",
+	"/show/unwatched": "Mark %s as unwatched?",
+}
+
+// ConfirmShowActionHandler asks before following old GET links to the show
+// actions, e.g. GET /show/watched?show_id=1&season=2. The form posts the same
+// parameters back, so link prefetchers and crawlers can't change anything.
+func ConfirmShowActionHandler(w http.ResponseWriter, r *http.Request) {
+	message, ok := confirmMessages[r.URL.Path]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	query := r.URL.Query()
+	showIDStr := query.Get("show_id")
+	if showIDStr == "" {
+		showIDStr = query.Get("id")
+	}
+	showID, err := strconv.Atoi(showIDStr)
+	if err != nil {
+		slog.WarnContext(r.Context(), "Invalid ID provided", "err", err)
+		http.Error(w, "Invalid ID provided", http.StatusBadRequest)
+		return
+	}
+
+	show, err := repo.Show(r.Context(), showID, false)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error searching TVDB", "err", err)
+		http.Error(w, "Error searching TVDB", providerStatus(err))
+		return
+	}
+
+	target := show.Name
+	if season := query.Get("season"); season != "" {
+		target = fmt.Sprintf("season %s of %s", season, show.Name)
+		if episode := query.Get("episode"); episode != "" {
+			target = fmt.Sprintf("episode %s of %s", episode, target)
+		}
+	}
+
+	type ConfirmData struct {
+		Message string
+		Action  string
+		Fields  url.Values
+		ShowID  int
+	}
+
+	renderTemplate(w, r, "confirm", ConfirmData{
+		Message: fmt.Sprintf(message, target),
+		Action:  r.URL.Path,
+		Fields:  query,
+		ShowID:  show.ID,
+	})
+}
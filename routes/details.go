@@ -1,16 +1,56 @@
 package routes
 
 import (
-	"database/sql"
-	"log"
+	"context"
+	"fmt"
+	"log/slog"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/jccroft1/goshowtrack/auth"
-	"github.com/jccroft1/goshowtrack/db"
 	"github.com/jccroft1/goshowtrack/tvdbapi"
 )
 
+type detailsEpisode struct {
+	Number  int    `json:"number"`
+	Name    string `json:"name"`
+	AirDate string `json:"air_date"`
+
+	Watched  bool `json:"watched"`
+	Released bool `json:"released"`
+}
+
+type detailsSeason struct {
+	Number    int    `json:"number"`
+	Episodes  int    `json:"episode_count"`
+	StartDate string `json:"start_date"` // e.g., "2023-01-15"
+	EndDate   string `json:"end_date"`
+
+	WatchedEpisodes int              `json:"watched_count"`
+	EpisodeList     []detailsEpisode `json:"episodes"`
+
+	Watched  bool `json:"watched"`
+	Released bool `json:"released"`
+}
+
+type detailsShow struct {
+	ID          int             `json:"id"`
+	Name        string          `json:"name"`
+	AirDate     string          `json:"air_date"`
+	Description string          `json:"description"`
+	Poster      string          `json:"poster"`
+	Status      string          `json:"status"`
+	Seasons     []detailsSeason `json:"seasons"`
+	Unwatched   int             `json:"unwatched"`
+	LastUpdated time.Time       `json:"last_updated"`
+}
+
+type detailsData struct {
+	Added    bool        `json:"added"`
+	ShowData detailsShow `json:"show"`
+}
+
 func ShowDetailsHandler(w http.ResponseWriter, r *http.Request) {
 	showIDStr := r.URL.Query().Get("id")
 	if showIDStr == "" {
@@ -20,15 +60,15 @@ func ShowDetailsHandler(w http.ResponseWriter, r *http.Request) {
 
 	showID, err := strconv.Atoi(showIDStr)
 	if err != nil {
-		log.Println("Invalid ID provided", err)
+		slog.WarnContext(r.Context(), "Invalid ID provided", "err", err)
 		http.Error(w, "Invalid ID provided", http.StatusBadRequest)
 		return
 	}
 
-	showDetails, err := tvdbapi.GetShowDetails(showID, false)
+	showDetails, err := repo.Show(r.Context(), showID, false)
 	if err != nil {
-		log.Println("Error searching TVDB: ", err)
-		http.Error(w, "Error searching TVDB", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Error searching TVDB", "err", err)
+		http.Error(w, "Error searching TVDB", providerStatus(err))
 		return
 	}
 
@@ -38,74 +78,93 @@ func ShowDetailsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	added := userHasAddedShow(userID, showID)
-
-	watchedSeasons := 0
-	err = db.Connection.QueryRow(`SELECT season_number FROM user_seasons WHERE user_id = ? AND show_id = ?`, userID, showID).Scan(&watchedSeasons)
-	if err != nil && err != sql.ErrNoRows {
-		log.Println("Failed to get users watched seasons", err)
+	data, err := buildDetails(r.Context(), userID, showDetails)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to get users watched episodes", "err", err)
 		http.Error(w, "Error querying database", http.StatusInternalServerError)
 		return
 	}
 
-	unwatchedSeasons, _ := hasSomethingToWatch(showDetails.Seasons, watchedSeasons)
-
-	type Season struct {
-		Number    int
-		Episodes  int
-		StartDate string // e.g., "2023-01-15"
-		EndDate   string
+	renderTemplate(w, r, "showDetails", data)
+}
 
-		Watched  bool
-		Released bool
+// RefreshShowHandler fetches the show from the provider straight away, POST /show/refresh
+func RefreshShowHandler(w http.ResponseWriter, r *http.Request) {
+	showID, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		slog.WarnContext(r.Context(), "Invalid ID provided", "err", err)
+		http.Error(w, "Invalid ID provided", http.StatusBadRequest)
+		return
 	}
-	type ShowData struct {
-		ID          int
-		Name        string
-		AirDate     string
-		Description string
-		Poster      string
-		Status      string
-		Seasons     []Season
-		Unwatched   int
+
+	showDetails, err := repo.Show(r.Context(), showID, true)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error refreshing show", "err", err)
+		http.Error(w, "Error refreshing show", providerStatus(err))
+		return
 	}
-	type Data struct {
-		Added    bool
-		ShowData ShowData
+
+	http.Redirect(w, r, fmt.Sprintf("/show/details?id=%v", showDetails.ID), http.StatusSeeOther)
+}
+
+// buildDetails combines the show with the user's progress
+func buildDetails(ctx context.Context, userID int64, showDetails *tvdbapi.ShowDetail) (detailsData, error) {
+	added := userHasAddedShow(ctx, userID, showDetails.ID)
+
+	progress, err := getWatchProgress(ctx, userID, showDetails.ID)
+	if err != nil {
+		return detailsData{}, err
 	}
 
 	// Fetch season data from TVDB API
-	showData := ShowData{
+	showData := detailsShow{
 		ID:          showDetails.ID,
 		Name:        showDetails.Name,
 		AirDate:     showDetails.AirDate,
 		Description: showDetails.Description,
 		Poster:      showDetails.PosterPath,
 		Status:      showDetails.Status,
-		Seasons:     []Season{}, // Initialize with empty slice
-		Unwatched:   len(unwatchedSeasons),
+		Seasons:     []detailsSeason{}, // Initialize with empty slice
+		Unwatched:   len(episodesToWatch(showDetails.Seasons, progress)),
+		LastUpdated: showDetails.FetchedAt,
 	}
 	if showDetails.Status == "Returning Series" {
 		showData.Status = getReturningInfo(*showDetails)
 	}
 
 	for _, season := range showDetails.Seasons {
-
-		watched := season.Number <= watchedSeasons
-		showData.Seasons = append(showData.Seasons, Season{
-			Number:    season.Number,
-			Episodes:  season.EpisodeCount,
-			StartDate: season.AirDate,
-			EndDate:   season.LastAirDate,
-			Watched:   watched,
-			Released:  isReleased(season.LastAirDate),
-		})
+		newSeason := detailsSeason{
+			Number:          season.Number,
+			Episodes:        season.EpisodeCount,
+			StartDate:       season.AirDate,
+			EndDate:         season.LastAirDate,
+			WatchedEpisodes: progress.watchedCount(season.Episodes),
+			EpisodeList:     []detailsEpisode{},
+			Released:        isReleased(season.LastAirDate),
+		}
+
+		// a season is watched once every released episode has been watched
+		newSeason.Watched = newSeason.WatchedEpisodes > 0
+		for _, e := range season.Episodes {
+			episode := detailsEpisode{
+				Number:   e.Number,
+				Name:     e.Name,
+				AirDate:  e.AirDate,
+				Watched:  progress.Watched(e),
+				Released: isReleased(e.AirDate),
+			}
+			if episode.Released && !episode.Watched {
+				newSeason.Watched = false
+			}
+
+			newSeason.EpisodeList = append(newSeason.EpisodeList, episode)
+		}
+
+		showData.Seasons = append(showData.Seasons, newSeason)
 	}
 
-	data := Data{
+	return detailsData{
 		Added:    added,
 		ShowData: showData,
-	}
-
-	renderTemplate(w, "showDetails", data)
+	}, nil
 }
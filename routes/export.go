@@ -0,0 +1,100 @@
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/jccroft1/goshowtrack/auth"
+	"github.com/jccroft1/goshowtrack/userdata"
+)
+
+// ExportHandler downloads the user's shows and progress, GET /export?format=json|csv
+func ExportHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserID(r)
+	if !ok {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+	}
+	contentTypes := map[string]string{
+		"json": "application/json",
+		"csv":  "text/csv",
+	}
+	contentType, ok := contentTypes[format]
+	if !ok {
+		http.Error(w, "Unknown format, expected json or csv", http.StatusBadRequest)
+		return
+	}
+
+	export, err := userdata.Load(userID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to load user data", "err", err)
+		http.Error(w, "Error querying database", http.StatusInternalServerError)
+		return
+	}
+
+	filename := fmt.Sprintf("goshowtrack-%s.%s", time.Now().Format("2006-01-02"), format)
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	err = userdata.Write(w, format, export)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to write export", "err", err)
+	}
+}
+
+// maxRestoreSize is the largest export that can be uploaded, years of
+// progress on hundreds of shows is well under 1MB
+const maxRestoreSize = 4 << 20
+
+// RestoreHandler merges an uploaded export into the user's data, POST /restore
+func RestoreHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserID(r)
+	if !ok {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	// the CSRF check may already have read the form, so the file's size is
+	// checked as well as the body's
+	r.Body = http.MaxBytesReader(w, r.Body, maxRestoreSize)
+	file, header, err := r.FormFile("file")
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) || (err == nil && header.Size > maxRestoreSize) {
+		if file != nil {
+			file.Close()
+		}
+		slog.WarnContext(r.Context(), "Restore file too large")
+		http.Error(w, fmt.Sprintf("The export file is larger than %d MB", maxRestoreSize>>20), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		slog.WarnContext(r.Context(), "Restore missing 'file'", "err", err)
+		http.Error(w, "An export file is required", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	export, err := userdata.Read(file)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to read export", "err", err)
+		http.Error(w, fmt.Sprintf("Failed to read export: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	result, err := userdata.Restore(userID, export)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to restore user data", "err", err)
+		http.Error(w, "Failed to restore data", http.StatusInternalServerError)
+		return
+	}
+	slog.InfoContext(r.Context(), "Restored user data", "shows", result.Shows, "episodes", result.Episodes)
+
+	http.Redirect(w, r, "/all", http.StatusSeeOther)
+}
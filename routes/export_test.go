@@ -0,0 +1,78 @@
+package routes
+
+import (
+	"bytes"
+	"context"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jccroft1/goshowtrack/auth"
+	"github.com/jccroft1/goshowtrack/db/dbtest"
+	"github.com/jccroft1/goshowtrack/store"
+)
+
+// restoreUpload is a restore form posting contents as the file, with the
+// CSRF token in the form rather than the header when inForm is set
+func restoreUpload(t *testing.T, contents string, csrf string, inForm bool) *http.Request {
+	t.Helper()
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	if inForm {
+		form.WriteField(auth.CSRFField, csrf)
+	}
+	file, err := form.CreateFormFile("file", "export.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Write([]byte(contents))
+	form.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/restore", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	if !inForm {
+		req.Header.Set(auth.CSRFHeader, csrf)
+	}
+	return req
+}
+
+func TestRestoreHandler(t *testing.T) {
+	dbtest.Open(t)
+	err := auth.Setup(auth.Options{Disable: true, Secret: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /export", auth.Middleware(ExportHandler))
+	mux.HandleFunc("POST /restore", auth.Middleware(RestoreHandler))
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/export", nil))
+	csrf := w.Header().Get(auth.CSRFHeader)
+	if w.Code != http.StatusOK || csrf == "" {
+		t.Fatalf("export status = %d, CSRF token %q, want 200 and a token", w.Code, csrf)
+	}
+
+	huge := `{"version": 1, "shows": [], "padding": "` + strings.Repeat("x", maxRestoreSize) + `"}`
+	for _, inForm := range []bool{false, true} {
+		w = httptest.NewRecorder()
+		mux.ServeHTTP(w, restoreUpload(t, huge, csrf, inForm))
+		if w.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("restoring a %d MB file (token in form %v) status = %d, want 413", len(huge)>>20, inForm, w.Code)
+		}
+	}
+
+	export := `{"version": 1, "shows": [{"show_id": 10, "added": true, "watched": [{"season": 1, "episode": 1}]}]}`
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, restoreUpload(t, export, csrf, true))
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("restore status = %d, want 303: %s", w.Code, w.Body.String())
+	}
+	if added, _ := (store.SQL{}).HasShow(context.Background(), 1, 10); !added {
+		t.Error("restored show wasn't added")
+	}
+}
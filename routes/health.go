@@ -0,0 +1,124 @@
+package routes
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jccroft1/goshowtrack/db"
+	"github.com/jccroft1/goshowtrack/tvdbapi"
+)
+
+// check states, the app is ready unless a check fails. Degraded checks
+// still serve pages, e.g. from the cache while the provider is down.
+const (
+	checkOK       = "ok"
+	checkDegraded = "degraded"
+	checkFailed   = "fail"
+)
+
+type checkResult struct {
+	Status string `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+type healthResponse struct {
+	Status string                 `json:"status"`
+	Checks map[string]checkResult `json:"checks,omitempty"`
+}
+
+// HealthzHandler reports the process is alive, GET /healthz
+func HealthzHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, healthResponse{Status: checkOK})
+}
+
+// ReadyzHandler reports whether the app can serve pages, GET /readyz.
+// It responds 503 if any check fails.
+func ReadyzHandler(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]checkResult{
+		"database":      checkDatabase(r.Context()),
+		"templates":     checkTemplates(),
+		"popular_shows": checkPopularShows(),
+		"provider":      checkProvider(),
+	}
+
+	response := healthResponse{Status: checkOK, Checks: checks}
+	for _, check := range checks {
+		if check.Status == checkFailed {
+			response.Status = checkFailed
+			break
+		}
+		if check.Status == checkDegraded {
+			response.Status = checkDegraded
+		}
+	}
+
+	status := http.StatusOK
+	if response.Status == checkFailed {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, response)
+}
+
+func checkDatabase(ctx context.Context) checkResult {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	err := db.Connection.PingContext(ctx)
+	if err != nil {
+		return checkResult{Status: checkFailed, Detail: err.Error()}
+	}
+	return checkResult{Status: checkOK}
+}
+
+// checkTemplates parses every page, so a bad deploy is caught before
+// anyone opens the broken page
+func checkTemplates() checkResult {
+	pages, err := filepath.Glob("templates/*.html")
+	if err != nil || len(pages) == 0 {
+		return checkResult{Status: checkFailed, Detail: "no templates found"}
+	}
+
+	for _, page := range pages {
+		name := strings.TrimSuffix(filepath.Base(page), ".html")
+		if name == "layout" {
+			continue
+		}
+
+		_, err := parseTemplate(name, templateFuncs(nil))
+		if err != nil {
+			return checkResult{Status: checkFailed, Detail: err.Error()}
+		}
+	}
+	return checkResult{Status: checkOK}
+}
+
+// checkPopularShows waits for the first load of the shows used by search
+// suggestions, if it failed search still works without them
+func checkPopularShows() checkResult {
+	count, tried := tvdbapi.PopularShowsStatus()
+	switch {
+	case count > 0:
+		return checkResult{Status: checkOK, Detail: fmt.Sprintf("%d shows", count)}
+	case tried:
+		return checkResult{Status: checkDegraded, Detail: "failed to load"}
+	default:
+		return checkResult{Status: checkFailed, Detail: "loading"}
+	}
+}
+
+// checkProvider reports whether the last request to the provider worked,
+// shows are served from the cache while it's down
+func checkProvider() checkResult {
+	checkedAt, err := tvdbapi.ProviderHealth()
+	if checkedAt.IsZero() {
+		return checkResult{Status: checkOK, Detail: "not used yet"}
+	}
+	if err != nil {
+		return checkResult{Status: checkDegraded, Detail: err.Error()}
+	}
+	return checkResult{Status: checkOK}
+}
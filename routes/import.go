@@ -0,0 +1,110 @@
+package routes
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/jccroft1/goshowtrack/auth"
+	"github.com/jccroft1/goshowtrack/importer"
+)
+
+type importShow struct {
+	importer.ShowMatch
+
+	Episodes int
+	Missing  []importer.Row
+}
+
+type importReport struct {
+	Shows  []importShow
+	Errors []importer.RowError
+
+	Matched   int
+	Ambiguous int
+	Failed    int
+}
+
+// ImportHandler shows the import form, GET /import
+func ImportHandler(w http.ResponseWriter, r *http.Request) {
+	renderTemplate(w, r, "import", importer.Formats)
+}
+
+// ImportUploadHandler imports the uploaded export and shows the report, POST /import
+func ImportUploadHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserID(r)
+	if !ok {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		slog.WarnContext(r.Context(), "Import missing 'file'", "err", err)
+		http.Error(w, "An export file is required", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	rows, rowErrors, err := importer.Parse(r.FormValue("format"), file)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to parse import", "err", err)
+		http.Error(w, fmt.Sprintf("Failed to read export: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	report := importReport{
+		Errors: rowErrors,
+		Failed: len(rowErrors),
+	}
+	for _, match := range importer.Match(r.Context(), rows) {
+		show := importShow{ShowMatch: match}
+
+		switch match.Status {
+		case importer.Matched:
+			err = importProgress(r.Context(), userID, &show)
+			if err != nil {
+				slog.ErrorContext(r.Context(), "Failed to import show", "err", err)
+				show.Status = importer.Failed
+				show.Reason = "failed to save progress"
+				report.Failed += len(show.Rows)
+			} else {
+				report.Matched += len(show.Rows) - len(show.Missing)
+				report.Failed += len(show.Missing)
+			}
+		case importer.Ambiguous:
+			report.Ambiguous += len(show.Rows)
+		default:
+			report.Failed += len(show.Rows)
+		}
+
+		report.Shows = append(report.Shows, show)
+	}
+
+	renderTemplate(w, r, "importReport", report)
+}
+
+// importProgress adds the matched show to the user's list and marks the
+// episodes in its rows watched
+func importProgress(ctx context.Context, userID int64, show *importShow) error {
+	details, err := repo.Show(ctx, show.Show.ID, false)
+	if err != nil {
+		return err
+	}
+
+	err = repo.AddShow(ctx, userID, details.ID)
+	if err != nil {
+		return err
+	}
+
+	episodes, missing := importer.SelectEpisodes(details.Seasons, show.Rows)
+	err = setEpisodesWatched(ctx, userID, details.ID, episodes)
+	if err != nil {
+		return err
+	}
+
+	show.Episodes = len(episodes)
+	show.Missing = missing
+	return nil
+}
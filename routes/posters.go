@@ -0,0 +1,71 @@
+package routes
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+
+	"github.com/jccroft1/goshowtrack/posters"
+	"github.com/jccroft1/goshowtrack/tvdbapi"
+)
+
+// posters rarely change, browsers keep them for a week and revalidate with
+// the ETag after that
+const posterMaxAge = 7 * 24 * 60 * 60
+
+// posterURL is the local URL of a show's poster, for templates
+func posterURL(showID int, size string) string {
+	return fmt.Sprintf("/posters/%d?size=%s", showID, size)
+}
+
+// PosterHandler serves shows' posters from the cache,
+// GET /posters/{id}?size=small|large
+func PosterHandler(cache *posters.Cache) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		servePoster(w, r, cache)
+	}
+}
+
+func servePoster(w http.ResponseWriter, r *http.Request, cache *posters.Cache) {
+	showID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid show ID", http.StatusBadRequest)
+		return
+	}
+
+	size := r.URL.Query().Get("size")
+	if size == "" {
+		size = "large"
+	}
+	if !slices.Contains(tvdbapi.PosterSizes, size) {
+		http.Error(w, "Invalid poster size", http.StatusBadRequest)
+		return
+	}
+
+	poster, err := cache.Get(r.Context(), showID, size)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to get poster", "err", err)
+		http.Error(w, "Failed to get poster", providerStatus(err))
+		return
+	}
+	if poster == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	file, err := os.Open(poster.Path)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to open poster", "err", err)
+		http.Error(w, "Failed to get poster", http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
+
+	w.Header().Set("Content-Type", poster.ContentType)
+	w.Header().Set("ETag", `"`+poster.Hash+`"`)
+	w.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d", posterMaxAge))
+	http.ServeContent(w, r, "", poster.FetchedAt, file)
+}
@@ -0,0 +1,87 @@
+package routes
+
+import (
+	"context"
+
+	"github.com/jccroft1/goshowtrack/store"
+	"github.com/jccroft1/goshowtrack/tvdbapi"
+)
+
+// watchProgress is the set of episodes a user has watched for a single show
+type watchProgress map[store.EpisodeKey]bool
+
+func getWatchProgress(ctx context.Context, userID int64, showID int) (watchProgress, error) {
+	return repo.Watched(ctx, userID, showID)
+}
+
+func (p watchProgress) Started() bool {
+	return len(p) > 0
+}
+
+func (p watchProgress) Watched(e tvdbapi.Episode) bool {
+	return p[store.EpisodeKey{Season: e.SeasonNumber, Episode: e.Number}]
+}
+
+// seasonStarted reports whether any episode of the season has been watched
+func (p watchProgress) seasonStarted(season tvdbapi.Season) bool {
+	for _, e := range season.Episodes {
+		if p.Watched(e) {
+			return true
+		}
+	}
+	return false
+}
+
+// watchedCount returns how many of the episodes have been watched
+func (p watchProgress) watchedCount(episodes []tvdbapi.Episode) int {
+	count := 0
+	for _, e := range episodes {
+		if p.Watched(e) {
+			count++
+		}
+	}
+	return count
+}
+
+// episodesToWatch returns the released episodes the user hasn't watched, in order.
+// Seasons that are still airing are only included once the user has started them,
+// so a new season is only suggested when it can be binge watched. Specials
+// (season 0) are never suggested.
+func episodesToWatch(seasons []tvdbapi.Season, progress watchProgress) []tvdbapi.Episode {
+	toWatch := []tvdbapi.Episode{}
+	for _, season := range seasons {
+		if season.Number == 0 {
+			continue
+		}
+		if !isReleased(season.LastAirDate) && !progress.seasonStarted(season) {
+			break
+		}
+
+		for _, e := range season.Episodes {
+			if progress.Watched(e) || !isReleased(e.AirDate) {
+				continue
+			}
+			toWatch = append(toWatch, e)
+		}
+	}
+
+	return toWatch
+}
+
+// allEpisodes returns the episodes across all seasons
+func allEpisodes(seasons []tvdbapi.Season) []tvdbapi.Episode {
+	episodes := []tvdbapi.Episode{}
+	for _, season := range seasons {
+		episodes = append(episodes, season.Episodes...)
+	}
+	return episodes
+}
+
+// setEpisodesWatched marks the episodes as watched for the user
+func setEpisodesWatched(ctx context.Context, userID int64, showID int, episodes []tvdbapi.Episode) error {
+	keys := make([]store.EpisodeKey, len(episodes))
+	for i, e := range episodes {
+		keys[i] = store.EpisodeKey{Season: e.SeasonNumber, Episode: e.Number}
+	}
+	return repo.SetWatched(ctx, userID, showID, keys)
+}
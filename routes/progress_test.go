@@ -0,0 +1,105 @@
+package routes
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/jccroft1/goshowtrack/store"
+	"github.com/jccroft1/goshowtrack/tvdbapi"
+)
+
+// episodeNames lists episodes as S1E2, to compare them in tests
+func episodeNames(episodes []tvdbapi.Episode) []string {
+	names := []string{}
+	for _, e := range episodes {
+		names = append(names, fmt.Sprintf("S%dE%d", e.SeasonNumber, e.Number))
+	}
+	return names
+}
+
+// season has an episode for each air date, "" for one without a date. It
+// finishes airing with its last dated episode.
+func season(number int, airDates ...string) tvdbapi.Season {
+	s := tvdbapi.Season{Number: number, EpisodeCount: len(airDates)}
+	for i, airDate := range airDates {
+		s.Episodes = append(s.Episodes, tvdbapi.Episode{SeasonNumber: number, Number: i + 1, AirDate: airDate})
+		if airDate != "" && airDate > s.LastAirDate {
+			s.LastAirDate = airDate
+		}
+	}
+	return s
+}
+
+func TestEpisodesToWatch(t *testing.T) {
+	const (
+		aired  = "2020-01-01"
+		future = "2999-01-01"
+	)
+
+	tests := []struct {
+		name    string
+		seasons []tvdbapi.Season
+		watched []store.EpisodeKey
+		want    []string
+	}{
+		{
+			name:    "nothing watched",
+			seasons: []tvdbapi.Season{season(1, aired, aired), season(2, aired)},
+			want:    []string{"S1E1", "S1E2", "S2E1"},
+		},
+		{
+			name:    "watched episodes skipped",
+			seasons: []tvdbapi.Season{season(1, aired, aired), season(2, aired)},
+			watched: []store.EpisodeKey{{Season: 1, Episode: 1}},
+			want:    []string{"S1E2", "S2E1"},
+		},
+		{
+			name:    "all watched",
+			seasons: []tvdbapi.Season{season(1, aired)},
+			watched: []store.EpisodeKey{{Season: 1, Episode: 1}},
+			want:    []string{},
+		},
+		{
+			name:    "specials skipped",
+			seasons: []tvdbapi.Season{season(0, aired, aired), season(1, aired)},
+			want:    []string{"S1E1"},
+		},
+		{
+			name:    "unaired season not suggested",
+			seasons: []tvdbapi.Season{season(1, aired), season(2, future, future)},
+			want:    []string{"S1E1"},
+		},
+		{
+			name:    "airing season waits until it's finished",
+			seasons: []tvdbapi.Season{season(1, aired), season(2, aired, future)},
+			watched: []store.EpisodeKey{{Season: 1, Episode: 1}},
+			want:    []string{},
+		},
+		{
+			name:    "started airing season only suggests aired episodes",
+			seasons: []tvdbapi.Season{season(1, aired), season(2, aired, aired, future)},
+			watched: []store.EpisodeKey{{Season: 1, Episode: 1}, {Season: 2, Episode: 1}},
+			want:    []string{"S2E2"},
+		},
+		{
+			name:    "episodes without an air date skipped",
+			seasons: []tvdbapi.Season{season(1, aired, "", aired)},
+			want:    []string{"S1E1", "S1E3"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			progress := watchProgress{}
+			for _, key := range tt.watched {
+				progress[key] = true
+			}
+
+			got := episodeNames(episodesToWatch(tt.seasons, progress))
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("episodesToWatch() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
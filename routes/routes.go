@@ -1,64 +1,123 @@
 package routes
 
 import (
-	"database/sql"
+	"context"
+	"errors"
 	"fmt"
 	"html/template"
-	"log"
+	"log/slog"
 	"net/http"
 	"sort"
 	"strings"
 	"time"
 
-	"github.com/jccroft1/goshowtrack/db"
+	"github.com/jccroft1/goshowtrack/auth"
+	"github.com/jccroft1/goshowtrack/store"
 	"github.com/jccroft1/goshowtrack/tvdbapi"
 )
 
-func renderTemplate(w http.ResponseWriter, tmpl string, data interface{}) {
-	tmplBase := template.New("layout").Funcs(template.FuncMap{
+// repo holds users' lists and progress, and the cached shows, tests replace
+// it with an in-memory fake
+var repo store.Store = store.SQL{}
+
+// searchShows searches the provider, tests replace it to avoid the network
+var searchShows = tvdbapi.SearchShow
+
+func renderTemplate(w http.ResponseWriter, r *http.Request, tmpl string, data interface{}) {
+	tmpls, err := parseTemplate(tmpl, templateFuncs(r))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to parse template", "template", tmpl, "err", err)
+		http.Error(w, "Failed to render template", http.StatusInternalServerError)
+		return
+	}
+
+	err = tmpls.ExecuteTemplate(w, "layout", data)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to render template", "template", tmpl, "err", err)
+		http.Error(w, "Failed to render template", http.StatusInternalServerError)
+		return
+	}
+}
+
+// templateFuncs are the functions pages can call, csrfField needs the request
+func templateFuncs(r *http.Request) template.FuncMap {
+	return template.FuncMap{
 		"dateToYear": dateToYear,
-	})
+		"posterURL":  posterURL,
+		// every POST form needs {{ csrfField }}, auth.Middleware rejects it otherwise
+		"csrfField": func() template.HTML {
+			return template.HTML(fmt.Sprintf(`<input type="hidden" name="%s" value="%s">`,
+				auth.CSRFField, template.HTMLEscapeString(auth.CSRFToken(r))))
+		},
+	}
+}
 
-	tmpls := template.Must(tmplBase.ParseFiles(
+// parseTemplate parses the page tmpl with the layout and partials it's shown in
+func parseTemplate(tmpl string, funcs template.FuncMap) (*template.Template, error) {
+	return template.New("layout").Funcs(funcs).ParseFiles(
 		"templates/layout.html",
 		"templates/"+tmpl+".html",
 		"templates/partials/searchBar.html",
 		"templates/partials/navBar.html",
 		"templates/partials/showStatus.html",
-	))
-	err := tmpls.ExecuteTemplate(w, "layout", data)
-	if err != nil {
-		log.Println(err)
-		http.Error(w, "Failed to render template", http.StatusInternalServerError)
-		return
+	)
+}
+
+// providerStatus is the response status for an error from the metadata provider
+func providerStatus(err error) int {
+	switch {
+	case errors.Is(err, tvdbapi.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, tvdbapi.ErrRateLimited), errors.Is(err, tvdbapi.ErrUnavailable):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
 	}
 }
 
-func userHasAddedShow(userID int64, showID int) bool {
-	var id int
-	err := db.Connection.QueryRow("SELECT user_id FROM user_shows WHERE show_id = ? AND user_id = ?", showID, userID).Scan(&id)
-	if err != nil && err != sql.ErrNoRows {
-		log.Println("Error checking if user has added show: ", err)
+func userHasAddedShow(ctx context.Context, userID int64, showID int) bool {
+	added, err := repo.HasShow(ctx, userID, showID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error checking if user has added show", "err", err)
 		return false
 	}
 
-	return err != sql.ErrNoRows
+	return added
+}
+
+// userAddedShows returns the set of shows the user has added, to mark search
+// results without a query for each
+func userAddedShows(ctx context.Context, userID int64) map[int]bool {
+	showIDs, err := repo.ShowIDs(ctx, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error checking if user has added shows", "err", err)
+	}
+
+	added := map[int]bool{}
+	for _, showID := range showIDs {
+		added[showID] = true
+	}
+	return added
 }
 
 // Render the results to the user
 type ShowData struct {
 	// TVDB Data
-	ID          int
-	Name        string
-	AirDate     string
-	Description string
-	Poster      string
-	Status      string
-	SeasonCount int
+	ID          int    `json:"id"`
+	Name        string `json:"name"`
+	AirDate     string `json:"air_date"`
+	Description string `json:"description"`
+	Poster      string `json:"poster"`
+	Status      string `json:"status"`
+	SeasonCount int    `json:"season_count"`
+
+	// User progress
+	EpisodeCount int `json:"episode_count"`
+	WatchedCount int `json:"watched_count"`
 
 	// UI features
-	Order     string
-	Unwatched int
+	Order     string `json:"-"`
+	Unwatched int    `json:"unwatched"`
 }
 
 func orderShows(shows []ShowData) []ShowData {
@@ -83,7 +142,7 @@ func isReleased(seasonAirDate string) bool {
 
 	parsedAirDate, err := time.Parse("2006-01-02", seasonAirDate)
 	if err != nil {
-		log.Println("Error parsing finish date:", err)
+		slog.Error("Error parsing finish date", "err", err)
 		return false
 	}
 
@@ -92,23 +151,6 @@ func isReleased(seasonAirDate string) bool {
 	return now.After(parsedAirDate)
 }
 
-func hasSomethingToWatch(seasons []tvdbapi.Season, watchedSeasons int) ([]int, bool) {
-	if len(seasons) < watchedSeasons {
-		return []int{}, false
-	}
-
-	toWatch := []int{}
-	for _, season := range seasons[watchedSeasons:] {
-		if !isReleased(season.LastAirDate) {
-			break
-		}
-
-		toWatch = append(toWatch, season.Number)
-	}
-
-	return toWatch, len(toWatch) > 0
-}
-
 func getReturningInfo(show tvdbapi.ShowDetail) string {
 	for _, season := range show.Seasons {
 		if isReleased(season.LastAirDate) {
@@ -2,7 +2,7 @@ package routes
 
 import (
 	"encoding/json"
-	"log"
+	"log/slog"
 	"net/http"
 
 	"github.com/jccroft1/goshowtrack/auth"
@@ -11,32 +11,32 @@ import (
 
 func SearchHandler(w http.ResponseWriter, req *http.Request) {
 	if req.URL.Query().Get("bulk") == "true" {
-		renderTemplate(w, "searchBulk", nil)
+		renderTemplate(w, req, "searchBulk", nil)
 		return
 	}
 
-	renderTemplate(w, "search", nil)
+	renderTemplate(w, req, "search", nil)
 }
 
 func SearchResultsHandler(w http.ResponseWriter, req *http.Request) {
 	userID, ok := auth.GetUserID(req)
 	if !ok {
-		log.Println("user not authenticated")
+		slog.WarnContext(req.Context(), "User not authenticated")
 		http.Error(w, "User not authenticated", http.StatusUnauthorized)
 		return
 	}
 
 	query := req.FormValue("query")
 	if query == "" {
-		log.Println("search request missing 'query'")
+		slog.WarnContext(req.Context(), "Search request missing 'query'")
 		http.Error(w, "Query is required", http.StatusBadRequest)
 		return
 	}
 
-	searchResults, err := tvdbapi.SearchShow(query)
+	searchResults, err := searchShows(req.Context(), query)
 	if err != nil {
-		log.Println("search failed", err)
-		http.Error(w, "Failed to search TVDB", http.StatusInternalServerError)
+		slog.ErrorContext(req.Context(), "Search failed", "err", err)
+		http.Error(w, "Failed to search TVDB", providerStatus(err))
 		return
 	}
 
@@ -58,6 +58,7 @@ func SearchResultsHandler(w http.ResponseWriter, req *http.Request) {
 		Results: make([]ShowData, len(searchResults)),
 		Query:   query,
 	}
+	added := userAddedShows(req.Context(), userID)
 	for i, show := range searchResults {
 
 		data.Results[i] = ShowData{
@@ -67,12 +68,12 @@ func SearchResultsHandler(w http.ResponseWriter, req *http.Request) {
 			Description: show.Description,
 			Poster:      show.PosterPath,
 
-			Added: userHasAddedShow(userID, show.ID),
+			Added: added[show.ID],
 		}
 
 	}
 
-	renderTemplate(w, "searchResults", data)
+	renderTemplate(w, req, "searchResults", data)
 }
 
 func AutofillHandler(w http.ResponseWriter, r *http.Request) {
@@ -81,7 +82,7 @@ func AutofillHandler(w http.ResponseWriter, r *http.Request) {
 	if len(searchName) < 3 {
 		err := json.NewEncoder(w).Encode([]string{})
 		if err != nil {
-			log.Println("empty autofill handler response failed", err)
+			slog.ErrorContext(r.Context(), "Empty autofill handler response failed", "err", err)
 			return
 		}
 		return
@@ -91,7 +92,7 @@ func AutofillHandler(w http.ResponseWriter, r *http.Request) {
 
 	err := json.NewEncoder(w).Encode(found)
 	if err != nil {
-		log.Println("autofill handler response failed", err)
+		slog.ErrorContext(r.Context(), "Autofill handler response failed", "err", err)
 		return
 	}
 }
@@ -1,12 +1,11 @@
 package routes
 
 import (
-	"database/sql"
-	"log"
+	"context"
+	"log/slog"
 	"net/http"
 
 	"github.com/jccroft1/goshowtrack/auth"
-	"github.com/jccroft1/goshowtrack/db"
 	"github.com/jccroft1/goshowtrack/tvdbapi"
 )
 
@@ -17,14 +16,12 @@ func AllHandler(w http.ResponseWriter, req *http.Request) {
 		sortType = "name"
 	}
 
-	op := func(userID int64, show *tvdbapi.ShowDetail) (bool, ShowData) {
-		watchedSeasons := 0
-		err := db.Connection.QueryRow(`SELECT season_number FROM user_seasons WHERE user_id = ? AND show_id = ?;`, userID, show.ID).Scan(&watchedSeasons)
-		if err != nil && err != sql.ErrNoRows {
-			log.Println("Failed to get shows", err)
-			return false, ShowData{}
-		}
+	listHandler(w, req, allFilter(sortType), sortType)
+}
 
+// allFilter selects every show, ordered by sortType
+func allFilter(sortType string) listFilter {
+	return func(show *tvdbapi.ShowDetail, progress watchProgress) (bool, ShowData) {
 		newShowData := ShowData{
 			ID:          show.ID,
 			Name:        show.Name,
@@ -34,6 +31,9 @@ func AllHandler(w http.ResponseWriter, req *http.Request) {
 			Status:      show.Status,
 			SeasonCount: len(show.Seasons),
 
+			EpisodeCount: len(allEpisodes(show.Seasons)),
+			WatchedCount: progress.watchedCount(allEpisodes(show.Seasons)),
+
 			Order: show.Name,
 		}
 
@@ -41,8 +41,8 @@ func AllHandler(w http.ResponseWriter, req *http.Request) {
 			newShowData.Status = getReturningInfo(*show)
 		}
 
-		unwatchedSeasons, _ := hasSomethingToWatch(show.Seasons, watchedSeasons)
-		newShowData.Unwatched = len(unwatchedSeasons)
+		toWatch := episodesToWatch(show.Seasons, progress)
+		newShowData.Unwatched = len(toWatch)
 
 		finished := tvdbapi.IsFinished(show.Status)
 
@@ -50,7 +50,7 @@ func AllHandler(w http.ResponseWriter, req *http.Request) {
 		case "first_release":
 			newShowData.Order = show.AirDate
 		case "watch_status":
-			if watchedSeasons > 0 && len(unwatchedSeasons) > 0 {
+			if progress.Started() && len(toWatch) > 0 {
 				// started, something to watch
 				newShowData.Order = "0"
 				if finished {
@@ -58,8 +58,8 @@ func AllHandler(w http.ResponseWriter, req *http.Request) {
 				} else {
 					newShowData.Order += "1"
 				}
-				newShowData.Order += show.Seasons[watchedSeasons].AirDate
-			} else if len(unwatchedSeasons) > 0 {
+				newShowData.Order += toWatch[0].AirDate
+			} else if len(toWatch) > 0 {
 				// not started
 				newShowData.Order = "1"
 				if finished {
@@ -67,7 +67,7 @@ func AllHandler(w http.ResponseWriter, req *http.Request) {
 				} else {
 					newShowData.Order += "1"
 				}
-				newShowData.Order += show.Seasons[watchedSeasons].AirDate
+				newShowData.Order += toWatch[0].AirDate
 			} else {
 				// watched all
 				newShowData.Order = "2"
@@ -83,216 +83,220 @@ func AllHandler(w http.ResponseWriter, req *http.Request) {
 
 		return true, newShowData
 	}
-
-	listHandler(w, req, op, sortType)
 }
 
 // HomeHandler lists unfinished shows the user can watch
 func HomeHandler(w http.ResponseWriter, req *http.Request) {
-	op := func(userID int64, show *tvdbapi.ShowDetail) (bool, ShowData) {
-		watchedSeasons := 0
-		err := db.Connection.QueryRow(`SELECT season_number FROM user_seasons WHERE user_id = ? AND show_id = ?`, userID, show.ID).Scan(&watchedSeasons)
-		if err != nil && err != sql.ErrNoRows {
-			log.Println("Failed to get shows", err)
-			return false, ShowData{}
-		}
+	listHandler(w, req, homeFilter, "")
+}
 
-		if watchedSeasons <= 0 {
-			return false, ShowData{}
-		}
+// homeFilter selects unfinished shows the user can watch
+func homeFilter(show *tvdbapi.ShowDetail, progress watchProgress) (bool, ShowData) {
+	if !progress.Started() {
+		return false, ShowData{}
+	}
 
-		unwatchedSeasons, somethingToWatch := hasSomethingToWatch(show.Seasons, watchedSeasons)
+	toWatch := episodesToWatch(show.Seasons, progress)
 
-		if !somethingToWatch {
-			return false, ShowData{}
-		}
+	if len(toWatch) == 0 {
+		return false, ShowData{}
+	}
 
-		newShowData := ShowData{
-			ID:          show.ID,
-			Name:        show.Name,
-			AirDate:     show.AirDate,
-			Description: show.Description,
-			Poster:      show.PosterPath,
-			Status:      show.Status,
-			SeasonCount: len(show.Seasons),
+	newShowData := ShowData{
+		ID:          show.ID,
+		Name:        show.Name,
+		AirDate:     show.AirDate,
+		Description: show.Description,
+		Poster:      show.PosterPath,
+		Status:      show.Status,
+		SeasonCount: len(show.Seasons),
 
-			Unwatched: len(unwatchedSeasons),
-		}
-		if show.Status == "Returning Series" {
-			newShowData.Status = getReturningInfo(*show)
-		}
+		EpisodeCount: len(allEpisodes(show.Seasons)),
+		WatchedCount: progress.watchedCount(allEpisodes(show.Seasons)),
 
-		finished := tvdbapi.IsFinished(show.Status)
+		Unwatched: len(toWatch),
+	}
+	if show.Status == "Returning Series" {
+		newShowData.Status = getReturningInfo(*show)
+	}
 
-		if finished {
-			newShowData.Order = "0"
-		} else {
-			newShowData.Order = "1"
-		}
-		newShowData.Order += show.Seasons[watchedSeasons].AirDate
+	finished := tvdbapi.IsFinished(show.Status)
 
-		return true, newShowData
+	if finished {
+		newShowData.Order = "0"
+	} else {
+		newShowData.Order = "1"
 	}
+	newShowData.Order += toWatch[0].AirDate
 
-	listHandler(w, req, op, "")
+	return true, newShowData
 }
 
 // StartHandler lists shows the user can start watching
 func StartHandler(w http.ResponseWriter, req *http.Request) {
-	op := func(userID int64, show *tvdbapi.ShowDetail) (bool, ShowData) {
-		watchedSeasons := 0
-		err := db.Connection.QueryRow(`SELECT season_number FROM user_seasons WHERE user_id = ? AND show_id = ?`, userID, show.ID).Scan(&watchedSeasons)
-		if err != nil && err != sql.ErrNoRows {
-			log.Println("Failed to get shows", err)
-			return false, ShowData{}
-		}
-		if watchedSeasons > 0 {
-			return false, ShowData{}
-		}
+	listHandler(w, req, startFilter, "")
+}
 
-		unwatchedSeasons, somethingToWatch := hasSomethingToWatch(show.Seasons, watchedSeasons)
+// startFilter selects shows the user can start watching
+func startFilter(show *tvdbapi.ShowDetail, progress watchProgress) (bool, ShowData) {
+	if progress.Started() {
+		return false, ShowData{}
+	}
 
-		if !somethingToWatch {
-			return false, ShowData{}
-		}
+	toWatch := episodesToWatch(show.Seasons, progress)
 
-		newShowData := ShowData{
-			ID:          show.ID,
-			Name:        show.Name,
-			AirDate:     show.AirDate,
-			Description: show.Description,
-			Poster:      show.PosterPath,
-			Status:      show.Status,
-			SeasonCount: len(show.Seasons),
+	if len(toWatch) == 0 {
+		return false, ShowData{}
+	}
 
-			Unwatched: len(unwatchedSeasons),
-		}
-		if show.Status == "Returning Series" {
-			newShowData.Status = getReturningInfo(*show)
-		}
+	newShowData := ShowData{
+		ID:          show.ID,
+		Name:        show.Name,
+		AirDate:     show.AirDate,
+		Description: show.Description,
+		Poster:      show.PosterPath,
+		Status:      show.Status,
+		SeasonCount: len(show.Seasons),
 
-		finished := tvdbapi.IsFinished(show.Status)
+		EpisodeCount: len(allEpisodes(show.Seasons)),
+		WatchedCount: progress.watchedCount(allEpisodes(show.Seasons)),
 
-		if finished {
-			newShowData.Order = "0"
-		} else {
-			newShowData.Order = "1"
-		}
-		newShowData.Order += show.Seasons[watchedSeasons].AirDate
+		Unwatched: len(toWatch),
+	}
+	if show.Status == "Returning Series" {
+		newShowData.Status = getReturningInfo(*show)
+	}
 
-		return true, newShowData
+	finished := tvdbapi.IsFinished(show.Status)
+
+	if finished {
+		newShowData.Order = "0"
+	} else {
+		newShowData.Order = "1"
 	}
+	newShowData.Order += toWatch[0].AirDate
 
-	listHandler(w, req, op, "")
+	return true, newShowData
 }
 
 func ComingSoonHandler(w http.ResponseWriter, req *http.Request) {
-	op := func(userID int64, show *tvdbapi.ShowDetail) (bool, ShowData) {
-		watchedSeasons := 0
-		err := db.Connection.QueryRow(`SELECT season_number FROM user_seasons WHERE user_id = ? AND show_id = ?`, userID, show.ID).Scan(&watchedSeasons)
-		if err != nil && err != sql.ErrNoRows {
-			log.Println("Failed to get shows", err)
-			return false, ShowData{}
-		}
-		_, somethingToWatch := hasSomethingToWatch(show.Seasons, watchedSeasons)
+	listHandler(w, req, comingSoonFilter, "")
+}
 
-		if somethingToWatch {
-			return false, ShowData{}
-		}
+// comingSoonFilter selects shows waiting on new episodes
+func comingSoonFilter(show *tvdbapi.ShowDetail, progress watchProgress) (bool, ShowData) {
+	if len(episodesToWatch(show.Seasons, progress)) > 0 {
+		return false, ShowData{}
+	}
 
-		finished := tvdbapi.IsFinished(show.Status)
-		if finished {
-			return false, ShowData{}
-		}
+	finished := tvdbapi.IsFinished(show.Status)
+	if finished {
+		return false, ShowData{}
+	}
 
-		newShowData := ShowData{
-			ID:          show.ID,
-			Name:        show.Name,
-			AirDate:     show.AirDate,
-			Description: show.Description,
-			Poster:      show.PosterPath,
-			Status:      show.Status,
-			SeasonCount: len(show.Seasons),
+	newShowData := ShowData{
+		ID:          show.ID,
+		Name:        show.Name,
+		AirDate:     show.AirDate,
+		Description: show.Description,
+		Poster:      show.PosterPath,
+		Status:      show.Status,
+		SeasonCount: len(show.Seasons),
 
-			Order: "9999",
-		}
-		if show.Status == "Returning Series" {
-			newShowData.Status = getReturningInfo(*show)
-		}
+		EpisodeCount: len(allEpisodes(show.Seasons)),
+		WatchedCount: progress.watchedCount(allEpisodes(show.Seasons)),
 
-		// get the air data of the next season that's not yet released
-		for _, season := range show.Seasons {
-			if isReleased(season.LastAirDate) {
-				// 0/1 - hack to put the shows with an unreleased season with a known date first
-				newShowData.Order = "1" + season.LastAirDate
-				continue
-			}
+		Order: "9999",
+	}
+	if show.Status == "Returning Series" {
+		newShowData.Status = getReturningInfo(*show)
+	}
 
-			if season.LastAirDate != "" {
-				newShowData.Order = "0" + season.LastAirDate
-			}
+	// get the air data of the next season that's not yet released
+	for _, season := range show.Seasons {
+		if isReleased(season.LastAirDate) {
+			// 0/1 - hack to put the shows with an unreleased season with a known date first
+			newShowData.Order = "1" + season.LastAirDate
+			continue
+		}
 
-			break
+		if season.LastAirDate != "" {
+			newShowData.Order = "0" + season.LastAirDate
 		}
 
-		return true, newShowData
+		break
 	}
 
-	listHandler(w, req, op, "")
+	return true, newShowData
 }
 
-func listHandler(w http.ResponseWriter, r *http.Request, op func(int64, *tvdbapi.ShowDetail) (bool, ShowData), sort string) {
+// listFilter decides if a show belongs in a list, given the user's progress, and builds its entry
+type listFilter func(show *tvdbapi.ShowDetail, progress watchProgress) (bool, ShowData)
+
+func listHandler(w http.ResponseWriter, r *http.Request, op listFilter, sort string) {
 	userID, ok := auth.GetUserID(r)
 	if !ok {
 		http.Error(w, "User not authenticated", http.StatusUnauthorized)
 		return
 	}
 
-	// SQL to fetch the User's Shows
-	showResults, err := db.Connection.Query(`SELECT show_id FROM user_shows WHERE user_id = ?`, userID)
+	list, err := loadUserList(r.Context(), userID, op)
 	if err != nil {
-		log.Println("Error fetch user show list: ", err)
+		slog.ErrorContext(r.Context(), "Error fetch user show list", "err", err)
 		http.Error(w, "Failed to fetch user shows", http.StatusInternalServerError)
 		return
 	}
 
-	// Fetch all shows for the user
-	var showIDs []int
-	for showResults.Next() {
-		var showID int
-		err := showResults.Scan(&showID)
-		if err != nil {
-			log.Println("Error scanning row: ", err)
-			continue
-		}
-		showIDs = append(showIDs, showID)
+	type ListData struct {
+		Sort string
+		List []ShowData
 	}
-	showResults.Close()
 
-	var list []ShowData
-	for _, showID := range showIDs {
-		show, err := tvdbapi.GetShowDetails(showID, false)
-		if err != nil {
-			log.Println("Error getting show details: ", err)
-			continue
+	// Render home page
+	renderTemplate(w, r, "showsList", ListData{Sort: sort, List: list})
+}
+
+// listFilterByName returns the filter behind each list page, used by the API
+func listFilterByName(name string, sort string) (listFilter, bool) {
+	switch name {
+	case "home":
+		return homeFilter, true
+	case "start":
+		return startFilter, true
+	case "comingsoon":
+		return comingSoonFilter, true
+	case "all":
+		if sort == "" {
+			sort = "name"
 		}
+		return allFilter(sort), true
+	default:
+		return nil, false
+	}
+}
 
-		add, newShow := op(userID, show)
+// loadUserList returns the user's shows selected by op, in order. The shows
+// and the user's progress are each loaded in one go, however long the list is.
+func loadUserList(ctx context.Context, userID int64, op listFilter) ([]ShowData, error) {
+	shows, err := repo.ListShows(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	watched, err := repo.AllWatched(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	list := []ShowData{}
+	for _, show := range shows {
+		add, newShow := op(show, watched[show.ID])
 		if !add {
 			continue
 		}
 
 		list = append(list, newShow)
-
-	}
-
-	type ListData struct {
-		Sort string
-		List []ShowData
 	}
 
-	orderShows(list)
-
-	// Render home page
-	renderTemplate(w, "showsList", ListData{Sort: sort, List: list})
+	return orderShows(list), nil
 }
@@ -0,0 +1,147 @@
+package routes
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jccroft1/goshowtrack/store"
+	"github.com/jccroft1/goshowtrack/tvdbapi"
+)
+
+// testShow has a released season for each of released, then one that hasn't aired
+func testShow(id int, name string, status string, released int, upcoming bool) *tvdbapi.ShowDetail {
+	show := &tvdbapi.ShowDetail{ID: id, Name: name, Status: status, AirDate: "2020-01-01"}
+	for n := 1; n <= released; n++ {
+		show.Seasons = append(show.Seasons, tvdbapi.Season{
+			Number: n, EpisodeCount: 2, AirDate: "2020-01-01", LastAirDate: "2020-01-08",
+			Episodes: []tvdbapi.Episode{
+				{SeasonNumber: n, Number: 1, AirDate: "2020-01-01"},
+				{SeasonNumber: n, Number: 2, AirDate: "2020-01-08"},
+			},
+		})
+	}
+	if upcoming {
+		n := released + 1
+		show.Seasons = append(show.Seasons, tvdbapi.Season{
+			Number: n, EpisodeCount: 1, AirDate: "2999-01-01", LastAirDate: "2999-01-01",
+			Episodes: []tvdbapi.Episode{{SeasonNumber: n, Number: 1, AirDate: "2999-01-01"}},
+		})
+	}
+	return show
+}
+
+func TestLoadUserList(t *testing.T) {
+	fake := newFakeStore(t,
+		testShow(1, "Watching", "Ended", 2, false),
+		testShow(2, "Not Started", "Ended", 1, false),
+		testShow(3, "Waiting", "Returning Series", 1, true),
+		testShow(4, "Someone Else's", "Ended", 1, false),
+	)
+	ctx := context.Background()
+	for _, showID := range []int{1, 2, 3} {
+		fake.AddShow(ctx, 1, showID)
+	}
+	fake.AddShow(ctx, 2, 4)
+	fake.SetWatched(ctx, 1, 1, []store.EpisodeKey{{Season: 1, Episode: 1}, {Season: 1, Episode: 2}})
+	fake.SetWatched(ctx, 1, 3, []store.EpisodeKey{{Season: 1, Episode: 1}, {Season: 1, Episode: 2}})
+
+	tests := []struct {
+		filter string
+		want   []string
+	}{
+		{filter: "home", want: []string{"Watching"}},
+		{filter: "start", want: []string{"Not Started"}},
+		{filter: "comingsoon", want: []string{"Waiting"}},
+		{filter: "all", want: []string{"Not Started", "Waiting", "Watching"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			op, ok := listFilterByName(tt.filter, "")
+			if !ok {
+				t.Fatalf("no filter %q", tt.filter)
+			}
+
+			list, err := loadUserList(ctx, 1, op)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var names []string
+			for _, show := range list {
+				names = append(names, show.Name)
+			}
+			if strings.Join(names, ", ") != strings.Join(tt.want, ", ") {
+				t.Errorf("list = %v, want %v", names, tt.want)
+			}
+		})
+	}
+
+	list, err := loadUserList(ctx, 1, homeFilter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].WatchedCount != 2 || list[0].EpisodeCount != 4 || list[0].Unwatched != 2 {
+		t.Errorf("home = %+v, want 2 of 4 episodes watched", list)
+	}
+}
+
+func TestUpdateWatched(t *testing.T) {
+	show := testShow(1, "Watching", "Returning Series", 2, true)
+	fake := newFakeStore(t, show)
+	ctx := context.Background()
+
+	// watching a season includes the released seasons before it
+	err := updateWatched(ctx, 1, show, 3, 0, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added, _ := fake.HasShow(ctx, 1, 1); !added {
+		t.Error("watching a show didn't add it to the list")
+	}
+	progress, _ := getWatchProgress(ctx, 1, 1)
+	if len(progress) != 4 || progress[store.EpisodeKey{Season: 3, Episode: 1}] {
+		t.Errorf("watched = %v, want the 4 released episodes", progress)
+	}
+
+	// unwatching a season includes the seasons after it
+	err = updateWatched(ctx, 1, show, 2, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = updateWatched(ctx, 1, show, 1, 2, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	progress, _ = getWatchProgress(ctx, 1, 1)
+	if len(progress) != 1 || !progress[store.EpisodeKey{Season: 1, Episode: 1}] {
+		t.Errorf("watched = %v, want only S1E1", progress)
+	}
+}
+
+func TestCalendarHandler(t *testing.T) {
+	fake := newFakeStore(t, testShow(1, "Waiting", "Returning Series", 1, true))
+	fake.AddShow(context.Background(), 1, 1)
+	fake.tokens[1] = "secret"
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /calendar/{token}", CalendarHandler)
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/calendar/guess.ics", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("unknown token status = %d, want 404", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/calendar/secret.ics", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), "SUMMARY:Waiting S02E01") {
+		t.Errorf("calendar is missing the upcoming episode:\n%s", w.Body.String())
+	}
+}
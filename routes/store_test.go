@@ -0,0 +1,146 @@
+package routes
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jccroft1/goshowtrack/store"
+	"github.com/jccroft1/goshowtrack/tvdbapi"
+)
+
+// fakeStore keeps everything in memory, for testing handlers without a database
+type fakeStore struct {
+	shows   map[int]*tvdbapi.ShowDetail
+	lists   map[int64][]int
+	watched map[int64]map[int]map[store.EpisodeKey]bool
+	tokens  map[int64]string
+}
+
+func newFakeStore(t *testing.T, shows ...*tvdbapi.ShowDetail) *fakeStore {
+	t.Helper()
+
+	f := &fakeStore{
+		shows:   map[int]*tvdbapi.ShowDetail{},
+		lists:   map[int64][]int{},
+		watched: map[int64]map[int]map[store.EpisodeKey]bool{},
+		tokens:  map[int64]string{},
+	}
+	for _, show := range shows {
+		f.shows[show.ID] = show
+	}
+
+	old := repo
+	repo = f
+	t.Cleanup(func() { repo = old })
+	return f
+}
+
+func (f *fakeStore) ShowIDs(ctx context.Context, userID int64) ([]int, error) {
+	return append([]int{}, f.lists[userID]...), nil
+}
+
+func (f *fakeStore) HasShow(ctx context.Context, userID int64, showID int) (bool, error) {
+	for _, id := range f.lists[userID] {
+		if id == showID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (f *fakeStore) AddShow(ctx context.Context, userID int64, showID int) error {
+	if added, _ := f.HasShow(ctx, userID, showID); !added {
+		f.lists[userID] = append(f.lists[userID], showID)
+	}
+	return nil
+}
+
+func (f *fakeStore) RemoveShow(ctx context.Context, userID int64, showID int) error {
+	list := []int{}
+	for _, id := range f.lists[userID] {
+		if id != showID {
+			list = append(list, id)
+		}
+	}
+	f.lists[userID] = list
+	return nil
+}
+
+func (f *fakeStore) Watched(ctx context.Context, userID int64, showID int) (map[store.EpisodeKey]bool, error) {
+	watched := map[store.EpisodeKey]bool{}
+	for key := range f.watched[userID][showID] {
+		watched[key] = true
+	}
+	return watched, nil
+}
+
+func (f *fakeStore) AllWatched(ctx context.Context, userID int64) (map[int]map[store.EpisodeKey]bool, error) {
+	all := map[int]map[store.EpisodeKey]bool{}
+	for showID := range f.watched[userID] {
+		all[showID], _ = f.Watched(ctx, userID, showID)
+	}
+	return all, nil
+}
+
+func (f *fakeStore) SetWatched(ctx context.Context, userID int64, showID int, episodes []store.EpisodeKey) error {
+	if f.watched[userID] == nil {
+		f.watched[userID] = map[int]map[store.EpisodeKey]bool{}
+	}
+	if f.watched[userID][showID] == nil {
+		f.watched[userID][showID] = map[store.EpisodeKey]bool{}
+	}
+	for _, key := range episodes {
+		f.watched[userID][showID][key] = true
+	}
+	return nil
+}
+
+func (f *fakeStore) UnsetWatched(ctx context.Context, userID int64, showID int, episode store.EpisodeKey) error {
+	delete(f.watched[userID][showID], episode)
+	return nil
+}
+
+func (f *fakeStore) UnsetWatchedFrom(ctx context.Context, userID int64, showID int, seasonNumber int) error {
+	for key := range f.watched[userID][showID] {
+		if key.Season >= seasonNumber {
+			delete(f.watched[userID][showID], key)
+		}
+	}
+	return nil
+}
+
+func (f *fakeStore) Show(ctx context.Context, showID int, refresh bool) (*tvdbapi.ShowDetail, error) {
+	show, ok := f.shows[showID]
+	if !ok {
+		return nil, tvdbapi.ErrNotFound
+	}
+	return show, nil
+}
+
+func (f *fakeStore) ListShows(ctx context.Context, userID int64) ([]*tvdbapi.ShowDetail, error) {
+	shows := []*tvdbapi.ShowDetail{}
+	for _, showID := range f.lists[userID] {
+		if show, ok := f.shows[showID]; ok {
+			shows = append(shows, show)
+		}
+	}
+	return shows, nil
+}
+
+func (f *fakeStore) CalendarUser(ctx context.Context, token string) (int64, bool, error) {
+	for userID, t := range f.tokens {
+		if t == token {
+			return userID, true, nil
+		}
+	}
+	return 0, false, nil
+}
+
+func (f *fakeStore) CalendarToken(ctx context.Context, userID int64) (string, error) {
+	return f.tokens[userID], nil
+}
+
+func (f *fakeStore) SetCalendarToken(ctx context.Context, userID int64, token string) error {
+	f.tokens[userID] = token
+	return nil
+}
@@ -0,0 +1,63 @@
+package routes
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/jccroft1/goshowtrack/auth"
+)
+
+// CreateTokenHandler creates an API token and shows it once on the about page, POST /tokens
+func CreateTokenHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserID(r)
+	if !ok {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	name := strings.TrimSpace(r.FormValue("name"))
+	if name == "" {
+		http.Error(w, "Name is required", http.StatusBadRequest)
+		return
+	}
+	scope := r.FormValue("scope")
+	if !auth.ValidScope(scope) {
+		http.Error(w, "Invalid scope", http.StatusBadRequest)
+		return
+	}
+
+	token, err := auth.CreateToken(userID, name, scope)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to create API token", "err", err)
+		http.Error(w, "Failed to create API token", http.StatusInternalServerError)
+		return
+	}
+
+	renderAbout(w, r, token)
+}
+
+// RevokeTokenHandler deletes one of the user's API tokens, POST /tokens/{id}/revoke
+func RevokeTokenHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserID(r)
+	if !ok {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	tokenID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid token ID", http.StatusBadRequest)
+		return
+	}
+
+	err = auth.RevokeToken(userID, tokenID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to revoke API token", "err", err)
+		http.Error(w, "Token not found", http.StatusNotFound)
+		return
+	}
+
+	http.Redirect(w, r, "/about#tokens", http.StatusSeeOther)
+}
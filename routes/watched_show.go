@@ -1,13 +1,15 @@
 package routes
 
 import (
+	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"strconv"
 
 	"github.com/jccroft1/goshowtrack/auth"
-	"github.com/jccroft1/goshowtrack/db"
+	"github.com/jccroft1/goshowtrack/store"
+	"github.com/jccroft1/goshowtrack/tvdbapi"
 )
 
 func WatchedHandler(w http.ResponseWriter, r *http.Request) {
@@ -19,7 +21,7 @@ func UnwatchedHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func userWatchedUpdate(w http.ResponseWriter, r *http.Request, watched bool) {
-	showIDStr := r.URL.Query().Get("show_id")
+	showIDStr := r.FormValue("show_id")
 	if showIDStr == "" {
 		http.Error(w, "No ID provided", http.StatusBadRequest)
 		return
@@ -27,12 +29,12 @@ func userWatchedUpdate(w http.ResponseWriter, r *http.Request, watched bool) {
 
 	showID, err := strconv.Atoi(showIDStr)
 	if err != nil {
-		log.Println("Invalid ID provided", err)
+		slog.WarnContext(r.Context(), "Invalid ID provided", "err", err)
 		http.Error(w, "Invalid ID provided", http.StatusBadRequest)
 		return
 	}
 
-	seasonNumberStr := r.URL.Query().Get("season")
+	seasonNumberStr := r.FormValue("season")
 	if seasonNumberStr == "" {
 		http.Error(w, "No ID provided", http.StatusBadRequest)
 		return
@@ -40,41 +42,84 @@ func userWatchedUpdate(w http.ResponseWriter, r *http.Request, watched bool) {
 
 	seasonNumber, err := strconv.Atoi(seasonNumberStr)
 	if err != nil {
-		log.Println("Invalid ID provided", err)
+		slog.WarnContext(r.Context(), "Invalid ID provided", "err", err)
 		http.Error(w, "Invalid ID provided", http.StatusBadRequest)
 		return
 	}
 
+	episodeNumber := 0
+	episodeNumberStr := r.FormValue("episode")
+	if episodeNumberStr != "" {
+		episodeNumber, err = strconv.Atoi(episodeNumberStr)
+		if err != nil {
+			slog.WarnContext(r.Context(), "Invalid episode provided", "err", err)
+			http.Error(w, "Invalid episode provided", http.StatusBadRequest)
+			return
+		}
+	}
+
 	userID, ok := auth.GetUserID(r)
 	if !ok {
 		http.Error(w, "User not authenticated", http.StatusUnauthorized)
 		return
 	}
 
-	if !watched {
-		seasonNumber--
+	showDetails, err := repo.Show(r.Context(), showID, false)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error searching TVDB", "err", err)
+		http.Error(w, "Error searching TVDB", providerStatus(err))
+		return
+	}
+
+	err = updateWatched(r.Context(), userID, showDetails, seasonNumber, episodeNumber, watched)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error updating watched episodes", "err", err)
+		http.Error(w, "Error updating watched episodes", http.StatusInternalServerError)
+		return
 	}
 
-	if seasonNumber > 0 {
+	// redirect to show details page
+	http.Redirect(w, r, fmt.Sprintf("/show/details?id=%v", showID), http.StatusSeeOther)
+}
+
+// updateWatched marks a single episode when episodeNumber is set. Otherwise
+// watching a season includes every season before it and unwatching a season
+// includes every season after it.
+func updateWatched(ctx context.Context, userID int64, show *tvdbapi.ShowDetail, seasonNumber int, episodeNumber int, watched bool) error {
+	if watched {
 		// ensure the user has added the show
-		err := addShow(userID, showID)
+		err := repo.AddShow(ctx, userID, show.ID)
 		if err != nil {
-			log.Println("Error adding show to user, ignoring", err)
+			slog.WarnContext(ctx, "Error adding show to user, ignoring", "err", err)
 		}
+
+		return setEpisodesWatched(ctx, userID, show.ID, selectEpisodes(show.Seasons, seasonNumber, episodeNumber))
 	}
 
-	query := `INSERT INTO user_seasons (user_id, show_id, season_number) 
-	VALUES (?, ?, ?)
-	ON CONFLICT(user_id, show_id) DO UPDATE SET
-		season_number = EXCLUDED.season_number;`
+	if episodeNumber > 0 {
+		return repo.UnsetWatched(ctx, userID, show.ID, store.EpisodeKey{Season: seasonNumber, Episode: episodeNumber})
+	}
+	return repo.UnsetWatchedFrom(ctx, userID, show.ID, seasonNumber)
+}
 
-	_, err = db.Connection.Exec(query, userID, showID, seasonNumber)
-	if err != nil {
-		log.Println("Error adding season to user", err)
-		http.Error(w, "Error adding season to user", http.StatusInternalServerError)
-		return
+// selectEpisodes returns the single episode requested, or every released
+// episode up to and including the season
+func selectEpisodes(seasons []tvdbapi.Season, seasonNumber int, episodeNumber int) []tvdbapi.Episode {
+	episodes := []tvdbapi.Episode{}
+	for _, season := range seasons {
+		for _, e := range season.Episodes {
+			if episodeNumber > 0 {
+				if e.SeasonNumber == seasonNumber && e.Number == episodeNumber {
+					episodes = append(episodes, e)
+				}
+				continue
+			}
+
+			if e.SeasonNumber <= seasonNumber && isReleased(e.AirDate) {
+				episodes = append(episodes, e)
+			}
+		}
 	}
 
-	// redirect to show details page
-	http.Redirect(w, r, fmt.Sprintf("/show/details?id=%v", showID), http.StatusSeeOther)
+	return episodes
 }
@@ -0,0 +1,109 @@
+package routes
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"testing"
+
+	"github.com/jccroft1/goshowtrack/store"
+	"github.com/jccroft1/goshowtrack/tvdbapi"
+)
+
+func TestSelectEpisodes(t *testing.T) {
+	const (
+		aired  = "2020-01-01"
+		future = "2999-01-01"
+	)
+	seasons := []tvdbapi.Season{
+		season(1, aired, aired),
+		season(2, aired, "", aired),
+		season(3, aired, future),
+	}
+
+	tests := []struct {
+		name    string
+		season  int
+		episode int
+		want    []string
+	}{
+		{name: "first season", season: 1, want: []string{"S1E1", "S1E2"}},
+		{name: "includes earlier seasons", season: 2, want: []string{"S1E1", "S1E2", "S2E1", "S2E3"}},
+		{name: "skips unaired episodes", season: 3, want: []string{"S1E1", "S1E2", "S2E1", "S2E3", "S3E1"}},
+		{name: "unknown season", season: 9, want: []string{"S1E1", "S1E2", "S2E1", "S2E3", "S3E1"}},
+		{name: "single episode", season: 2, episode: 3, want: []string{"S2E3"}},
+		{name: "single unaired episode", season: 3, episode: 2, want: []string{"S3E2"}},
+		{name: "unknown episode", season: 2, episode: 9, want: []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := episodeNames(selectEpisodes(seasons, tt.season, tt.episode))
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("selectEpisodes(%d, %d) = %v, want %v", tt.season, tt.episode, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpdateWatchedMarksUpTo(t *testing.T) {
+	tests := []struct {
+		name    string
+		watched []store.EpisodeKey
+		season  int
+		episode int
+		want    []store.EpisodeKey
+	}{
+		{
+			name:   "season",
+			season: 2,
+			want:   []store.EpisodeKey{{Season: 1, Episode: 1}, {Season: 1, Episode: 2}, {Season: 2, Episode: 1}, {Season: 2, Episode: 2}},
+		},
+		{
+			name:   "unaired season",
+			season: 3,
+			want:   []store.EpisodeKey{{Season: 1, Episode: 1}, {Season: 1, Episode: 2}, {Season: 2, Episode: 1}, {Season: 2, Episode: 2}},
+		},
+		{
+			name:    "episode",
+			season:  2,
+			episode: 2,
+			want:    []store.EpisodeKey{{Season: 2, Episode: 2}},
+		},
+		{
+			name:    "keeps earlier progress",
+			watched: []store.EpisodeKey{{Season: 2, Episode: 2}},
+			season:  1,
+			want:    []store.EpisodeKey{{Season: 1, Episode: 1}, {Season: 1, Episode: 2}, {Season: 2, Episode: 2}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			show := testShow(1, "Watching", "Returning Series", 2, true)
+			fake := newFakeStore(t, show)
+			ctx := context.Background()
+			fake.SetWatched(ctx, 1, 1, tt.watched)
+
+			err := updateWatched(ctx, 1, show, tt.season, tt.episode, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			progress, _ := getWatchProgress(ctx, 1, 1)
+			got := []store.EpisodeKey{}
+			for key := range progress {
+				got = append(got, key)
+			}
+			sort.Slice(got, func(i, j int) bool {
+				if got[i].Season != got[j].Season {
+					return got[i].Season < got[j].Season
+				}
+				return got[i].Episode < got[j].Episode
+			})
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("watched %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
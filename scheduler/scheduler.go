@@ -0,0 +1,295 @@
+// Package scheduler runs background jobs on an interval, keeping when each
+// job last ran in the database so the timing survives restarts.
+package scheduler
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/jccroft1/goshowtrack/db"
+	"github.com/jccroft1/goshowtrack/metrics"
+)
+
+const (
+	// how often jobs are checked to see if they're due
+	pollInterval = time.Minute
+	// failed jobs are retried sooner than their interval
+	retryDelay = time.Hour
+)
+
+// Job is a task run in the background every Interval
+type Job struct {
+	Name     string
+	Interval time.Duration
+	// RunAtStartup runs the job when the app starts however recently it ran,
+	// for jobs that fill an in-memory cache
+	RunAtStartup bool
+	Run          func(ctx context.Context) error
+}
+
+// Status is a job's last run and when it will next run
+type Status struct {
+	Name         string
+	Interval     time.Duration
+	Running      bool
+	LastRun      time.Time
+	LastDuration time.Duration
+	LastError    string
+	NextRun      time.Time
+}
+
+var (
+	jobRuns = metrics.NewCounter("goshowtrack_job_runs_total",
+		"Background job runs by result, success, failure or cancelled.", "job", "result")
+	jobDuration = metrics.NewHistogram("goshowtrack_job_duration_seconds",
+		"Time taken by background jobs.", metrics.JobBuckets, "job")
+)
+
+var (
+	mu      sync.Mutex
+	jobs    []Job
+	running = map[string]bool{}
+	// jobs triggered manually are cancelled with the ones on the schedule
+	baseCtx = context.Background()
+	wg      sync.WaitGroup
+)
+
+// Register adds a job, it must be called before Start
+func Register(job Job) {
+	mu.Lock()
+	defer mu.Unlock()
+	jobs = append(jobs, job)
+}
+
+// Start runs jobs in the background as they become due until ctx is cancelled
+func Start(ctx context.Context) {
+	mu.Lock()
+	baseCtx = ctx
+	mu.Unlock()
+
+	for _, job := range registered() {
+		if job.RunAtStartup {
+			start(ctx, job)
+		}
+	}
+
+	go func() {
+		ticker := time.NewTicker(pollInterval)
+		defer ticker.Stop()
+
+		for {
+			runDue(ctx)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Wait blocks until running jobs have stopped, or ctx is done
+func Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("jobs still running: %v", ctx.Err())
+	}
+}
+
+// Run runs the named job now and waits for it to finish
+func Run(ctx context.Context, name string) error {
+	job, ok := find(name)
+	if !ok {
+		return fmt.Errorf("unknown job %q", name)
+	}
+	if !claim(job.Name) {
+		return fmt.Errorf("job %q is already running", name)
+	}
+	defer wg.Done()
+
+	return run(ctx, job)
+}
+
+// Trigger starts the named job now in the background
+func Trigger(name string) error {
+	job, ok := find(name)
+	if !ok {
+		return fmt.Errorf("unknown job %q", name)
+	}
+
+	mu.Lock()
+	ctx := baseCtx
+	mu.Unlock()
+
+	if !start(ctx, job) {
+		return fmt.Errorf("job %q is already running", name)
+	}
+	return nil
+}
+
+// List returns the status of every registered job
+func List() ([]Status, error) {
+	var statuses []Status
+	for _, job := range registered() {
+		status, err := loadStatus(job)
+		if err != nil {
+			return nil, err
+		}
+
+		mu.Lock()
+		status.Running = running[job.Name]
+		mu.Unlock()
+
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+func registered() []Job {
+	mu.Lock()
+	defer mu.Unlock()
+	return append([]Job(nil), jobs...)
+}
+
+func find(name string) (Job, bool) {
+	for _, job := range registered() {
+		if job.Name == name {
+			return job, true
+		}
+	}
+	return Job{}, false
+}
+
+func runDue(ctx context.Context) {
+	now := time.Now()
+	for _, job := range registered() {
+		status, err := loadStatus(job)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to load job", "job", job.Name, "err", err)
+			continue
+		}
+
+		// jobs that have never run are due straight away
+		if status.NextRun.After(now) {
+			continue
+		}
+		start(ctx, job)
+	}
+}
+
+// claim marks the job as running, returning false if it already is.
+// The caller must call wg.Done when the job finishes.
+func claim(name string) bool {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if running[name] {
+		return false
+	}
+	running[name] = true
+	wg.Add(1)
+	return true
+}
+
+// start runs the job in the background, unless it's already running
+func start(ctx context.Context, job Job) bool {
+	if !claim(job.Name) {
+		return false
+	}
+
+	go func() {
+		defer wg.Done()
+		run(ctx, job)
+	}()
+	return true
+}
+
+// run runs a claimed job and records the result
+func run(ctx context.Context, job Job) error {
+	defer func() {
+		mu.Lock()
+		delete(running, job.Name)
+		mu.Unlock()
+	}()
+
+	slog.InfoContext(ctx, "Running job", "job", job.Name)
+	started := time.Now()
+	err := job.Run(ctx)
+	duration := time.Since(started)
+
+	next := started.Add(job.Interval)
+	lastError := ""
+	result := "success"
+	switch {
+	case ctx.Err() != nil:
+		// interrupted by shutdown, run it again at the next start
+		next = started
+		lastError = "cancelled"
+		result = "cancelled"
+	case err != nil:
+		lastError = err.Error()
+		result = "failure"
+		if job.Interval > retryDelay {
+			next = started.Add(retryDelay)
+		}
+	}
+	jobRuns.Inc(job.Name, result)
+	jobDuration.Observe(duration.Seconds(), job.Name)
+	if err != nil {
+		slog.ErrorContext(ctx, "Job failed", "job", job.Name, "duration", duration.Round(time.Millisecond), "err", err)
+	} else {
+		slog.InfoContext(ctx, "Job complete", "job", job.Name, "duration", duration.Round(time.Millisecond))
+	}
+
+	_, dbErr := db.Connection.Exec(`INSERT INTO jobs (name, last_run_at, last_duration_ms, last_error, next_run_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(name) DO UPDATE SET
+			last_run_at = excluded.last_run_at,
+			last_duration_ms = excluded.last_duration_ms,
+			last_error = excluded.last_error,
+			next_run_at = excluded.next_run_at;`,
+		job.Name, started.UTC().Format(time.RFC3339), duration.Milliseconds(), lastError, next.UTC().Format(time.RFC3339))
+	if dbErr != nil {
+		slog.ErrorContext(ctx, "Failed to save job", "job", job.Name, "err", dbErr)
+	}
+
+	return err
+}
+
+func loadStatus(job Job) (Status, error) {
+	status := Status{
+		Name:     job.Name,
+		Interval: job.Interval,
+	}
+
+	var lastRun, nextRun, lastError sql.NullString
+	var durationMS sql.NullInt64
+	err := db.Connection.QueryRow(`SELECT last_run_at, last_duration_ms, last_error, next_run_at FROM jobs WHERE name = ?;`,
+		job.Name).Scan(&lastRun, &durationMS, &lastError, &nextRun)
+	if err == sql.ErrNoRows {
+		return status, nil
+	}
+	if err != nil {
+		return status, fmt.Errorf("failed to load job %s: %v", job.Name, err)
+	}
+
+	status.LastRun, _ = time.Parse(time.RFC3339, lastRun.String)
+	status.NextRun, _ = time.Parse(time.RFC3339, nextRun.String)
+	status.LastDuration = time.Duration(durationMS.Int64) * time.Millisecond
+	status.LastError = lastError.String
+
+	return status, nil
+}
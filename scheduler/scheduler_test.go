@@ -0,0 +1,112 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jccroft1/goshowtrack/db/dbtest"
+)
+
+// setupTest gives each test an empty jobs table and no registered jobs
+func setupTest(t *testing.T) {
+	t.Helper()
+
+	dbtest.Open(t)
+	jobs = nil
+	t.Cleanup(func() { jobs = nil })
+}
+
+func TestRunRecordsStatus(t *testing.T) {
+	setupTest(t)
+
+	runs := 0
+	Register(Job{Name: "ok", Interval: 50 * time.Hour, Run: func(ctx context.Context) error {
+		runs++
+		return nil
+	}})
+	Register(Job{Name: "failing", Interval: 50 * time.Hour, Run: func(ctx context.Context) error {
+		return errors.New("provider unavailable")
+	}})
+
+	statuses, err := List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !statuses[0].LastRun.IsZero() || !statuses[0].NextRun.IsZero() {
+		t.Errorf("new job status = %+v, want never run", statuses[0])
+	}
+
+	err = Run(context.Background(), "ok")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Run(context.Background(), "failing")
+	if err == nil {
+		t.Fatal("expected the failing job's error")
+	}
+	if runs != 1 {
+		t.Errorf("job ran %d times, want 1", runs)
+	}
+
+	statuses, err = List()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ok, failing := statuses[0], statuses[1]
+	if ok.LastError != "" || ok.NextRun.Sub(ok.LastRun) != 50*time.Hour {
+		t.Errorf("ok status = %+v, want next run after the interval", ok)
+	}
+	if failing.LastError != "provider unavailable" || failing.NextRun.Sub(failing.LastRun) != retryDelay {
+		t.Errorf("failing status = %+v, want a retry after %v", failing, retryDelay)
+	}
+
+	err = Run(context.Background(), "missing")
+	if err == nil {
+		t.Error("expected an error for an unknown job")
+	}
+}
+
+func TestStartRunsDueJobsAndStopsOnCancel(t *testing.T) {
+	setupTest(t)
+
+	started := make(chan struct{})
+	Register(Job{Name: "slow", Interval: time.Hour, Run: func(ctx context.Context) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	Start(ctx)
+
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("job that never ran wasn't started")
+	}
+
+	err := Trigger("slow")
+	if err == nil {
+		t.Error("expected an error triggering a running job")
+	}
+
+	cancel()
+	waitCtx, waitCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer waitCancel()
+	err = Wait(waitCtx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	statuses, err := List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// cancelled jobs run again straight away next time
+	if statuses[0].LastError != "cancelled" || statuses[0].NextRun.After(time.Now()) {
+		t.Errorf("status = %+v, want cancelled and due", statuses[0])
+	}
+}
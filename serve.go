@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/jccroft1/goshowtrack/auth"
+	"github.com/jccroft1/goshowtrack/config"
+	"github.com/jccroft1/goshowtrack/logging"
+	"github.com/jccroft1/goshowtrack/metrics"
+	"github.com/jccroft1/goshowtrack/posters"
+	"github.com/jccroft1/goshowtrack/routes"
+	"github.com/jccroft1/goshowtrack/scheduler"
+	"github.com/jccroft1/goshowtrack/tvdbapi"
+)
+
+// serveCommand runs the web server until it's stopped with SIGINT or SIGTERM
+//
+//	goshowtrack [serve]
+func serveCommand(cfg *config.Config, cache *posters.Cache) error {
+	err := auth.Setup(cfg.Auth)
+	if err != nil {
+		return fmt.Errorf("failed to setup auth: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	scheduler.Start(ctx)
+	go tvdbapi.RunRefresher(ctx)
+
+	// Setup server
+	mux := http.NewServeMux()
+
+	fs := http.FileServer(http.Dir("./assets/"))
+	mux.Handle("GET /assets/", http.StripPrefix("/assets/", fs))
+
+	// sign in with OIDC
+	mux.HandleFunc("GET /auth/login", logging.Middleware(auth.LoginHandler))
+	mux.HandleFunc("GET /auth/callback", logging.Middleware(auth.CallbackHandler))
+	mux.HandleFunc("POST /auth/logout", logging.Middleware(auth.Middleware(auth.LogoutHandler)))
+
+	// main pages
+	mux.HandleFunc("GET /", logging.Middleware(auth.Middleware(routes.HomeHandler)))
+	mux.HandleFunc("GET /about", logging.Middleware(auth.Middleware(routes.AboutHandler)))
+	mux.HandleFunc("GET /start", logging.Middleware(auth.Middleware(routes.StartHandler)))
+	mux.HandleFunc("GET /comingsoon", logging.Middleware(auth.Middleware(routes.ComingSoonHandler)))
+	mux.HandleFunc("GET /all", logging.Middleware(auth.Middleware(routes.AllHandler)))
+
+	// search pages
+	mux.HandleFunc("GET /search", logging.Middleware(auth.Middleware(routes.SearchHandler)))
+	mux.HandleFunc("POST /search", logging.Middleware(auth.Middleware(routes.SearchResultsHandler)))
+	mux.HandleFunc("POST /bulk_add", logging.Middleware(auth.Middleware(routes.BulkAddHandler)))
+	mux.HandleFunc("GET /import", logging.Middleware(auth.Middleware(routes.ImportHandler)))
+	mux.HandleFunc("POST /import", logging.Middleware(auth.Middleware(routes.ImportUploadHandler)))
+	mux.HandleFunc("GET /show/details", logging.Middleware(auth.Middleware(routes.ShowDetailsHandler)))
+	mux.HandleFunc("POST /show/refresh", logging.Middleware(auth.Middleware(routes.RefreshShowHandler)))
+	mux.HandleFunc("GET /posters/{id}", logging.Middleware(auth.Middleware(routes.PosterHandler(cache))))
+	mux.HandleFunc("GET /autofill", logging.Middleware(auth.Middleware(routes.AutofillHandler)))
+
+	// show actions
+	mux.HandleFunc("POST /show/add", logging.Middleware(auth.Middleware(routes.AddShowHandler)))
+	mux.HandleFunc("POST /show/remove", logging.Middleware(auth.Middleware(routes.RemoveShowHandler)))
+	mux.HandleFunc("POST /show/watched", logging.Middleware(auth.Middleware(routes.WatchedHandler)))
+	mux.HandleFunc("POST /show/unwatched", logging.Middleware(auth.Middleware(routes.UnwatchedHandler)))
+
+	// old links to the show actions ask for confirmation instead
+	mux.HandleFunc("GET /show/add", logging.Middleware(auth.Middleware(routes.ConfirmShowActionHandler)))
+	mux.HandleFunc("GET /show/remove", logging.Middleware(auth.Middleware(routes.ConfirmShowActionHandler)))
+	mux.HandleFunc("GET /show/watched", logging.Middleware(auth.Middleware(routes.ConfirmShowActionHandler)))
+	mux.HandleFunc("GET /show/unwatched", logging.Middleware(auth.Middleware(routes.ConfirmShowActionHandler)))
+
+	// user data
+	mux.HandleFunc("GET /export", logging.Middleware(auth.Middleware(routes.ExportHandler)))
+	mux.HandleFunc("POST /restore", logging.Middleware(auth.Middleware(routes.RestoreHandler)))
+
+	// calendar feed, authenticated by the token in the URL
+	mux.HandleFunc("GET /calendar/{token}", logging.Middleware(routes.CalendarHandler))
+	mux.HandleFunc("POST /calendar/token", logging.Middleware(auth.Middleware(routes.CalendarTokenHandler)))
+
+	mux.HandleFunc("POST /tokens", logging.Middleware(auth.Middleware(routes.CreateTokenHandler)))
+	mux.HandleFunc("POST /tokens/{id}/revoke", logging.Middleware(auth.Middleware(routes.RevokeTokenHandler)))
+
+	// admin
+	mux.HandleFunc("GET /admin/jobs", logging.Middleware(auth.AdminMiddleware(routes.AdminJobsHandler)))
+	mux.HandleFunc("POST /admin/jobs/{name}/run", logging.Middleware(auth.AdminMiddleware(routes.AdminRunJobHandler)))
+	mux.HandleFunc("GET /admin/users", logging.Middleware(auth.AdminMiddleware(routes.AdminUsersHandler)))
+	mux.HandleFunc("POST /admin/users/{id}", logging.Middleware(auth.AdminMiddleware(routes.AdminUpdateUserHandler)))
+
+	// JSON API
+	mux.HandleFunc("GET /api/v1/search", logging.Middleware(auth.Middleware(routes.APISearchHandler)))
+	mux.HandleFunc("GET /api/v1/shows/{id}", logging.Middleware(auth.Middleware(routes.APIShowHandler)))
+	mux.HandleFunc("GET /api/v1/shows/{id}/progress", logging.Middleware(auth.Middleware(routes.APIProgressHandler)))
+	mux.HandleFunc("PUT /api/v1/shows/{id}/seasons/{season}/watched", logging.Middleware(auth.Middleware(routes.APIWatchedHandler)))
+	mux.HandleFunc("DELETE /api/v1/shows/{id}/seasons/{season}/watched", logging.Middleware(auth.Middleware(routes.APIUnwatchedHandler)))
+	mux.HandleFunc("PUT /api/v1/shows/{id}/seasons/{season}/episodes/{episode}/watched", logging.Middleware(auth.Middleware(routes.APIWatchedHandler)))
+	mux.HandleFunc("DELETE /api/v1/shows/{id}/seasons/{season}/episodes/{episode}/watched", logging.Middleware(auth.Middleware(routes.APIUnwatchedHandler)))
+	mux.HandleFunc("GET /api/v1/list", logging.Middleware(auth.Middleware(routes.APIListHandler)))
+	mux.HandleFunc("PUT /api/v1/list/{id}", logging.Middleware(auth.Middleware(routes.APIAddShowHandler)))
+	mux.HandleFunc("DELETE /api/v1/list/{id}", logging.Middleware(auth.Middleware(routes.APIRemoveShowHandler)))
+
+	// probes for docker-compose and Kubernetes, not logged as they're so frequent
+	mux.HandleFunc("GET /healthz", routes.HealthzHandler)
+	mux.HandleFunc("GET /readyz", routes.ReadyzHandler)
+
+	// Prometheus, not logged as it's scraped so often
+	mux.HandleFunc("GET /metrics", metrics.Handler(cfg.Metrics.Token))
+
+	server := &http.Server{
+		Addr:    cfg.Server.Addr,
+		Handler: mux,
+	}
+
+	// Run server
+	serverErr := make(chan error, 1)
+	go func() {
+		slog.Info("Server running", "addr", cfg.Server.Addr)
+		serverErr <- server.ListenAndServe()
+	}()
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+
+	// Wait for signal
+	select {
+	case <-stop:
+	case err := <-serverErr:
+		return fmt.Errorf("server error: %v", err)
+	}
+	slog.Info("Shutting down server")
+
+	// Graceful shutdown
+	cancel()
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer shutdownCancel()
+
+	// Shutdown HTTP server
+	err = server.Shutdown(shutdownCtx)
+	if err != nil {
+		return fmt.Errorf("server shutdown failed: %v", err)
+	}
+
+	// Wait for background jobs to notice they've been cancelled
+	err = scheduler.Wait(shutdownCtx)
+	if err != nil {
+		slog.Warn("Background jobs didn't stop", "err", err)
+	}
+
+	slog.Info("Server shutdown complete")
+	return nil
+}
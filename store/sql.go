@@ -0,0 +1,297 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/jccroft1/goshowtrack/db"
+	"github.com/jccroft1/goshowtrack/tvdbapi"
+)
+
+// SQL is the Store kept in db.Connection
+type SQL struct{}
+
+func (SQL) ShowIDs(ctx context.Context, userID int64) ([]int, error) {
+	rows, err := db.Connection.QueryContext(ctx, `SELECT show_id FROM user_shows WHERE user_id = ? ORDER BY id;`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query user shows: %v", err)
+	}
+	defer rows.Close()
+
+	showIDs := []int{}
+	for rows.Next() {
+		var showID int
+		err := rows.Scan(&showID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan user show: %v", err)
+		}
+		showIDs = append(showIDs, showID)
+	}
+	return showIDs, rows.Err()
+}
+
+func (SQL) HasShow(ctx context.Context, userID int64, showID int) (bool, error) {
+	var id int64
+	err := db.Connection.QueryRowContext(ctx, `SELECT id FROM user_shows WHERE user_id = ? AND show_id = ?;`, userID, showID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to check user show: %v", err)
+	}
+	return true, nil
+}
+
+func (SQL) AddShow(ctx context.Context, userID int64, showID int) error {
+	_, err := db.Connection.ExecContext(ctx, `INSERT INTO user_shows (user_id, show_id) VALUES (?, ?) ON CONFLICT DO NOTHING;`, userID, showID)
+	if err != nil {
+		return fmt.Errorf("failed to add show to user: %v", err)
+	}
+	return nil
+}
+
+func (SQL) RemoveShow(ctx context.Context, userID int64, showID int) error {
+	_, err := db.Connection.ExecContext(ctx, `DELETE FROM user_shows WHERE user_id = ? AND show_id = ?;`, userID, showID)
+	if err != nil {
+		return fmt.Errorf("failed to remove show from user: %v", err)
+	}
+	return nil
+}
+
+func (SQL) Watched(ctx context.Context, userID int64, showID int) (map[EpisodeKey]bool, error) {
+	rows, err := db.Connection.QueryContext(ctx, `SELECT season_number, episode_number FROM user_episodes WHERE user_id = ? AND show_id = ?;`, userID, showID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query watched episodes: %v", err)
+	}
+	defer rows.Close()
+
+	watched := map[EpisodeKey]bool{}
+	for rows.Next() {
+		var key EpisodeKey
+		err := rows.Scan(&key.Season, &key.Episode)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan watched episode: %v", err)
+		}
+		watched[key] = true
+	}
+	return watched, rows.Err()
+}
+
+func (SQL) AllWatched(ctx context.Context, userID int64) (map[int]map[EpisodeKey]bool, error) {
+	rows, err := db.Connection.QueryContext(ctx, `SELECT show_id, season_number, episode_number FROM user_episodes WHERE user_id = ?;`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query watched episodes: %v", err)
+	}
+	defer rows.Close()
+
+	watched := map[int]map[EpisodeKey]bool{}
+	for rows.Next() {
+		var showID int
+		var key EpisodeKey
+		err := rows.Scan(&showID, &key.Season, &key.Episode)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan watched episode: %v", err)
+		}
+		if watched[showID] == nil {
+			watched[showID] = map[EpisodeKey]bool{}
+		}
+		watched[showID][key] = true
+	}
+	return watched, rows.Err()
+}
+
+func (SQL) SetWatched(ctx context.Context, userID int64, showID int, episodes []EpisodeKey) error {
+	tx, err := db.Connection.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, e := range episodes {
+		_, err = tx.ExecContext(ctx, `INSERT INTO user_episodes (user_id, show_id, season_number, episode_number) VALUES (?, ?, ?, ?) ON CONFLICT DO NOTHING;`,
+			userID, showID, e.Season, e.Episode)
+		if err != nil {
+			return fmt.Errorf("failed to mark episode watched: %v", err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (SQL) UnsetWatched(ctx context.Context, userID int64, showID int, episode EpisodeKey) error {
+	_, err := db.Connection.ExecContext(ctx, `DELETE FROM user_episodes WHERE user_id = ? AND show_id = ? AND season_number = ? AND episode_number = ?;`,
+		userID, showID, episode.Season, episode.Episode)
+	if err != nil {
+		return fmt.Errorf("failed to remove watched episodes: %v", err)
+	}
+	return nil
+}
+
+func (SQL) UnsetWatchedFrom(ctx context.Context, userID int64, showID int, seasonNumber int) error {
+	_, err := db.Connection.ExecContext(ctx, `DELETE FROM user_episodes WHERE user_id = ? AND show_id = ? AND season_number >= ?;`,
+		userID, showID, seasonNumber)
+	if err != nil {
+		return fmt.Errorf("failed to remove watched episodes: %v", err)
+	}
+	return nil
+}
+
+func (SQL) Show(ctx context.Context, showID int, refresh bool) (*tvdbapi.ShowDetail, error) {
+	return tvdbapi.GetShowDetails(ctx, showID, refresh)
+}
+
+// ListShows loads the cached shows on the list with two queries, one for the
+// shows and their seasons and one for their episodes, rather than three per
+// show. Shows that aren't cached yet, or are missing episodes, are fetched
+// from the provider.
+func (SQL) ListShows(ctx context.Context, userID int64) ([]*tvdbapi.ShowDetail, error) {
+	rows, err := db.Connection.QueryContext(ctx, `SELECT user_shows.show_id, shows.show_id, shows.name, shows.status, shows.air_date,
+			shows.description, shows.poster_path, shows.fetched_at,
+			seasons.season_number, seasons.name, seasons.episode_count, seasons.air_date, seasons.last_air_date, seasons.fetched_at
+		FROM user_shows
+		LEFT JOIN shows ON shows.show_id = user_shows.show_id
+		LEFT JOIN seasons ON seasons.show_id = shows.show_id
+		WHERE user_shows.user_id = ?
+		ORDER BY user_shows.id, seasons.season_number;`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query user shows: %v", err)
+	}
+	defer rows.Close()
+
+	// order is the list's IDs in order, cached is nil for shows not saved yet
+	var order []int
+	cached := map[int]*tvdbapi.ShowDetail{}
+	for rows.Next() {
+		var listID int
+		var showID, seasonNumber, episodeCount sql.NullInt64
+		var name, status, airDate, description, posterPath, fetchedAt sql.NullString
+		var seasonName, seasonAirDate, seasonLastAirDate, seasonFetchedAt sql.NullString
+		err := rows.Scan(&listID, &showID, &name, &status, &airDate, &description, &posterPath, &fetchedAt,
+			&seasonNumber, &seasonName, &episodeCount, &seasonAirDate, &seasonLastAirDate, &seasonFetchedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan user show: %v", err)
+		}
+
+		show, seen := cached[listID]
+		if !seen {
+			order = append(order, listID)
+			if showID.Valid {
+				show = &tvdbapi.ShowDetail{
+					ID:          listID,
+					Name:        name.String,
+					Status:      status.String,
+					AirDate:     airDate.String,
+					Description: description.String,
+					PosterPath:  posterPath.String,
+					Seasons:     []tvdbapi.Season{},
+				}
+				// shows saved before fetched_at was added are left as zero, so they're stale
+				show.FetchedAt, _ = time.Parse(time.RFC3339, fetchedAt.String)
+			}
+			cached[listID] = show
+		}
+		if show == nil || !seasonNumber.Valid {
+			continue
+		}
+
+		season := tvdbapi.Season{
+			Number:       int(seasonNumber.Int64),
+			Name:         seasonName.String,
+			EpisodeCount: int(episodeCount.Int64),
+			AirDate:      seasonAirDate.String,
+			LastAirDate:  seasonLastAirDate.String,
+		}
+		season.FetchedAt, _ = time.Parse(time.RFC3339, seasonFetchedAt.String)
+		if season.FetchedAt.Before(show.FetchedAt) {
+			show.FetchedAt = season.FetchedAt
+		}
+		show.Seasons = append(show.Seasons, season)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read user shows: %v", err)
+	}
+	rows.Close()
+
+	rows, err = db.Connection.QueryContext(ctx, `SELECT episodes.show_id, episodes.season_number, episodes.episode_number, episodes.name, episodes.air_date
+		FROM user_shows
+		JOIN episodes ON episodes.show_id = user_shows.show_id
+		WHERE user_shows.user_id = ?
+		ORDER BY episodes.show_id, episodes.season_number, episodes.episode_number;`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query episodes: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var showID int
+		var episode tvdbapi.Episode
+		var name, airDate sql.NullString
+		err := rows.Scan(&showID, &episode.SeasonNumber, &episode.Number, &name, &airDate)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan episode: %v", err)
+		}
+		episode.Name, episode.AirDate = name.String, airDate.String
+
+		show := cached[showID]
+		if show == nil {
+			continue
+		}
+		for i := range show.Seasons {
+			if show.Seasons[i].Number == episode.SeasonNumber {
+				show.Seasons[i].Episodes = append(show.Seasons[i].Episodes, episode)
+				break
+			}
+		}
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read episodes: %v", err)
+	}
+	rows.Close()
+
+	shows := []*tvdbapi.ShowDetail{}
+	for _, showID := range order {
+		show, err := tvdbapi.CachedShowDetails(ctx, showID, cached[showID])
+		if err != nil {
+			slog.ErrorContext(ctx, "Error getting show details", "show", showID, "err", err)
+			continue
+		}
+		shows = append(shows, show)
+	}
+	return shows, nil
+}
+
+func (SQL) CalendarUser(ctx context.Context, token string) (int64, bool, error) {
+	var userID int64
+	// disabled users' feeds stop with the rest of their access, the column is
+	// a BOOLEAN on Postgres so it's compared with a bool, not 0
+	err := db.Connection.QueryRowContext(ctx, `SELECT id FROM users WHERE calendar_token = ? AND disabled = ?;`, token, false).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to find calendar token: %v", err)
+	}
+	return userID, true, nil
+}
+
+func (SQL) CalendarToken(ctx context.Context, userID int64) (string, error) {
+	var token sql.NullString
+	err := db.Connection.QueryRowContext(ctx, `SELECT calendar_token FROM users WHERE id = ?;`, userID).Scan(&token)
+	if err != nil && err != sql.ErrNoRows {
+		return "", fmt.Errorf("failed to get calendar token: %v", err)
+	}
+	return token.String, nil
+}
+
+func (SQL) SetCalendarToken(ctx context.Context, userID int64, token string) error {
+	_, err := db.Connection.ExecContext(ctx, `UPDATE users SET calendar_token = ? WHERE id = ?;`, token, userID)
+	if err != nil {
+		return fmt.Errorf("failed to save calendar token: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,213 @@
+package store
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jccroft1/goshowtrack/db"
+	"github.com/jccroft1/goshowtrack/db/dbtest"
+)
+
+func TestUserShows(t *testing.T) {
+	dbtest.Open(t)
+	ctx := context.Background()
+	s := SQL{}
+
+	for _, showID := range []int{20, 10, 20} {
+		err := s.AddShow(ctx, 1, showID)
+		if err != nil {
+			t.Fatalf("AddShow(%d) error = %v", showID, err)
+		}
+	}
+	err := s.AddShow(ctx, 2, 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	showIDs, err := s.ShowIDs(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{20, 10}; !reflect.DeepEqual(showIDs, want) {
+		t.Errorf("ShowIDs() = %v, want %v in the order they were added", showIDs, want)
+	}
+
+	err = s.RemoveShow(ctx, 1, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for showID, want := range map[int]bool{10: true, 20: false, 30: false} {
+		added, err := s.HasShow(ctx, 1, showID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if added != want {
+			t.Errorf("HasShow(%d) = %v, want %v", showID, added, want)
+		}
+	}
+}
+
+func TestProgress(t *testing.T) {
+	dbtest.Open(t)
+	ctx := context.Background()
+	s := SQL{}
+
+	err := s.SetWatched(ctx, 1, 10, []EpisodeKey{{1, 1}, {1, 2}, {2, 1}, {2, 2}, {3, 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// already watched episodes are ignored
+	err = s.SetWatched(ctx, 1, 10, []EpisodeKey{{1, 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.SetWatched(ctx, 1, 20, []EpisodeKey{{1, 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.SetWatched(ctx, 2, 10, []EpisodeKey{{1, 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.UnsetWatched(ctx, 1, 10, EpisodeKey{1, 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.UnsetWatchedFrom(ctx, 1, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	watched, err := s.Watched(ctx, 1, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[EpisodeKey]bool{{1, 1}: true}; !reflect.DeepEqual(watched, want) {
+		t.Errorf("Watched() = %v, want %v", watched, want)
+	}
+
+	all, err := s.AllWatched(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[int]map[EpisodeKey]bool{
+		10: {{1, 1}: true},
+		20: {{1, 1}: true},
+	}
+	if !reflect.DeepEqual(all, want) {
+		t.Errorf("AllWatched() = %v, want %v", all, want)
+	}
+}
+
+func TestListShows(t *testing.T) {
+	dbtest.Open(t)
+	ctx := context.Background()
+	fetchedAt := time.Now().UTC().Truncate(time.Second)
+	seasonFetchedAt := fetchedAt.Add(-time.Hour)
+
+	_, err := db.Connection.Exec(`INSERT INTO shows (show_id, name, status, air_date, description, poster_path, fetched_at)
+		VALUES (10, 'Severance', 'Returning Series', '2022-02-18', 'Office', '/s.jpg', ?), (20, 'Andor', 'Ended', '2022-09-21', 'Rebels', '/a.jpg', ?);`,
+		fetchedAt.Format(time.RFC3339), fetchedAt.Format(time.RFC3339))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Connection.Exec(`INSERT INTO seasons (show_id, name, season_number, episode_count, air_date, last_air_date, fetched_at)
+		VALUES (10, 'Season 2', 2, 1, '2025-01-17', '2025-03-21', ?), (10, 'Season 1', 1, 2, '2022-02-18', '2022-04-08', ?), (20, 'Season 1', 1, 1, '2022-09-21', '2022-11-23', ?);`,
+		fetchedAt.Format(time.RFC3339), seasonFetchedAt.Format(time.RFC3339), fetchedAt.Format(time.RFC3339))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Connection.Exec(`INSERT INTO episodes (show_id, season_number, episode_number, name, air_date)
+		VALUES (10, 1, 2, 'Half Loop', '2022-02-18'), (10, 1, 1, 'Good News About Hell', '2022-02-18'), (10, 2, 1, 'Hello, Ms. Cobel', '2025-01-17'), (20, 1, 1, 'Kassa', '2022-09-21');`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := SQL{}
+	for _, showID := range []int{20, 10} {
+		err = s.AddShow(ctx, 1, showID)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = s.AddShow(ctx, 2, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	shows, err := s.ListShows(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(shows) != 2 || shows[0].ID != 20 || shows[1].ID != 10 {
+		t.Fatalf("ListShows() = %+v, want Andor then Severance", shows)
+	}
+
+	severance := shows[1]
+	if severance.Name != "Severance" || severance.PosterPath != "/s.jpg" {
+		t.Errorf("show = %+v, want Severance's details", severance)
+	}
+	if !severance.FetchedAt.Equal(seasonFetchedAt) {
+		t.Errorf("FetchedAt = %v, want the oldest season's %v", severance.FetchedAt, seasonFetchedAt)
+	}
+	if len(severance.Seasons) != 2 || severance.Seasons[0].Number != 1 || severance.Seasons[1].Number != 2 {
+		t.Fatalf("seasons = %+v, want 1 and 2 in order", severance.Seasons)
+	}
+	var names []string
+	for _, e := range severance.Seasons[0].Episodes {
+		names = append(names, e.Name)
+	}
+	if want := []string{"Good News About Hell", "Half Loop"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("season 1 episodes = %v, want %v", names, want)
+	}
+	if len(shows[0].Seasons) != 1 || len(shows[0].Seasons[0].Episodes) != 1 {
+		t.Errorf("Andor seasons = %+v, want its one episode", shows[0].Seasons)
+	}
+}
+
+func TestCalendarTokens(t *testing.T) {
+	dbtest.Open(t)
+	ctx := context.Background()
+	s := SQL{}
+
+	_, err := db.Connection.Exec(`INSERT INTO users (email) VALUES ('jane@example.com');`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := s.CalendarToken(ctx, 1)
+	if err != nil || token != "" {
+		t.Fatalf("CalendarToken() = %q, %v, want none yet", token, err)
+	}
+
+	err = s.SetCalendarToken(ctx, 1, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err = s.CalendarToken(ctx, 1)
+	if err != nil || token != "secret" {
+		t.Errorf("CalendarToken() = %q, %v, want secret", token, err)
+	}
+
+	userID, ok, err := s.CalendarUser(ctx, "secret")
+	if err != nil || !ok || userID != 1 {
+		t.Errorf("CalendarUser(secret) = %d, %v, %v, want user 1", userID, ok, err)
+	}
+	_, ok, err = s.CalendarUser(ctx, "guess")
+	if err != nil || ok {
+		t.Errorf("CalendarUser(guess) = %v, %v, want no user", ok, err)
+	}
+
+	// the feed stops when the user is disabled
+	_, err = db.Connection.Exec(`UPDATE users SET disabled = ? WHERE id = 1;`, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, ok, err = s.CalendarUser(ctx, "secret")
+	if err != nil || ok {
+		t.Errorf("CalendarUser(secret) of a disabled user = %v, %v, want no user", ok, err)
+	}
+}
@@ -0,0 +1,71 @@
+// Package store is where handlers load and save users' lists, their watch
+// progress and the shows cached from the provider. Handlers use the Store
+// interface rather than SQL, so they can be tested without a database.
+package store
+
+import (
+	"context"
+
+	"github.com/jccroft1/goshowtrack/tvdbapi"
+)
+
+// EpisodeKey identifies an episode within a show
+type EpisodeKey struct {
+	Season  int `json:"season"`
+	Episode int `json:"episode"`
+}
+
+// UserShows are the shows each user has added to their list
+type UserShows interface {
+	// ShowIDs returns the IDs of every show the user has added
+	ShowIDs(ctx context.Context, userID int64) ([]int, error)
+	// HasShow reports whether the user has added the show
+	HasShow(ctx context.Context, userID int64, showID int) (bool, error)
+	// AddShow adds the show to the user's list, it's fine if it's already there
+	AddShow(ctx context.Context, userID int64, showID int) error
+	// RemoveShow removes the show from the user's list, their progress is kept
+	RemoveShow(ctx context.Context, userID int64, showID int) error
+}
+
+// Progress is the episodes each user has watched
+type Progress interface {
+	// Watched returns the episodes of the show the user has watched
+	Watched(ctx context.Context, userID int64, showID int) (map[EpisodeKey]bool, error)
+	// AllWatched returns the episodes the user has watched of every show, by show ID
+	AllWatched(ctx context.Context, userID int64) (map[int]map[EpisodeKey]bool, error)
+	// SetWatched marks the episodes watched, ignoring any already watched
+	SetWatched(ctx context.Context, userID int64, showID int, episodes []EpisodeKey) error
+	// UnsetWatched marks a single episode unwatched
+	UnsetWatched(ctx context.Context, userID int64, showID int, episode EpisodeKey) error
+	// UnsetWatchedFrom marks every episode of the season, and the seasons after it, unwatched
+	UnsetWatchedFrom(ctx context.Context, userID int64, showID int, seasonNumber int) error
+}
+
+// ShowCache serves shows with their seasons and episodes from the database,
+// fetching them from the provider when they're missing
+type ShowCache interface {
+	// Show returns the show, from the provider if refresh is set
+	Show(ctx context.Context, showID int, refresh bool) (*tvdbapi.ShowDetail, error)
+	// ListShows returns every show on the user's list. Shows that fail to
+	// load are logged and left out, so one bad show doesn't break the list.
+	ListShows(ctx context.Context, userID int64) ([]*tvdbapi.ShowDetail, error)
+}
+
+// CalendarTokens are the secret tokens in users' calendar feed URLs
+type CalendarTokens interface {
+	// CalendarUser returns the user with the token, ok is false if there isn't
+	// one or they've been disabled
+	CalendarUser(ctx context.Context, token string) (userID int64, ok bool, err error)
+	// CalendarToken returns the user's token, or "" if they haven't created one
+	CalendarToken(ctx context.Context, userID int64) (string, error)
+	// SetCalendarToken replaces the user's token
+	SetCalendarToken(ctx context.Context, userID int64, token string) error
+}
+
+// Store is everything the handlers need
+type Store interface {
+	UserShows
+	Progress
+	ShowCache
+	CalendarTokens
+}
@@ -0,0 +1,47 @@
+package tvdbapi
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// The provider's health is taken from the requests the app already makes,
+// so health checks don't use up the rate limit
+
+var (
+	healthMu sync.Mutex
+	// error from the last request, nil if it worked
+	providerErr       error
+	providerCheckedAt time.Time
+)
+
+// recordProviderResult keeps the outcome of a request to the provider.
+// Shows that don't exist and cancelled requests say nothing about its health.
+func recordProviderResult(err error) {
+	if errors.Is(err, ErrNotFound) || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return
+	}
+
+	healthMu.Lock()
+	defer healthMu.Unlock()
+	providerErr = err
+	providerCheckedAt = time.Now()
+}
+
+// ProviderHealth returns when the provider was last used and the error if
+// that request failed. The time is zero if it hasn't been used yet.
+func ProviderHealth() (time.Time, error) {
+	healthMu.Lock()
+	defer healthMu.Unlock()
+	return providerCheckedAt, providerErr
+}
+
+// PopularShowsStatus returns how many popular shows are loaded for autofill,
+// and false until the first load has finished
+func PopularShowsStatus() (int, bool) {
+	popularShowsMu.RLock()
+	defer popularShowsMu.RUnlock()
+	return len(popularShows), popularShowsTried
+}
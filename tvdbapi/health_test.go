@@ -0,0 +1,40 @@
+package tvdbapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRecordProviderResult(t *testing.T) {
+	t.Cleanup(func() {
+		providerErr = nil
+		providerCheckedAt = time.Time{}
+	})
+
+	checkedAt, err := ProviderHealth()
+	if !checkedAt.IsZero() || err != nil {
+		t.Fatalf("ProviderHealth() = %v, %v before any request", checkedAt, err)
+	}
+
+	down := errors.New("connection refused")
+	recordProviderResult(down)
+	checkedAt, err = ProviderHealth()
+	if checkedAt.IsZero() || err != down {
+		t.Fatalf("ProviderHealth() = %v, %v, want the failed request", checkedAt, err)
+	}
+
+	// neither of these say the provider is back up
+	recordProviderResult(fmt.Errorf("show 1: %w", ErrNotFound))
+	recordProviderResult(context.Canceled)
+	if _, err = ProviderHealth(); err != down {
+		t.Errorf("ProviderHealth() error = %v, want %v", err, down)
+	}
+
+	recordProviderResult(nil)
+	if _, err = ProviderHealth(); err != nil {
+		t.Errorf("ProviderHealth() error = %v after a request worked", err)
+	}
+}
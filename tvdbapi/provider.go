@@ -0,0 +1,101 @@
+package tvdbapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Errors returned by providers, check for them with errors.Is
+var (
+	ErrNotFound     = errors.New("not found")
+	ErrUnauthorized = errors.New("unauthorized, check the provider token")
+	ErrRateLimited  = errors.New("rate limited")
+	ErrUnavailable  = errors.New("provider unavailable")
+)
+
+// APIError is a failed request to a provider's API
+type APIError struct {
+	Path string
+	// StatusCode is 0 if no response was received
+	StatusCode int
+	// RetryAfter is how long the API asked us to wait before trying again
+	RetryAfter time.Duration
+	// Kind is one of the Err values above, or nil for other failures
+	Kind error
+	// Err is the transport error when no response was received
+	Err error
+}
+
+func (e *APIError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("request to %s failed: %v", e.Path, e.Err)
+	}
+	return fmt.Sprintf("request to %s failed: %d %s", e.Path, e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+func (e *APIError) Unwrap() error {
+	return e.Kind
+}
+
+// Provider is a catalog of TV show metadata. Implementations only talk to
+// their upstream API, caching in the DB is handled by this package. Failed
+// requests return an *APIError.
+type Provider interface {
+	// SearchShows returns shows matching the query, best match first.
+	SearchShows(ctx context.Context, query string) ([]Show, error)
+	// ShowDetails returns a show with its list of seasons.
+	ShowDetails(ctx context.Context, id int) (*ShowDetail, error)
+	// SeasonDetails returns the episodes of a single season.
+	SeasonDetails(ctx context.Context, showID int, seasonNumber int) (*SeasonDetails, error)
+	// PopularShows returns a page of shows ordered by popularity, pages start at 1.
+	// An empty page means there are no more results.
+	PopularShows(ctx context.Context, page int) ([]Show, error)
+}
+
+// ExternalIDFinder is optionally implemented by providers that can look up a
+// show by another catalog's ID, used to match imported watch history.
+type ExternalIDFinder interface {
+	// FindByExternalID returns the show with the ID in source, one of "tmdb",
+	// "tvdb" or "imdb". It returns nil if the show isn't found.
+	FindByExternalID(ctx context.Context, source string, id string) (*Show, error)
+}
+
+// SeasonBatcher is optionally implemented by providers that can fetch seasons
+// in the same request as the show, saving a request per season.
+type SeasonBatcher interface {
+	// ShowDetailsWithSeasons returns the show and the episodes of the seasons
+	// in seasonNumbers, keyed by season number. At most maxBatchedSeasons are
+	// requested at once, seasons missing from the map are fetched separately.
+	ShowDetailsWithSeasons(ctx context.Context, id int, seasonNumbers []int) (*ShowDetail, map[int]*SeasonDetails, error)
+}
+
+// providers maps the name used in config to a constructor
+var providers = map[string]func(token string, requestInterval time.Duration) Provider{
+	"tmdb": NewTMDB,
+}
+
+// NewProvider creates the provider registered under name, defaulting to TMDB,
+// that waits at least requestInterval between requests
+func NewProvider(name string, token string, requestInterval time.Duration) (Provider, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" {
+		name = "tmdb"
+	}
+
+	newProvider, ok := providers[name]
+	if !ok {
+		names := make([]string, 0, len(providers))
+		for n := range providers {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown provider %q, expected one of %v", name, names)
+	}
+
+	return newProvider(token, requestInterval), nil
+}
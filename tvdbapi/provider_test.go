@@ -0,0 +1,82 @@
+package tvdbapi
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// fakeProvider serves a fixed catalog without any network access
+type fakeProvider struct {
+	popular [][]Show
+}
+
+func (f *fakeProvider) SearchShows(ctx context.Context, query string) ([]Show, error) {
+	return nil, nil
+}
+
+func (f *fakeProvider) ShowDetails(ctx context.Context, id int) (*ShowDetail, error) {
+	return &ShowDetail{ID: id}, nil
+}
+
+func (f *fakeProvider) SeasonDetails(ctx context.Context, showID int, seasonNumber int) (*SeasonDetails, error) {
+	return &SeasonDetails{}, nil
+}
+
+func (f *fakeProvider) PopularShows(ctx context.Context, page int) ([]Show, error) {
+	if page > len(f.popular) {
+		return nil, nil
+	}
+	return f.popular[page-1], nil
+}
+
+func TestNewProvider(t *testing.T) {
+	tests := []struct {
+		name    string
+		wantErr bool
+	}{
+		{"", false},
+		{"tmdb", false},
+		{" TMDB ", false},
+		{"imdb", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := NewProvider(tt.name, "token", time.Second)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewProvider(%q) error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			}
+			if !tt.wantErr && p == nil {
+				t.Fatalf("NewProvider(%q) returned nil provider", tt.name)
+			}
+		})
+	}
+}
+
+func TestLoadPopularShows(t *testing.T) {
+	provider = &fakeProvider{
+		popular: [][]Show{
+			{{Name: "Breaking Bad"}, {Name: "Better Call Saul"}},
+			{{Name: "Breaking Bad: The Movie"}, {Name: "Severance"}},
+		},
+	}
+	t.Cleanup(func() { provider = nil })
+
+	err := LoadPopularShows(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	popularShowsMu.RLock()
+	count := len(popularShows)
+	popularShowsMu.RUnlock()
+	if count != 3 {
+		t.Fatalf("loaded %d popular shows, want 3", count)
+	}
+
+	found := FindPopularShows("breaking")
+	if len(found) == 0 || found[0] != "Breaking Bad" {
+		t.Errorf("FindPopularShows(%q) = %v, want Breaking Bad first", "breaking", found)
+	}
+}
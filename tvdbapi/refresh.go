@@ -0,0 +1,134 @@
+package tvdbapi
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/jccroft1/goshowtrack/db"
+)
+
+const (
+	// airingTTL is how long shows that are still airing, or might be, stay fresh
+	airingTTL = 24 * time.Hour
+	// finishedTTL is how long ended and cancelled shows stay fresh, they
+	// rarely change but metadata does get corrected
+	finishedTTL = 30 * 24 * time.Hour
+)
+
+var (
+	// shows served stale, waiting for RunRefresher
+	refreshQueue = make(chan int, 100)
+	queuedMu     sync.Mutex
+	queued       = map[int]bool{}
+)
+
+// TTL is how long a show with status is cached before it's refreshed
+func TTL(status string) time.Duration {
+	if IsFinished(status) {
+		return finishedTTL
+	}
+	return airingTTL
+}
+
+// IsStale reports whether the cached show is older than its TTL
+func IsStale(show *ShowDetail) bool {
+	return time.Since(show.FetchedAt) > TTL(show.Status)
+}
+
+// queueRefresh asks RunRefresher to refresh the show, unless it's already queued.
+// If the queue is full the refresh-shows job picks the show up instead.
+func queueRefresh(id int) {
+	queuedMu.Lock()
+	defer queuedMu.Unlock()
+
+	if queued[id] {
+		return
+	}
+	select {
+	case refreshQueue <- id:
+		queued[id] = true
+	default:
+	}
+}
+
+// RunRefresher refreshes stale shows queued by GetShowDetails, one at a time,
+// until ctx is cancelled
+func RunRefresher(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case id := <-refreshQueue:
+			_, err := GetShowDetails(ctx, id, true)
+			if err != nil {
+				slog.ErrorContext(ctx, "Failed to refresh show", "show", id, "err", err)
+			}
+
+			queuedMu.Lock()
+			delete(queued, id)
+			queuedMu.Unlock()
+		}
+	}
+}
+
+// RefreshShows refetches every cached show older than its TTL
+func RefreshShows(ctx context.Context) error {
+	return refreshShows(ctx, false)
+}
+
+// RefreshAllShows refetches every cached show, fresh or not
+func RefreshAllShows(ctx context.Context) error {
+	return refreshShows(ctx, true)
+}
+
+func refreshShows(ctx context.Context, all bool) error {
+	slog.InfoContext(ctx, "Refreshing shows", "all", all)
+
+	rows, err := db.Connection.Query("SELECT show_id, status, fetched_at FROM shows")
+	if err != nil {
+		return fmt.Errorf("failed to load show ids: %v", err)
+	}
+
+	var ids []int
+	for rows.Next() {
+		var show ShowDetail
+		var fetchedAt sql.NullString
+		err := rows.Scan(&show.ID, &show.Status, &fetchedAt)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to scan show id", "err", err)
+			continue
+		}
+		show.FetchedAt, _ = time.Parse(time.RFC3339, fetchedAt.String)
+
+		if !all && !IsStale(&show) {
+			continue
+		}
+
+		ids = append(ids, show.ID)
+	}
+	rows.Close()
+
+	failed := 0
+	for _, id := range ids {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		_, err = GetShowDetails(ctx, id, true)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to refresh show", "show", id, "err", err)
+			failed++
+			continue
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to refresh %d of %d shows", failed, len(ids))
+	}
+
+	slog.InfoContext(ctx, "Refresh complete", "shows", len(ids))
+	return nil
+}
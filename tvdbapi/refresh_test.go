@@ -0,0 +1,91 @@
+package tvdbapi
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jccroft1/goshowtrack/db"
+	"github.com/jccroft1/goshowtrack/db/dbtest"
+)
+
+// setupCacheDB gives the test empty show cache tables
+func setupCacheDB(t *testing.T) {
+	t.Helper()
+
+	dbtest.Open(t)
+}
+
+func TestIsStale(t *testing.T) {
+	tests := []struct {
+		status string
+		age    time.Duration
+		want   bool
+	}{
+		{"Returning Series", time.Hour, false},
+		{"Returning Series", 2 * 24 * time.Hour, true},
+		{"Ended", 2 * 24 * time.Hour, false},
+		{"Canceled", 60 * 24 * time.Hour, true},
+	}
+
+	for _, tt := range tests {
+		show := &ShowDetail{Status: tt.status, FetchedAt: time.Now().Add(-tt.age)}
+		if got := IsStale(show); got != tt.want {
+			t.Errorf("IsStale(%s, %v old) = %v, want %v", tt.status, tt.age, got, tt.want)
+		}
+	}
+
+	// shows cached before fetched_at was recorded are always stale
+	if !IsStale(&ShowDetail{Status: "Ended"}) {
+		t.Error("show without a fetched time isn't stale")
+	}
+}
+
+func TestStaleShowServedThenRefreshed(t *testing.T) {
+	setupCacheDB(t)
+	provider = &fakeProvider{}
+	t.Cleanup(func() { provider = nil })
+
+	stale := time.Now().Add(-2 * airingTTL).UTC().Format(time.RFC3339)
+	_, err := db.Connection.Exec(`INSERT INTO shows (show_id, name, status, air_date, description, poster_path, fetched_at)
+		VALUES (1, 'Cached', 'Returning Series', '2020-01-01', '', '', ?);`, stale)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	show, err := GetShowDetails(context.Background(), 1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if show.Name != "Cached" {
+		t.Errorf("show = %+v, want the cached show served straight away", show)
+	}
+
+	// asking again doesn't queue it twice
+	_, err = GetShowDetails(context.Background(), 1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(refreshQueue) != 1 {
+		t.Fatalf("%d refreshes queued, want 1", len(refreshQueue))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go RunRefresher(ctx)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		cached, err := loadCachedShow(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !IsStale(cached) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("stale show wasn't refreshed in the background")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,400 @@
+package tvdbapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jccroft1/goshowtrack/metrics"
+)
+
+const (
+	// maxAttempts is how many times a request is tried before giving up
+	maxAttempts = 4
+	// requestTimeout limits a single attempt, callers limit the whole request with their context
+	requestTimeout = 10 * time.Second
+	// maxRetryDelay is the longest we'll wait to retry, longer Retry-After values fail instead
+	maxRetryDelay = 30 * time.Second
+)
+
+// retryBackoff is the delay before the first retry
+var retryBackoff = 500 * time.Millisecond
+
+var (
+	tmdbRequests = metrics.NewCounter("goshowtrack_tmdb_requests_total",
+		"Requests made to TMDB, including retries, by result.", "result")
+	tmdbDuration = metrics.NewHistogram("goshowtrack_tmdb_request_duration_seconds",
+		"Time taken by requests to TMDB.", metrics.DefaultBuckets)
+	tmdbRateLimitWait = metrics.NewHistogram("goshowtrack_tmdb_rate_limit_wait_seconds",
+		"Time requests to TMDB waited for the rate limiter.", metrics.DefaultBuckets)
+)
+
+const (
+	baseUrl      string = "https://api.themoviedb.org/3/"
+	imageURL     string = "https://media.themoviedb.org/t/p/"
+	baseImageURL string = imageURL + "w300_and_h450_bestv2"
+)
+
+// posterSizes maps PosterSizes to TMDB image sizes
+// https://developer.themoviedb.org/docs/image-basics
+var posterSizes = map[string]string{
+	"small": "w185",
+	"large": "w342",
+}
+
+// TMDB fetches metadata from The Movie Database
+// https://developer.themoviedb.org/reference/intro/getting-started
+type TMDB struct {
+	token   string
+	baseURL string
+	client  *http.Client
+	limiter <-chan time.Time
+}
+
+func NewTMDB(token string, requestInterval time.Duration) Provider {
+	return &TMDB{
+		token:   token,
+		baseURL: baseUrl,
+		client: &http.Client{
+			Timeout: requestTimeout,
+		},
+		limiter: time.Tick(requestInterval),
+	}
+}
+
+type searchShowsResponse struct {
+	Results []Show `json:"results"`
+}
+
+func (t *TMDB) SearchShows(ctx context.Context, query string) ([]Show, error) {
+	escapedQuery := url.QueryEscape(query)
+
+	url := fmt.Sprintf("search/tv?query=%v&include_adult=false&language=en-US&page=1", escapedQuery)
+	var results searchShowsResponse
+	err := t.getRequest(ctx, url, &results)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, show := range results.Results {
+		show.PosterPath = fmt.Sprintf("%s%s", baseImageURL, show.PosterPath)
+		results.Results[i] = show
+	}
+
+	return results.Results, nil
+}
+
+// https://developer.themoviedb.org/reference/tv-series-details
+func (t *TMDB) ShowDetails(ctx context.Context, id int) (*ShowDetail, error) {
+	var response ShowDetail
+	err := t.getRequest(ctx, "tv/"+strconv.Itoa(id), &response)
+	if err != nil {
+		return nil, err
+	}
+	response.PosterPath = fmt.Sprintf("%s%s", baseImageURL, response.PosterPath)
+
+	return &response, nil
+}
+
+// ShowDetailsWithSeasons appends the seasons to the series request
+// https://developer.themoviedb.org/docs/append-to-response
+func (t *TMDB) ShowDetailsWithSeasons(ctx context.Context, id int, seasonNumbers []int) (*ShowDetail, map[int]*SeasonDetails, error) {
+	if len(seasonNumbers) > maxBatchedSeasons {
+		seasonNumbers = seasonNumbers[:maxBatchedSeasons]
+	}
+
+	path := "tv/" + strconv.Itoa(id)
+	if len(seasonNumbers) > 0 {
+		appends := make([]string, len(seasonNumbers))
+		for i, number := range seasonNumbers {
+			appends[i] = fmt.Sprintf("season/%d", number)
+		}
+		path += "?append_to_response=" + strings.Join(appends, ",")
+	}
+
+	var body json.RawMessage
+	err := t.getRequest(ctx, path, &body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var response ShowDetail
+	err = json.Unmarshal(body, &response)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to decode show %d: %v", id, err)
+	}
+	response.PosterPath = fmt.Sprintf("%s%s", baseImageURL, response.PosterPath)
+
+	// appended seasons are keyed "season/N" alongside the series fields
+	var appended map[string]json.RawMessage
+	err = json.Unmarshal(body, &appended)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to decode show %d: %v", id, err)
+	}
+
+	seasons := map[int]*SeasonDetails{}
+	for _, number := range seasonNumbers {
+		raw, ok := appended[fmt.Sprintf("season/%d", number)]
+		if !ok {
+			continue
+		}
+
+		var season SeasonDetails
+		err = json.Unmarshal(raw, &season)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to decode season %d of show %d: %v", number, id, err)
+		}
+		seasons[number] = &season
+	}
+
+	return &response, seasons, nil
+}
+
+// https://developer.themoviedb.org/reference/tv-season-details
+func (t *TMDB) SeasonDetails(ctx context.Context, showID int, seasonNumber int) (*SeasonDetails, error) {
+	var season SeasonDetails
+	err := t.getRequest(ctx, fmt.Sprintf("tv/%v/season/%v", strconv.Itoa(showID), seasonNumber), &season)
+	if err != nil {
+		return nil, err
+	}
+
+	return &season, nil
+}
+
+// https://developer.themoviedb.org/reference/tv-series-popular-list
+func (t *TMDB) PopularShows(ctx context.Context, page int) ([]Show, error) {
+	url := fmt.Sprintf("tv/popular?language=en-US&page=%d", page)
+	var results searchShowsResponse
+	err := t.getRequest(ctx, url, &results)
+	if err != nil {
+		return nil, err
+	}
+
+	return results.Results, nil
+}
+
+type findResponse struct {
+	TVResults []Show `json:"tv_results"`
+}
+
+// https://developer.themoviedb.org/reference/find-by-id
+func (t *TMDB) FindByExternalID(ctx context.Context, source string, id string) (*Show, error) {
+	if source == "tmdb" {
+		showID, err := strconv.Atoi(id)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TMDB ID %q", id)
+		}
+
+		details, err := t.ShowDetails(ctx, showID)
+		if errors.Is(err, ErrNotFound) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		return &Show{
+			ID:          details.ID,
+			Name:        details.Name,
+			AirDate:     details.AirDate,
+			Description: details.Description,
+			PosterPath:  details.PosterPath,
+		}, nil
+	}
+
+	externalSources := map[string]string{
+		"tvdb": "tvdb_id",
+		"imdb": "imdb_id",
+	}
+	externalSource, ok := externalSources[source]
+	if !ok {
+		return nil, fmt.Errorf("unsupported external ID source %q", source)
+	}
+
+	var results findResponse
+	err := t.getRequest(ctx, fmt.Sprintf("find/%s?external_source=%s", url.PathEscape(id), externalSource), &results)
+	if err != nil {
+		return nil, err
+	}
+	if len(results.TVResults) == 0 {
+		return nil, nil
+	}
+
+	show := results.TVResults[0]
+	show.PosterPath = fmt.Sprintf("%s%s", baseImageURL, show.PosterPath)
+	return &show, nil
+}
+
+// posterVariant swaps the size in a TMDB poster URL. Other URLs are returned
+// unchanged, and URLs without an image (the show has no poster) as "".
+func posterVariant(poster string, size string) string {
+	rest, ok := strings.CutPrefix(poster, imageURL)
+	if !ok {
+		return poster
+	}
+
+	i := strings.Index(rest, "/")
+	if i == -1 || i == len(rest)-1 {
+		return ""
+	}
+	return imageURL + posterSizes[size] + rest[i:]
+}
+
+// getRequest fetches relativeURL and decodes the JSON response into output.
+// Transport errors, rate limits and server errors are retried with
+// exponential backoff, other failures are returned straight away.
+func (t *TMDB) getRequest(ctx context.Context, relativeURL string, output interface{}) error {
+	for attempt := 1; ; attempt++ {
+		err := t.tryRequest(ctx, relativeURL, output)
+		if err == nil {
+			return nil
+		}
+
+		var apiErr *APIError
+		if !errors.As(err, &apiErr) || !retryable(apiErr) || attempt == maxAttempts {
+			return err
+		}
+
+		delay := backoff(attempt)
+		if apiErr.RetryAfter > 0 {
+			delay = apiErr.RetryAfter
+		}
+		// don't hold up a page for longer than the caller will wait
+		deadline, ok := ctx.Deadline()
+		if delay > maxRetryDelay || (ok && time.Now().Add(delay).After(deadline)) {
+			return err
+		}
+
+		slog.WarnContext(ctx, "Provider request failed, retrying", "err", err, "delay", delay.Round(time.Millisecond))
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// tryRequest makes a single request, returning an *APIError if it fails
+func (t *TMDB) tryRequest(ctx context.Context, relativeURL string, output interface{}) (err error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", t.baseURL+relativeURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+t.token)
+
+	waitStart := time.Now()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.limiter:
+	}
+	tmdbRateLimitWait.Since(waitStart)
+
+	start := time.Now()
+	defer func() {
+		tmdbDuration.Since(start)
+		tmdbRequests.Inc(requestResult(err))
+		recordProviderResult(err)
+	}()
+
+	res, err := t.client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		// the URL is already in the APIError
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return &APIError{Path: relativeURL, Kind: ErrUnavailable, Err: err}
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		// let the connection be reused
+		io.Copy(io.Discard, io.LimitReader(res.Body, 64<<10))
+
+		apiErr := &APIError{
+			Path:       relativeURL,
+			StatusCode: res.StatusCode,
+			RetryAfter: parseRetryAfter(res.Header.Get("Retry-After")),
+		}
+		switch {
+		case res.StatusCode == http.StatusNotFound:
+			apiErr.Kind = ErrNotFound
+		case res.StatusCode == http.StatusUnauthorized || res.StatusCode == http.StatusForbidden:
+			apiErr.Kind = ErrUnauthorized
+		case res.StatusCode == http.StatusTooManyRequests:
+			apiErr.Kind = ErrRateLimited
+		case res.StatusCode >= 500:
+			apiErr.Kind = ErrUnavailable
+		}
+		return apiErr
+	}
+
+	err = json.NewDecoder(res.Body).Decode(output)
+	if err != nil {
+		return fmt.Errorf("failed to decode %s: %v", relativeURL, err)
+	}
+
+	return nil
+}
+
+// requestResult names how a request went for the metrics
+func requestResult(err error) string {
+	switch {
+	case err == nil:
+		return "ok"
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return "cancelled"
+	case errors.Is(err, ErrNotFound):
+		return "not_found"
+	case errors.Is(err, ErrUnauthorized):
+		return "unauthorized"
+	case errors.Is(err, ErrRateLimited):
+		return "rate_limited"
+	case errors.Is(err, ErrUnavailable):
+		return "unavailable"
+	default:
+		return "error"
+	}
+}
+
+func retryable(err *APIError) bool {
+	return err.Kind == ErrRateLimited || err.Kind == ErrUnavailable
+}
+
+// backoff is the delay before retrying after the attempt failed, doubling
+// each time with up to half of it random so clients don't retry in step
+func backoff(attempt int) time.Duration {
+	delay := retryBackoff << (attempt - 1)
+	return delay/2 + rand.N(delay/2+1)
+}
+
+// parseRetryAfter reads a Retry-After header, either in seconds or a date
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	seconds, err := strconv.Atoi(value)
+	if err == nil {
+		return max(time.Duration(seconds)*time.Second, 0)
+	}
+	date, err := http.ParseTime(value)
+	if err == nil {
+		return max(time.Until(date), 0)
+	}
+	return 0
+}
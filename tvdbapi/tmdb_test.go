@@ -0,0 +1,177 @@
+package tvdbapi
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testTMDB points a TMDB client at handler, without rate limiting or backoff delays
+func testTMDB(t *testing.T, handler http.HandlerFunc) *TMDB {
+	t.Helper()
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	oldBackoff := retryBackoff
+	retryBackoff = time.Millisecond
+	t.Cleanup(func() { retryBackoff = oldBackoff })
+
+	limiter := make(chan time.Time)
+	close(limiter)
+	return &TMDB{
+		token:   "token",
+		baseURL: server.URL + "/",
+		client:  server.Client(),
+		limiter: limiter,
+	}
+}
+
+func TestGetRequestErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		want     error
+		attempts int32
+	}{
+		{"not found", http.StatusNotFound, ErrNotFound, 1},
+		{"unauthorized", http.StatusUnauthorized, ErrUnauthorized, 1},
+		{"rate limited", http.StatusTooManyRequests, ErrRateLimited, maxAttempts},
+		{"server error", http.StatusBadGateway, ErrUnavailable, maxAttempts},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var attempts atomic.Int32
+			tmdb := testTMDB(t, func(w http.ResponseWriter, r *http.Request) {
+				attempts.Add(1)
+				w.WriteHeader(tt.status)
+				w.Write([]byte(`{"status_message": "failed"}`))
+			})
+
+			_, err := tmdb.ShowDetails(context.Background(), 1)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("error = %v, want %v", err, tt.want)
+			}
+			var apiErr *APIError
+			if !errors.As(err, &apiErr) || apiErr.StatusCode != tt.status {
+				t.Errorf("error = %#v, want an APIError with status %d", err, tt.status)
+			}
+			if attempts.Load() != tt.attempts {
+				t.Errorf("made %d attempts, want %d", attempts.Load(), tt.attempts)
+			}
+		})
+	}
+}
+
+func TestGetRequestRetries(t *testing.T) {
+	var attempts atomic.Int32
+	tmdb := testTMDB(t, func(w http.ResponseWriter, r *http.Request) {
+		switch attempts.Add(1) {
+		case 1:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case 2:
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+		default:
+			w.Write([]byte(`{"id": 1, "name": "Severance"}`))
+		}
+	})
+
+	start := time.Now()
+	show, err := tmdb.ShowDetails(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if show.Name != "Severance" || attempts.Load() != 3 {
+		t.Errorf("show = %+v after %d attempts, want Severance after 3", show, attempts.Load())
+	}
+	if time.Since(start) < time.Second {
+		t.Errorf("finished after %v, want Retry-After to be honoured", time.Since(start))
+	}
+}
+
+func TestGetRequestGivesUpForLongRetryAfter(t *testing.T) {
+	var attempts atomic.Int32
+	tmdb := testTMDB(t, func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		w.Header().Set("Retry-After", "3600")
+		w.WriteHeader(http.StatusTooManyRequests)
+	})
+
+	_, err := tmdb.ShowDetails(context.Background(), 1)
+	if !errors.Is(err, ErrRateLimited) || attempts.Load() != 1 {
+		t.Errorf("error = %v after %d attempts, want rate limited after 1", err, attempts.Load())
+	}
+}
+
+func TestGetRequestStopsWhenCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	tmdb := testTMDB(t, func(w http.ResponseWriter, r *http.Request) {
+		cancel()
+		w.Header().Set("Retry-After", "10")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	start := time.Now()
+	_, err := tmdb.ShowDetails(ctx, 1)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("error = %v, want context.Canceled", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("cancelled request took %v", time.Since(start))
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", 0},
+		{"5", 5 * time.Second},
+		{"-1", 0},
+		{"soon", 0},
+		{time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat), 0},
+	}
+
+	for _, tt := range tests {
+		got := parseRetryAfter(tt.value)
+		if got != tt.want {
+			t.Errorf("parseRetryAfter(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestShowDetailsWithSeasons(t *testing.T) {
+	var query string
+	tmdb := testTMDB(t, func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query().Get("append_to_response")
+		w.Write([]byte(`{
+			"id": 1,
+			"name": "Severance",
+			"poster_path": "/poster.jpg",
+			"seasons": [{"season_number": 1}, {"season_number": 2}, {"season_number": 3}],
+			"season/1": {"episodes": [{"episode_number": 1}, {"episode_number": 2}]},
+			"season/2": {"episodes": [{"episode_number": 1}]}
+		}`))
+	})
+
+	show, seasons, err := tmdb.ShowDetailsWithSeasons(context.Background(), 1, []int{1, 2, 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if query != "season/1,season/2,season/3" {
+		t.Errorf("append_to_response = %q, want all three seasons", query)
+	}
+	if show.Name != "Severance" || len(show.Seasons) != 3 || show.PosterPath != baseImageURL+"/poster.jpg" {
+		t.Errorf("show = %+v, want Severance with 3 seasons", show)
+	}
+	if len(seasons) != 2 || len(seasons[1].Episodes) != 2 || len(seasons[2].Episodes) != 1 {
+		t.Errorf("seasons = %+v, want the two appended seasons", seasons)
+	}
+}
@@ -2,15 +2,12 @@ package tvdbapi
 
 import (
 	"cmp"
+	"context"
 	"database/sql"
-	"encoding/json"
 	"fmt"
-	"log"
-	"net/http"
-	"net/url"
+	"log/slog"
 	"slices"
 	"sort"
-	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -18,11 +15,6 @@ import (
 	"github.com/jccroft1/goshowtrack/db"
 )
 
-const (
-	baseUrl      string = "https://api.themoviedb.org/3/"
-	baseImageURL string = "https://media.themoviedb.org/t/p/w300_and_h450_bestv2"
-)
-
 type Show struct {
 	ID          int    `json:"id"`
 	Name        string `json:"name"`
@@ -31,45 +23,43 @@ type Show struct {
 	PosterPath  string `json:"poster_path"`
 }
 
-type SearchShowsResponse struct {
-	Results []Show `json:"results"`
-}
-
 type PopularShowDetails struct {
 	name       string
 	popularity float32
 }
 
 var (
-	client = &http.Client{
-		Timeout: time.Second,
-	}
-	limiter = time.Tick(120 * time.Millisecond)
-
-	token string
+	provider Provider
 
 	// normalized name used as key
 	popularShows   map[string]PopularShowDetails
 	popularShowsMu sync.RWMutex
+	// set once the first load has finished, whether or not it worked
+	popularShowsTried bool
+	popularShowsPages = 100
 )
 
-func Setup(_token string) {
-	token = _token
+// Options configure the provider shows are fetched from
+type Options struct {
+	// Name is the provider to use, see NewProvider
+	Name  string
+	Token string
+	// RequestInterval is the least time between requests to the provider
+	RequestInterval time.Duration
+	// PopularShowsPages is how many pages of popular shows are loaded for autofill
+	PopularShowsPages int
+}
 
-	go func() {
-		t := time.Tick(time.Hour * 50)
-		for range t {
-			refreshShows()
-		}
-	}()
+// Setup creates the provider used to fetch shows
+func Setup(options Options) error {
+	var err error
+	provider, err = NewProvider(options.Name, options.Token, options.RequestInterval)
+	if err != nil {
+		return err
+	}
 
-	loadPopularShows()
-	go func() {
-		t := time.Tick(time.Hour * 200)
-		for range t {
-			loadPopularShows()
-		}
-	}()
+	popularShowsPages = options.PopularShowsPages
+	return nil
 }
 
 type NameScore struct {
@@ -139,27 +129,34 @@ func FindPopularShows(search string) []string {
 	return result
 }
 
-func loadPopularShows() {
-	maxPages := 100
+// LoadPopularShows replaces the list of popular shows used for autofill,
+// keeping the old list if it fails
+func LoadPopularShows(ctx context.Context) error {
 	perPage := 20
-	maxRank := float32(maxPages * perPage)
+	maxRank := float32(popularShowsPages * perPage)
 
-	log.Println("Loading popular shows...")
+	slog.InfoContext(ctx, "Loading popular shows")
+	defer func() {
+		popularShowsMu.Lock()
+		popularShowsTried = true
+		popularShowsMu.Unlock()
+	}()
 
-	popularShowsMu.Lock()
-	defer popularShowsMu.Unlock()
-	popularShows = make(map[string]PopularShowDetails)
+	shows := make(map[string]PopularShowDetails)
+	for i := 1; i <= popularShowsPages; i++ {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 
-	var results SearchShowsResponse
-	for i := 1; i < maxPages; i++ {
-		url := fmt.Sprintf("tv/popular?language=en-US&page=%d", i)
-		err := getRequest(url, &results)
+		results, err := provider.PopularShows(ctx, i)
 		if err != nil {
-			log.Println("failed to scan show id", err)
-			return
+			return fmt.Errorf("failed to load popular shows: %v", err)
+		}
+		if len(results) == 0 {
+			break
 		}
 
-		for j, show := range results.Results {
+		for j, show := range results {
 			showName := removeSubtitle(show.Name)
 
 			normName := NormalizeShowName(showName)
@@ -168,7 +165,7 @@ func loadPopularShows() {
 				continue
 			}
 
-			_, exists := popularShows[normName]
+			_, exists := shows[normName]
 			if exists {
 				// prioritize existing show as it's more popular
 				continue
@@ -178,69 +175,63 @@ func loadPopularShows() {
 			rank := ((i - 1) * perPage) + j
 			popularity := (maxRank - float32(rank)) / maxRank
 
-			popularShows[normName] = PopularShowDetails{
+			shows[normName] = PopularShowDetails{
 				name:       showName,
 				popularity: float32(popularity),
 			}
 		}
 	}
 
-	log.Println("Popular show load complete.")
-}
-
-func refreshShows() {
-	log.Println("Refreshing shows...")
+	popularShowsMu.Lock()
+	popularShows = shows
+	popularShowsMu.Unlock()
 
-	rows, err := db.Connection.Query("SELECT show_id, status FROM shows")
-	if err != nil {
-		log.Println("failed to load show ids", err)
-		return
-	}
+	slog.InfoContext(ctx, "Popular show load complete", "shows", len(shows))
+	return nil
+}
 
-	var ids []int
-	for rows.Next() {
-		var id int
-		var status string
-		err := rows.Scan(&id, &status)
-		if err != nil {
-			log.Println("failed to scan show id", err)
-			continue
-		}
+func SearchShow(ctx context.Context, query string) ([]Show, error) {
+	return provider.SearchShows(ctx, query)
+}
 
-		if IsFinished(status) {
-			continue
-		}
+// PosterSizes are the sizes PosterURL can return
+var PosterSizes = []string{"small", "large"}
 
-		ids = append(ids, id)
+// PosterURL returns the provider's URL for the show's poster in size, or ""
+// if the show doesn't have one. With lookup, shows that aren't cached (e.g.
+// search results) are fetched from the provider without caching them,
+// otherwise "" is returned for them.
+func PosterURL(ctx context.Context, showID int, size string, lookup bool) (string, error) {
+	if !slices.Contains(PosterSizes, size) {
+		return "", fmt.Errorf("unknown poster size %q", size)
 	}
-	rows.Close()
 
-	for _, id := range ids {
-		_, err = GetShowDetails(id, true)
+	var poster string
+	err := db.Connection.QueryRow("SELECT poster_path FROM shows WHERE show_id = ?", showID).Scan(&poster)
+	if err == sql.ErrNoRows {
+		if !lookup {
+			return "", nil
+		}
+		show, err := provider.ShowDetails(ctx, showID)
 		if err != nil {
-			log.Println("failed to get show details", err)
-			continue
+			return "", err
 		}
+		poster = show.PosterPath
+	} else if err != nil {
+		return "", fmt.Errorf("failed to load poster: %v", err)
 	}
-	log.Println("Refresh complete.")
-}
 
-func SearchShow(query string) ([]Show, error) {
-	escapedQuery := url.QueryEscape(query)
-
-	url := fmt.Sprintf("search/tv?query=%v&include_adult=false&language=en-US&page=1", escapedQuery)
-	var results SearchShowsResponse
-	err := getRequest(url, &results)
-	if err != nil {
-		return nil, err
-	}
+	return posterVariant(poster, size), nil
+}
 
-	for i, show := range results.Results {
-		show.PosterPath = fmt.Sprintf("%s%s", baseImageURL, show.PosterPath)
-		results.Results[i] = show
+// FindShowByExternalID looks up a show by another catalog's ID. It returns nil
+// if the show isn't found or the provider doesn't support external IDs.
+func FindShowByExternalID(ctx context.Context, source string, id string) (*Show, error) {
+	finder, ok := provider.(ExternalIDFinder)
+	if !ok {
+		return nil, nil
 	}
-
-	return results.Results, nil
+	return finder.FindByExternalID(ctx, source, id)
 }
 
 type ShowDetail struct {
@@ -251,6 +242,9 @@ type ShowDetail struct {
 	Description string   `json:"overview"`
 	PosterPath  string   `json:"poster_path"`
 	Seasons     []Season `json:"seasons"`
+	// FetchedAt is when the show and its seasons were last fetched from the
+	// provider, the oldest of them if they differ
+	FetchedAt time.Time `json:"-"`
 }
 
 type Season struct {
@@ -259,6 +253,8 @@ type Season struct {
 	EpisodeCount int    `json:"episode_count"`
 	AirDate      string `json:"air_date"`
 	LastAirDate  string
+	Episodes     []Episode `json:"episodes"`
+	FetchedAt    time.Time `json:"-"`
 }
 
 type SeasonDetails struct {
@@ -266,50 +262,63 @@ type SeasonDetails struct {
 }
 
 type Episode struct {
-	AirDate string `json:"air_date"`
+	SeasonNumber int    `json:"season_number"`
+	Number       int    `json:"episode_number"`
+	Name         string `json:"name"`
+	AirDate      string `json:"air_date"`
 }
 
-// https://developer.themoviedb.org/reference/tv-series-details
-// https://developer.themoviedb.org/reference/tv-season-details
-func GetShowDetails(id int, forceRefresh bool) (*ShowDetail, error) {
-	if !forceRefresh {
-		var show ShowDetail
-		row := db.Connection.QueryRow("SELECT show_id, name, status, air_date, description, poster_path FROM shows WHERE show_id = ?", id)
-		err := row.Scan(&show.ID, &show.Name, &show.Status, &show.AirDate, &show.Description, &show.PosterPath)
-		if err != nil && err != sql.ErrNoRows {
-			return nil, fmt.Errorf("failed to check show details in DB: %v", err)
-		}
-		if err != sql.ErrNoRows {
-			// load seasons
-			rows, err := db.Connection.Query("SELECT name, episode_count, season_number, air_date, last_air_date FROM seasons WHERE show_id = ?", id)
-			if err != nil {
-				return nil, fmt.Errorf("failed to query seasons: %v", err)
-			}
-			defer rows.Close()
-
-			seasons := []Season{}
-			for rows.Next() {
-				var season Season
-				err := rows.Scan(&season.Name, &season.EpisodeCount, &season.Number, &season.AirDate, &season.LastAirDate)
-				if err != nil {
-					return nil, fmt.Errorf("failed to scan season: %v", err)
-				}
-				seasons = append(seasons, season)
-			}
+const (
+	// seasonWorkers is how many season requests are made at once, the
+	// provider's rate limit still applies across all of them
+	seasonWorkers = 4
+	// maxBatchedSeasons is the most seasons a SeasonBatcher is asked for in
+	// one request, TMDB's limit for append_to_response
+	maxBatchedSeasons = 20
+)
+
+// GetShowDetails loads a show from the DB cache, or from the provider if it's
+// missing or forceRefresh is set
+func GetShowDetails(ctx context.Context, id int, forceRefresh bool) (*ShowDetail, error) {
+	cached, err := loadCachedShow(id)
+	if err != nil {
+		return nil, err
+	}
+	return showDetails(ctx, id, cached, forceRefresh)
+}
 
-			show.Seasons = seasons
+// CachedShowDetails is GetShowDetails for a show already loaded from the DB
+// cache, e.g. with other shows in one query. cached is nil if it's not saved.
+func CachedShowDetails(ctx context.Context, id int, cached *ShowDetail) (*ShowDetail, error) {
+	return showDetails(ctx, id, cached, false)
+}
 
-			return &show, err
+func showDetails(ctx context.Context, id int, cached *ShowDetail, forceRefresh bool) (*ShowDetail, error) {
+	if !forceRefresh && cached != nil && !missingEpisodes(cached) {
+		// serve stale shows straight away, they're updated in the background
+		if IsStale(cached) {
+			queueRefresh(id)
+		}
+		return cached, nil
+	}
+
+	// seasons we already know about can be fetched with the show
+	var knownSeasons []int
+	if cached != nil {
+		for _, s := range cached.Seasons {
+			knownSeasons = append(knownSeasons, s.Number)
 		}
 	}
 
 	// actual request
-	var response ShowDetail
-	err := getRequest("tv/"+strconv.Itoa(id), &response)
+	response, seasons, err := fetchShow(ctx, id, knownSeasons)
 	if err != nil {
+		if cached != nil && !forceRefresh {
+			slog.WarnContext(ctx, "Failed to fetch missing episodes, using cached show", "show", id, "err", err)
+			return cached, nil
+		}
 		return nil, err
 	}
-	response.PosterPath = fmt.Sprintf("%s%s", baseImageURL, response.PosterPath)
 
 	// remove Season 0 (specials)
 	for i, s := range response.Seasons {
@@ -321,13 +330,31 @@ func GetShowDetails(id int, forceRefresh bool) (*ShowDetail, error) {
 		break // assume there's only 1 season 0
 	}
 
-	// bit of a hack, we fetch each Season details, then find the newest episode and augment the response
+	var missing []int
+	for _, s := range response.Seasons {
+		if seasons[s.Number] == nil {
+			missing = append(missing, s.Number)
+		}
+	}
+	fetched, err := fetchSeasons(ctx, id, missing)
+	if err != nil {
+		if cached != nil && !forceRefresh {
+			slog.WarnContext(ctx, "Failed to fetch missing episodes, using cached show", "show", id, "err", err)
+			return cached, nil
+		}
+		return nil, err
+	}
+	for number, season := range fetched {
+		seasons[number] = season
+	}
+
+	// the newest episode is used as the season's last air date
 	for i, s := range response.Seasons {
-		var season SeasonDetails
-		err = getRequest(fmt.Sprintf("tv/%v/season/%v", strconv.Itoa(id), s.Number), &season)
-		if err != nil {
-			return nil, fmt.Errorf("failed to fetch season %v details for show %d: %v", s.Number, id, err)
+		season := seasons[s.Number]
+		for j := range season.Episodes {
+			season.Episodes[j].SeasonNumber = s.Number
 		}
+		response.Seasons[i].Episodes = season.Episodes
 
 		if len(season.Episodes) == 0 {
 			continue
@@ -340,67 +367,327 @@ func GetShowDetails(id int, forceRefresh bool) (*ShowDetail, error) {
 		response.Seasons[i].LastAirDate = newestEpisode.AirDate
 	}
 
-	// save to DB
-	query := `INSERT INTO shows (show_id, name, status, air_date, description, poster_path) 
-	VALUES (?, ?, ?, ?, ?, ?)
+	err = saveShow(ctx, response)
+	if err != nil {
+		return &ShowDetail{}, err
+	}
+
+	return response, nil
+}
+
+// fetchShow gets the show from the provider, along with as many of
+// seasonNumbers as it can include in the same request
+func fetchShow(ctx context.Context, id int, seasonNumbers []int) (*ShowDetail, map[int]*SeasonDetails, error) {
+	batcher, ok := provider.(SeasonBatcher)
+	if ok {
+		return batcher.ShowDetailsWithSeasons(ctx, id, seasonNumbers)
+	}
+
+	show, err := provider.ShowDetails(ctx, id)
+	return show, map[int]*SeasonDetails{}, err
+}
+
+// fetchSeasons gets the seasons from the provider using a pool of
+// seasonWorkers, batching them if the provider supports it. It stops at the
+// first error.
+func fetchSeasons(ctx context.Context, id int, seasonNumbers []int) (map[int]*SeasonDetails, error) {
+	batcher, canBatch := provider.(SeasonBatcher)
+
+	var batches [][]int
+	if canBatch {
+		for batch := range slices.Chunk(seasonNumbers, maxBatchedSeasons) {
+			batches = append(batches, batch)
+		}
+	} else {
+		for _, number := range seasonNumbers {
+			batches = append(batches, []int{number})
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		mu       sync.Mutex
+		seasons  = map[int]*SeasonDetails{}
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	fail := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if firstErr == nil {
+			firstErr = err
+			cancel()
+		}
+	}
+
+	work := make(chan []int)
+	for range min(seasonWorkers, len(batches)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for batch := range work {
+				got := map[int]*SeasonDetails{}
+				if canBatch {
+					var err error
+					_, got, err = batcher.ShowDetailsWithSeasons(ctx, id, batch)
+					if err != nil {
+						fail(fmt.Errorf("failed to fetch seasons %v details for show %d: %w", batch, id, err))
+						continue
+					}
+				}
+
+				for _, number := range batch {
+					if got[number] != nil {
+						continue
+					}
+					season, err := provider.SeasonDetails(ctx, id, number)
+					if err != nil {
+						fail(fmt.Errorf("failed to fetch season %v details for show %d: %w", number, id, err))
+						break
+					}
+					got[number] = season
+				}
+
+				mu.Lock()
+				for number, season := range got {
+					seasons[number] = season
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for _, batch := range batches {
+		if ctx.Err() != nil {
+			break
+		}
+		work <- batch
+	}
+	close(work)
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return seasons, nil
+}
+
+// saveShow writes the show, its seasons and episodes to the DB cache in one transaction
+func saveShow(ctx context.Context, show *ShowDetail) error {
+	tx, err := db.Connection.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to save show: %v", err)
+	}
+	defer tx.Rollback()
+
+	show.FetchedAt = time.Now().UTC().Truncate(time.Second)
+	fetchedAt := show.FetchedAt.Format(time.RFC3339)
+
+	query := `INSERT INTO shows (show_id, name, status, air_date, description, poster_path, fetched_at) 
+	VALUES (?, ?, ?, ?, ?, ?, ?)
 	ON CONFLICT(show_id) DO UPDATE SET
 		name = excluded.name, 
 		status = excluded.status, 
 		air_date = excluded.air_date, 
 		description = excluded.description, 
-		poster_path = excluded.poster_path;`
-	_, err = db.Connection.Exec(query,
-		response.ID, response.Name, response.Status, response.AirDate, response.Description, response.PosterPath)
+		poster_path = excluded.poster_path,
+		fetched_at = excluded.fetched_at;`
+	_, err = tx.Exec(query,
+		show.ID, show.Name, show.Status, show.AirDate, show.Description, show.PosterPath, fetchedAt)
 	if err != nil {
-		return &ShowDetail{}, fmt.Errorf("failed to insert show: %v", err)
+		return fmt.Errorf("failed to insert show: %v", err)
 	}
 
-	for _, s := range response.Seasons {
-		query = `INSERT INTO seasons (show_id, name, season_number, episode_count, air_date, last_air_date) 
-		VALUES (?, ?, ?, ?, ?, ?)
+	for i, s := range show.Seasons {
+		show.Seasons[i].FetchedAt = show.FetchedAt
+
+		query = `INSERT INTO seasons (show_id, name, season_number, episode_count, air_date, last_air_date, fetched_at) 
+		VALUES (?, ?, ?, ?, ?, ?, ?)
 		ON CONFLICT(show_id, season_number) DO UPDATE SET
 			name = excluded.name, 
 			episode_count = excluded.episode_count, 
 			air_date = excluded.air_date, 
-			last_air_date = excluded.last_air_date;`
-		_, err = db.Connection.Exec(query,
-			response.ID, s.Name, s.Number, s.EpisodeCount, s.AirDate, s.LastAirDate)
+			last_air_date = excluded.last_air_date,
+			fetched_at = excluded.fetched_at;`
+		_, err = tx.Exec(query,
+			show.ID, s.Name, s.Number, s.EpisodeCount, s.AirDate, s.LastAirDate, fetchedAt)
 		if err != nil {
-			return &ShowDetail{}, fmt.Errorf("failed to insert season: %v", err)
+			return fmt.Errorf("failed to insert season: %v", err)
 		}
-	}
 
-	return &response, nil
-}
+		for _, e := range s.Episodes {
+			query = `INSERT INTO episodes (show_id, season_number, episode_number, name, air_date) 
+			VALUES (?, ?, ?, ?, ?)
+			ON CONFLICT(show_id, season_number, episode_number) DO UPDATE SET
+				name = excluded.name, 
+				air_date = excluded.air_date;`
+			_, err = tx.Exec(query,
+				show.ID, s.Number, e.Number, e.Name, e.AirDate)
+			if err != nil {
+				return fmt.Errorf("failed to insert episode: %v", err)
+			}
+		}
+	}
 
-func getRequest(relativeURL string, output interface{}) error {
-	url := fmt.Sprintf("%s%s", baseUrl, relativeURL)
-	req, err := http.NewRequest("GET", url, nil)
+	err = deleteStaleEpisodes(tx, show)
 	if err != nil {
 		return err
 	}
 
-	req.Header.Set("Authorization", "Bearer "+token)
-	<-limiter
-	res, err := client.Do(req)
+	return tx.Commit()
+}
+
+// deleteStaleEpisodes removes the show's cached seasons and episodes the
+// provider no longer lists, e.g. after it renumbers them
+func deleteStaleEpisodes(tx *sql.Tx, show *ShowDetail) error {
+	type episodeKey struct{ season, episode int }
+	seasons := map[int]bool{}
+	episodes := map[episodeKey]bool{}
+	for _, s := range show.Seasons {
+		seasons[s.Number] = true
+		for _, e := range s.Episodes {
+			episodes[episodeKey{s.Number, e.Number}] = true
+		}
+	}
+
+	rows, err := tx.Query("SELECT season_number, episode_number FROM episodes WHERE show_id = ?", show.ID)
 	if err != nil {
-		log.Printf("failed to make request, retrying: %v", err)
-		<-limiter
-		res, err = client.Do(req)
+		return fmt.Errorf("failed to query cached episodes: %v", err)
+	}
+	var staleEpisodes []episodeKey
+	for rows.Next() {
+		var key episodeKey
+		err = rows.Scan(&key.season, &key.episode)
 		if err != nil {
-			return err
+			rows.Close()
+			return fmt.Errorf("failed to scan cached episode: %v", err)
 		}
+		if !episodes[key] {
+			staleEpisodes = append(staleEpisodes, key)
+		}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("failed to query cached episodes: %v", err)
 	}
 
-	defer res.Body.Close()
-	err = json.NewDecoder(res.Body).Decode(&output)
+	rows, err = tx.Query("SELECT season_number FROM seasons WHERE show_id = ?", show.ID)
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to query cached seasons: %v", err)
+	}
+	var staleSeasons []int
+	for rows.Next() {
+		var number int
+		err = rows.Scan(&number)
+		if err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan cached season: %v", err)
+		}
+		if !seasons[number] {
+			staleSeasons = append(staleSeasons, number)
+		}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("failed to query cached seasons: %v", err)
+	}
+
+	for _, key := range staleEpisodes {
+		_, err = tx.Exec("DELETE FROM episodes WHERE show_id = ? AND season_number = ? AND episode_number = ?",
+			show.ID, key.season, key.episode)
+		if err != nil {
+			return fmt.Errorf("failed to delete episode: %v", err)
+		}
+	}
+	for _, number := range staleSeasons {
+		_, err = tx.Exec("DELETE FROM seasons WHERE show_id = ? AND season_number = ?", show.ID, number)
+		if err != nil {
+			return fmt.Errorf("failed to delete season: %v", err)
+		}
 	}
 
 	return nil
 }
 
+// loadCachedShow returns the show saved in the DB, or nil if it's not been saved
+func loadCachedShow(id int) (*ShowDetail, error) {
+	var show ShowDetail
+	var fetchedAt sql.NullString
+	row := db.Connection.QueryRow("SELECT show_id, name, status, air_date, description, poster_path, fetched_at FROM shows WHERE show_id = ?", id)
+	err := row.Scan(&show.ID, &show.Name, &show.Status, &show.AirDate, &show.Description, &show.PosterPath, &fetchedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to check show details in DB: %v", err)
+	}
+
+	// shows saved before fetched_at was added are left as zero, so they're stale
+	show.FetchedAt, _ = time.Parse(time.RFC3339, fetchedAt.String)
+
+	// load seasons
+	rows, err := db.Connection.Query("SELECT name, episode_count, season_number, air_date, last_air_date, fetched_at FROM seasons WHERE show_id = ? ORDER BY season_number", id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query seasons: %v", err)
+	}
+	defer rows.Close()
+
+	seasons := []Season{}
+	seasonIndex := map[int]int{}
+	for rows.Next() {
+		var season Season
+		var seasonFetchedAt sql.NullString
+		err := rows.Scan(&season.Name, &season.EpisodeCount, &season.Number, &season.AirDate, &season.LastAirDate, &seasonFetchedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan season: %v", err)
+		}
+		season.FetchedAt, _ = time.Parse(time.RFC3339, seasonFetchedAt.String)
+		if season.FetchedAt.Before(show.FetchedAt) {
+			show.FetchedAt = season.FetchedAt
+		}
+		seasonIndex[season.Number] = len(seasons)
+		seasons = append(seasons, season)
+	}
+	rows.Close()
+
+	// load episodes
+	rows, err = db.Connection.Query("SELECT season_number, episode_number, name, air_date FROM episodes WHERE show_id = ? ORDER BY season_number, episode_number", id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query episodes: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var episode Episode
+		err := rows.Scan(&episode.SeasonNumber, &episode.Number, &episode.Name, &episode.AirDate)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan episode: %v", err)
+		}
+
+		i, ok := seasonIndex[episode.SeasonNumber]
+		if !ok {
+			continue
+		}
+		seasons[i].Episodes = append(seasons[i].Episodes, episode)
+	}
+
+	show.Seasons = seasons
+
+	return &show, nil
+}
+
+// missingEpisodes reports whether the show was cached before episodes were saved
+func missingEpisodes(show *ShowDetail) bool {
+	for _, season := range show.Seasons {
+		if season.EpisodeCount > 0 && len(season.Episodes) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
 func IsFinished(status string) bool {
 	status = strings.ToLower(status)
 	switch status {
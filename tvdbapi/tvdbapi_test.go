@@ -0,0 +1,206 @@
+package tvdbapi
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// seasonProvider serves seasons with one episode each, tracking how many
+// requests run at once
+type seasonProvider struct {
+	fakeProvider
+
+	mu          sync.Mutex
+	active      int
+	maxActive   int
+	seasonCalls []int
+	failSeason  int
+}
+
+func (p *seasonProvider) SeasonDetails(ctx context.Context, showID int, seasonNumber int) (*SeasonDetails, error) {
+	p.mu.Lock()
+	p.active++
+	p.maxActive = max(p.maxActive, p.active)
+	p.seasonCalls = append(p.seasonCalls, seasonNumber)
+	p.mu.Unlock()
+
+	time.Sleep(10 * time.Millisecond)
+
+	p.mu.Lock()
+	p.active--
+	p.mu.Unlock()
+
+	if seasonNumber == p.failSeason {
+		return nil, ErrUnavailable
+	}
+	return &SeasonDetails{Episodes: []Episode{{Number: 1}}}, nil
+}
+
+// batchingProvider returns even seasons with the show, odd ones are left for SeasonDetails
+type batchingProvider struct {
+	seasonProvider
+
+	batches [][]int
+}
+
+func (p *batchingProvider) ShowDetailsWithSeasons(ctx context.Context, id int, seasonNumbers []int) (*ShowDetail, map[int]*SeasonDetails, error) {
+	p.mu.Lock()
+	p.batches = append(p.batches, seasonNumbers)
+	p.mu.Unlock()
+
+	seasons := map[int]*SeasonDetails{}
+	for _, number := range seasonNumbers {
+		if number%2 == 0 {
+			seasons[number] = &SeasonDetails{}
+		}
+	}
+	return &ShowDetail{ID: id}, seasons, nil
+}
+
+func seasonRange(n int) []int {
+	numbers := make([]int, n)
+	for i := range numbers {
+		numbers[i] = i + 1
+	}
+	return numbers
+}
+
+func TestFetchSeasonsUsesBoundedPool(t *testing.T) {
+	p := &seasonProvider{}
+	provider = p
+	t.Cleanup(func() { provider = nil })
+
+	seasons, err := fetchSeasons(context.Background(), 1, seasonRange(30))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(seasons) != 30 {
+		t.Errorf("fetched %d seasons, want 30", len(seasons))
+	}
+	if p.maxActive < 2 || p.maxActive > seasonWorkers {
+		t.Errorf("%d requests ran at once, want between 2 and %d", p.maxActive, seasonWorkers)
+	}
+}
+
+func TestFetchSeasonsBatches(t *testing.T) {
+	p := &batchingProvider{}
+	provider = p
+	t.Cleanup(func() { provider = nil })
+
+	seasons, err := fetchSeasons(context.Background(), 1, seasonRange(30))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(seasons) != 30 {
+		t.Errorf("fetched %d seasons, want 30", len(seasons))
+	}
+	if len(p.batches) != 2 {
+		t.Errorf("made %d batch requests, want 2: %v", len(p.batches), p.batches)
+	}
+	// seasons left out of a batch are fetched on their own
+	if len(p.seasonCalls) != 15 {
+		t.Errorf("fetched %d seasons separately, want the 15 odd ones", len(p.seasonCalls))
+	}
+}
+
+func TestFetchSeasonsStopsAtError(t *testing.T) {
+	p := &seasonProvider{failSeason: 3}
+	provider = p
+	t.Cleanup(func() { provider = nil })
+
+	_, err := fetchSeasons(context.Background(), 1, seasonRange(30))
+	if !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("error = %v, want the failed season's error", err)
+	}
+	if len(p.seasonCalls) == 30 {
+		t.Error("kept fetching seasons after one failed")
+	}
+}
+
+func TestSaveShowRemovesStaleEpisodes(t *testing.T) {
+	setupCacheDB(t)
+	ctx := context.Background()
+
+	season := func(number int, episodes ...int) Season {
+		s := Season{Number: number, EpisodeCount: len(episodes)}
+		for _, e := range episodes {
+			s.Episodes = append(s.Episodes, Episode{SeasonNumber: number, Number: e, AirDate: "2020-01-01"})
+		}
+		return s
+	}
+	save := func(show *ShowDetail) {
+		t.Helper()
+		err := saveShow(ctx, show)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	save(&ShowDetail{ID: 1, Name: "Renumbered", Seasons: []Season{season(1, 1, 2, 3), season(2, 1, 2)}})
+	save(&ShowDetail{ID: 2, Name: "Other", Seasons: []Season{season(1, 1, 2), season(2, 1)}})
+
+	// the provider merged season 2 into season 1 and dropped an episode
+	save(&ShowDetail{ID: 1, Name: "Renumbered", Seasons: []Season{season(1, 1, 2, 4, 5)}})
+
+	show, err := loadCachedShow(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(show.Seasons) != 1 {
+		t.Fatalf("cached %d seasons, want 1", len(show.Seasons))
+	}
+	var numbers []int
+	for _, e := range show.Seasons[0].Episodes {
+		numbers = append(numbers, e.Number)
+	}
+	if !slices.Equal(numbers, []int{1, 2, 4, 5}) {
+		t.Errorf("cached episodes %v, want [1 2 4 5]", numbers)
+	}
+
+	// other shows are left alone
+	other, err := loadCachedShow(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(other.Seasons) != 2 || len(other.Seasons[0].Episodes) != 2 || len(other.Seasons[1].Episodes) != 1 {
+		t.Errorf("other show = %+v, want it unchanged", other.Seasons)
+	}
+}
+
+// seasonListProvider lists seasons 1 to 3 with the show, their episodes come
+// from seasonProvider
+type seasonListProvider struct {
+	seasonProvider
+}
+
+func (p *seasonListProvider) ShowDetails(ctx context.Context, id int) (*ShowDetail, error) {
+	show := &ShowDetail{ID: id}
+	for _, number := range seasonRange(3) {
+		show.Seasons = append(show.Seasons, Season{Number: number, EpisodeCount: 1})
+	}
+	return show, nil
+}
+
+func TestShowDetailsKeepsCachedShowWhenSeasonsFail(t *testing.T) {
+	provider = &seasonListProvider{seasonProvider{failSeason: 2}}
+	t.Cleanup(func() { provider = nil })
+
+	// cached before its episodes were saved, so they're fetched again
+	cached := &ShowDetail{ID: 1, Seasons: []Season{{Number: 1, EpisodeCount: 1}}}
+
+	show, err := showDetails(context.Background(), 1, cached, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if show != cached {
+		t.Errorf("got %+v, want the cached show", show)
+	}
+
+	_, err = showDetails(context.Background(), 1, cached, true)
+	if !errors.Is(err, ErrUnavailable) {
+		t.Errorf("forced refresh error = %v, want the failed season's error", err)
+	}
+}
@@ -0,0 +1,171 @@
+package userdata
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// the first line of a CSV export, before the header
+const csvVersionPrefix = "# goshowtrack export version "
+
+var csvHeader = []string{"show_id", "name", "added", "season", "episode"}
+
+// Write encodes the export as "json" or "csv"
+func Write(w io.Writer, format string, export *Export) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(export)
+	case "csv":
+		return writeCSV(w, export)
+	default:
+		return fmt.Errorf("unknown export format %q, expected json or csv", format)
+	}
+}
+
+// writeCSV writes a row per watched episode, shows without progress get a
+// single row with the season and episode left blank
+func writeCSV(w io.Writer, export *Export) error {
+	_, err := fmt.Fprintf(w, "%s%d\n", csvVersionPrefix, export.Version)
+	if err != nil {
+		return err
+	}
+
+	cw := csv.NewWriter(w)
+	err = cw.Write(csvHeader)
+	if err != nil {
+		return err
+	}
+
+	for _, show := range export.Shows {
+		id := strconv.Itoa(show.ID)
+		added := strconv.FormatBool(show.Added)
+		if len(show.Watched) == 0 {
+			err = cw.Write([]string{id, show.Name, added, "", ""})
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		for _, e := range show.Watched {
+			err = cw.Write([]string{id, show.Name, added, strconv.Itoa(e.Season), strconv.Itoa(e.Episode)})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// Read decodes an export written by Write, detecting the format
+func Read(r io.Reader) (*Export, error) {
+	br := bufio.NewReader(r)
+	start, err := br.Peek(1)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read export: %v", err)
+	}
+
+	var export *Export
+	if bytes.Equal(start, []byte("{")) {
+		export = &Export{}
+		err = json.NewDecoder(br).Decode(export)
+		if err != nil {
+			err = fmt.Errorf("failed to read JSON export: %v", err)
+		}
+	} else {
+		export, err = readCSV(br)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if export.Version < 1 {
+		return nil, fmt.Errorf("export is missing its version")
+	}
+	if export.Version > Version {
+		return nil, fmt.Errorf("export version %d is newer than this instance supports (%d), upgrade the app", export.Version, Version)
+	}
+
+	return export, nil
+}
+
+func readCSV(br *bufio.Reader) (*Export, error) {
+	first, err := br.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	versionStr, ok := strings.CutPrefix(strings.TrimSpace(first), csvVersionPrefix)
+	if !ok {
+		return nil, fmt.Errorf("not a goshowtrack export, the first line should start with %q", csvVersionPrefix)
+	}
+	version, err := strconv.Atoi(versionStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid export version %q", versionStr)
+	}
+
+	export := &Export{Version: version, Shows: []Show{}}
+
+	cr := csv.NewReader(br)
+	cr.FieldsPerRecord = len(csvHeader)
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV header: %v", err)
+	}
+	if strings.Join(header, ",") != strings.Join(csvHeader, ",") {
+		return nil, fmt.Errorf("unexpected CSV header %v, expected %v", header, csvHeader)
+	}
+
+	index := map[int]int{}
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CSV: %v", err)
+		}
+		line, _ := cr.FieldPos(0)
+		line++ // the version line isn't part of the CSV
+
+		showID, err := strconv.Atoi(record[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid show_id %q", line, record[0])
+		}
+		added, err := strconv.ParseBool(record[2])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid added %q", line, record[2])
+		}
+
+		i, ok := index[showID]
+		if !ok {
+			i = len(export.Shows)
+			index[showID] = i
+			export.Shows = append(export.Shows, Show{ID: showID, Name: record[1], Added: added, Watched: []Episode{}})
+		}
+
+		if record[3] == "" && record[4] == "" {
+			continue
+		}
+		season, err := strconv.Atoi(record[3])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid season %q", line, record[3])
+		}
+		episode, err := strconv.Atoi(record[4])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid episode %q", line, record[4])
+		}
+		export.Shows[i].Watched = append(export.Shows[i].Watched, Episode{season, episode})
+	}
+
+	return export, nil
+}
@@ -0,0 +1,78 @@
+package userdata
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func testExport() *Export {
+	return &Export{
+		Version:    Version,
+		ExportedAt: "2025-06-01T12:00:00Z",
+		Email:      "dev@localhost",
+		Shows: []Show{
+			{ID: 1396, Name: "Breaking Bad", Added: true, Watched: []Episode{{1, 1}, {1, 2}}},
+			{ID: 2316, Name: "The Office, US", Added: true, Watched: []Episode{}},
+			{ID: 95396, Name: "Severance", Added: false, Watched: []Episode{{1, 1}}},
+		},
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, format := range []string{"json", "csv"} {
+		t.Run(format, func(t *testing.T) {
+			want := testExport()
+
+			var b bytes.Buffer
+			err := Write(&b, format, want)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := Read(&b)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// CSV only keeps the shows
+			if format == "csv" {
+				want.ExportedAt = ""
+				want.Email = ""
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Read() = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestReadRejects(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"newer json", `{"version": 99, "shows": []}`},
+		{"unversioned json", `{"shows": []}`},
+		{"newer csv", "# goshowtrack export version 99\nshow_id,name,added,season,episode\n"},
+		{"other csv", "title,year,season,episode\nSeverance,2022,1,1\n"},
+		{"bad episode", "# goshowtrack export version 1\nshow_id,name,added,season,episode\n1,Show,true,1,x\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Read(strings.NewReader(tt.input))
+			if err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestWriteUnknownFormat(t *testing.T) {
+	err := Write(&bytes.Buffer{}, "xml", testExport())
+	if err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
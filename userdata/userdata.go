@@ -0,0 +1,173 @@
+// Package userdata exports a user's shows and watched progress, and restores
+// them into the same or another instance.
+package userdata
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/jccroft1/goshowtrack/db"
+)
+
+// Version of the export format, bump it when fields are added so older
+// instances refuse exports they would only partially restore
+const Version = 1
+
+// Export is everything stored for a user. Shows are keyed on the provider's
+// show IDs so it can be restored into another instance.
+type Export struct {
+	Version    int    `json:"version"`
+	ExportedAt string `json:"exported_at"`
+	Email      string `json:"email"`
+	Shows      []Show `json:"shows"`
+}
+
+type Show struct {
+	ID   int    `json:"show_id"`
+	Name string `json:"name"`
+	// Added is false for shows removed from the list that still have progress
+	Added   bool      `json:"added"`
+	Watched []Episode `json:"watched"`
+}
+
+type Episode struct {
+	Season  int `json:"season"`
+	Episode int `json:"episode"`
+}
+
+// Result counts what a restore changed, rows that already existed aren't counted
+type Result struct {
+	Shows    int
+	Episodes int
+}
+
+// UserID finds the user with the email, creating them if create is set
+func UserID(email string, create bool) (int64, error) {
+	if create {
+		_, err := db.Connection.Exec(`INSERT INTO users (email) VALUES (?) ON CONFLICT DO NOTHING;`, email)
+		if err != nil {
+			return 0, fmt.Errorf("failed to create user: %v", err)
+		}
+	}
+
+	var userID int64
+	err := db.Connection.QueryRow(`SELECT id FROM users WHERE email = ?;`, email).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("no user with email %q", email)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to find user: %v", err)
+	}
+
+	return userID, nil
+}
+
+// Load reads the user's data from the database
+func Load(userID int64) (*Export, error) {
+	export := &Export{
+		Version:    Version,
+		ExportedAt: time.Now().UTC().Format(time.RFC3339),
+		Shows:      []Show{},
+	}
+
+	// there's no users row when auth is disabled
+	err := db.Connection.QueryRow(`SELECT email FROM users WHERE id = ?;`, userID).Scan(&export.Email)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to find user: %v", err)
+	}
+
+	shows := map[int]*Show{}
+	getShow := func(showID int) *Show {
+		show, ok := shows[showID]
+		if !ok {
+			show = &Show{ID: showID, Watched: []Episode{}}
+			shows[showID] = show
+		}
+		return show
+	}
+
+	rows, err := db.Connection.Query(`SELECT show_id FROM user_shows WHERE user_id = ?;`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load user shows: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var showID int
+		err = rows.Scan(&showID)
+		if err != nil {
+			return nil, err
+		}
+		getShow(showID).Added = true
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = db.Connection.Query(`SELECT show_id, season_number, episode_number FROM user_episodes
+		WHERE user_id = ? ORDER BY season_number, episode_number;`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load watched episodes: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var showID int
+		var e Episode
+		err = rows.Scan(&showID, &e.Season, &e.Episode)
+		if err != nil {
+			return nil, err
+		}
+		show := getShow(showID)
+		show.Watched = append(show.Watched, e)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, show := range shows {
+		// names are only to make the export readable, restores don't need them
+		_ = db.Connection.QueryRow(`SELECT name FROM shows WHERE show_id = ?;`, show.ID).Scan(&show.Name)
+		export.Shows = append(export.Shows, *show)
+	}
+	sort.Slice(export.Shows, func(i, j int) bool {
+		return export.Shows[i].ID < export.Shows[j].ID
+	})
+
+	return export, nil
+}
+
+// Restore merges the export into the user's data. Nothing is removed, so
+// restoring the same export twice has no effect the second time.
+func Restore(userID int64, export *Export) (Result, error) {
+	var result Result
+
+	tx, err := db.Connection.Begin()
+	if err != nil {
+		return result, err
+	}
+	defer tx.Rollback()
+
+	for _, show := range export.Shows {
+		if show.Added {
+			res, err := tx.Exec(`INSERT INTO user_shows (user_id, show_id) VALUES (?, ?) ON CONFLICT DO NOTHING;`, userID, show.ID)
+			if err != nil {
+				return result, fmt.Errorf("failed to restore show %d: %v", show.ID, err)
+			}
+			added, _ := res.RowsAffected()
+			result.Shows += int(added)
+		}
+
+		for _, e := range show.Watched {
+			res, err := tx.Exec(`INSERT INTO user_episodes (user_id, show_id, season_number, episode_number) VALUES (?, ?, ?, ?) ON CONFLICT DO NOTHING;`,
+				userID, show.ID, e.Season, e.Episode)
+			if err != nil {
+				return result, fmt.Errorf("failed to restore show %d progress: %v", show.ID, err)
+			}
+			added, _ := res.RowsAffected()
+			result.Episodes += int(added)
+		}
+	}
+
+	return result, tx.Commit()
+}
@@ -0,0 +1,112 @@
+package userdata
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/jccroft1/goshowtrack/db"
+	"github.com/jccroft1/goshowtrack/db/dbtest"
+)
+
+// rowCounts is how many shows and watched episodes the user has
+func rowCounts(t *testing.T, userID int64) Result {
+	t.Helper()
+
+	var counts Result
+	err := db.Connection.QueryRow(`SELECT COUNT(*) FROM user_shows WHERE user_id = ?;`, userID).Scan(&counts.Shows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Connection.QueryRow(`SELECT COUNT(*) FROM user_episodes WHERE user_id = ?;`, userID).Scan(&counts.Episodes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return counts
+}
+
+func TestRestore(t *testing.T) {
+	dbtest.Open(t)
+
+	from, err := UserID("from@localhost", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	to, err := UserID("to@localhost", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the shows table is empty so exports won't have names
+	want := testExport()
+	for i := range want.Shows {
+		want.Shows[i].Name = ""
+	}
+	result, err := Restore(from, want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != (Result{Shows: 2, Episodes: 3}) {
+		t.Errorf("first restore = %+v, want 2 shows and 3 episodes", result)
+	}
+
+	export, err := Load(from)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if export.Email != "from@localhost" || !reflect.DeepEqual(export.Shows, want.Shows) {
+		t.Fatalf("loaded %v %+v, want from@localhost %+v", export.Email, export.Shows, want.Shows)
+	}
+
+	result, err = Restore(to, export)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != (Result{Shows: 2, Episodes: 3}) {
+		t.Errorf("restore into another user = %+v, want 2 shows and 3 episodes", result)
+	}
+	restored, err := Load(to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(restored.Shows, export.Shows) {
+		t.Errorf("restored %+v, want %+v", restored.Shows, export.Shows)
+	}
+
+	counts := rowCounts(t, to)
+	result, err = Restore(to, export)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != (Result{}) {
+		t.Errorf("restoring twice = %+v, want nothing", result)
+	}
+	if got := rowCounts(t, to); got != counts {
+		t.Errorf("restoring twice changed the rows from %+v to %+v", counts, got)
+	}
+}
+
+func TestRestoreRemovedShow(t *testing.T) {
+	dbtest.Open(t)
+
+	userID, err := UserID("dev@localhost", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	removed := Show{ID: 95396, Added: false, Watched: []Episode{{1, 1}, {1, 2}}}
+	result, err := Restore(userID, &Export{Version: Version, Shows: []Show{removed}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != (Result{Episodes: 2}) {
+		t.Errorf("restore = %+v, want only 2 episodes", result)
+	}
+
+	export, err := Load(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(export.Shows, []Show{removed}) {
+		t.Errorf("loaded %+v, want the progress without the show on the list %+v", export.Shows, removed)
+	}
+}